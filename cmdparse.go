@@ -36,3 +36,14 @@ func checkHasFields(needFields []string, input map[string]string) []string {
 func printMissingFields(missingFields []string, w io.Writer) {
 	fmt.Fprintln(w, "Missing fields: "+strings.Join(missingFields, ","))
 }
+
+// splitList splits a comma-separated parameter, ignoring empty entries.
+func splitList(in string) []string {
+	var out []string
+	for _, s := range strings.Split(in, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
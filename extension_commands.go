@@ -1,14 +1,30 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"path"
 	"pushtart/config"
 	"pushtart/dnsserv"
+	"pushtart/tartmanager"
+	"pushtart/util"
+	"pushtart/webproxy"
 	"strconv"
 	"strings"
 )
 
+//extensionSettingOperations are extension operations which change server-wide settings, and so may only be run by admins.
+var extensionSettingOperations = map[string]bool{
+	"enable":              true,
+	"disable":             true,
+	"set-listener":        true,
+	"set-default-domain":  true,
+	"enable-recursion":    true,
+	"disable-recursion":   true,
+	"set-trusted-proxies": true,
+}
+
 func extensionCommand(params map[string]string, w io.Writer, user string) {
 	if params["operation"] == "show-config" {
 		listDnsservOptions(w)
@@ -22,11 +38,15 @@ func extensionCommand(params map[string]string, w io.Writer, user string) {
 		return
 	}
 
+	if (extensionSettingOperations[params["operation"]] || params["cache-size"] != "") && !checkIsAdmin(w, user) {
+		return
+	}
+
 	if strings.ToUpper(params["extension"]) == "DNSSERV" {
-		dnsservCommand(params, w)
+		dnsservCommand(params, w, user)
 	}
 	if strings.ToUpper(params["extension"]) == "HTTPPROXY" {
-		httpproxyCommand(params, w)
+		httpproxyCommand(params, w, user)
 	}
 }
 
@@ -35,9 +55,11 @@ func listHttpproxyOptions(w io.Writer) {
 	fmt.Fprintln(w, "\t Enabled = "+strconv.FormatBool(config.All().Web.Enabled))
 	fmt.Fprintln(w, "\t Listener = '"+config.All().Web.Listener+"'")
 	fmt.Fprintln(w, "\t DefaultDomain = '"+config.All().Web.DefaultDomain+"'")
+	fmt.Fprintln(w, "\t TrustedProxies = '"+strings.Join(config.All().Web.TrustedProxies, ",")+"'")
+	fmt.Fprintln(w, "\t TartSubdomains = "+strconv.FormatBool(config.All().Web.TartSubdomains))
 }
 
-func httpproxyCommand(params map[string]string, w io.Writer) {
+func httpproxyCommand(params map[string]string, w io.Writer, user string) {
 	if params["operation"] == "enable" {
 		config.All().Web.Enabled = true
 	}
@@ -57,8 +79,21 @@ func httpproxyCommand(params map[string]string, w io.Writer) {
 		return
 	}
 
+	if params["operation"] == "set-trusted-proxies" {
+		proxies := splitList(params["addresses"])
+		for i, addr := range proxies {
+			network, err := util.ParseCIDR(addr)
+			if err != nil {
+				fmt.Fprintln(w, "Err: "+err.Error())
+				return
+			}
+			proxies[i] = network.String()
+		}
+		config.All().Web.TrustedProxies = proxies
+	}
+
 	if params["operation"] == "set-domain-proxy" {
-		if !httpproxySetDomainProxy(params, w) {
+		if !httpproxySetDomainProxy(params, w, user) {
 			return
 		}
 	}
@@ -68,17 +103,68 @@ func httpproxyCommand(params map[string]string, w io.Writer) {
 			return
 		}
 		if config.All().Web.DomainProxies != nil {
+			if proxy, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]; ok {
+				if !checkCanModifyResource("domain proxy", params["domain"], proxy.OwnerUser, proxy.OwnerTart, w, user) {
+					return
+				}
+			}
 			delete(config.All().Web.DomainProxies, strings.ToLower(params["domain"]))
 		}
 	}
 
+	if params["operation"] == "set-static-site" {
+		if !httpproxySetStaticSite(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "set-error-pages" {
+		if !httpproxySetErrorPages(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "set-maintenance" {
+		if !httpproxySetMaintenance(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "add-upstream" {
+		if !httpproxyAddUpstream(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "remove-upstream" {
+		if !httpproxyRemoveUpstream(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "set-load-balancing" {
+		if !httpproxySetLoadBalancing(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "set-streaming-options" {
+		if !httpproxySetStreamingOptions(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "set-path-route" {
+		if !httpproxySetPathRoute(params, w, user) {
+			return
+		}
+	}
+	if params["operation"] == "delete-path-route" {
+		if !httpproxyDeletePathRoute(params, w, user) {
+			return
+		}
+	}
+
 	if params["operation"] == "add-authorization-rule" {
-		if !httpproxyAddAuthorizationRule(params, w) {
+		if !httpproxyAddAuthorizationRule(params, w, user) {
 			return
 		}
 	}
 	if params["operation"] == "remove-authorization-rule" {
-		if !httpproxyRemoveAuthorizationRule(params, w) {
+		if !httpproxyRemoveAuthorizationRule(params, w, user) {
 			return
 		}
 	}
@@ -86,111 +172,648 @@ func httpproxyCommand(params map[string]string, w io.Writer) {
 	config.Flush()
 }
 
-func httpproxySetDomainProxy(params map[string]string, w io.Writer) bool {
+func httpproxySetDomainProxy(params map[string]string, w io.Writer, user string) bool {
 	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "targetport", "targethost"}, params); len(missingFields) > 0 {
 		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-domain-proxy --domain <domain> --targethost <host> --targetport <port>")
 		printMissingFields(missingFields, w)
 		return false
 	}
 
+	if !checkProxyDomain(params["domain"], w) {
+		return false
+	}
+	if config.All().Web.DomainProxies == nil {
+		config.All().Web.DomainProxies = map[string]config.DomainProxy{}
+	}
+	port, err := strconv.Atoi(params["targetport"])
+	if err != nil {
+		fmt.Fprintln(w, "Err parsing port: "+err.Error())
+		return false
+	}
+
+	scheme := "http"
+	if params["scheme"] != "" {
+		scheme = params["scheme"]
+	}
+
+	ownerUser, ownerTart, ok := resourceOwnerFromParams(params, w, user)
+	if !ok {
+		return false
+	}
+	existing, exists := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if exists {
+		if !checkCanModifyResource("domain proxy", params["domain"], existing.OwnerUser, existing.OwnerTart, w, user) {
+			return false
+		}
+		if params["tart"] == "" { //keep existing ownership unless a new owning tart was specified
+			ownerUser, ownerTart = existing.OwnerUser, existing.OwnerTart
+		}
+	}
+
+	proxy := existing //auth rules, routes and other upstreams are kept
+	proxy.SetTarget(params["targethost"], port, scheme)
+	proxy.Static = nil
+	proxy.OwnerUser, proxy.OwnerTart = ownerUser, ownerTart
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = proxy
+	if ownerTart != "" { //balance across the tart's replicas, if it targets one
+		tartmanager.SyncReplicaUpstreams(ownerTart)
+	}
+	return true
+}
+
+//checkProxyDomain returns true if domain may be given a proxy, otherwise it writes an error and returns false.
+func checkProxyDomain(domain string, w io.Writer) bool {
+	if strings.Contains(domain, "*") && (!strings.HasPrefix(domain, "*.") || strings.Count(domain, "*") > 1 || !strings.Contains(domain[2:], ".")) {
+		fmt.Fprintln(w, "Err: Wildcard domains must be of the form *.example.com")
+		return false
+	}
+	return true
+}
+
+func httpproxySetErrorPages(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain"}, params); len(missingFields) > 0 || (params["tart"] == "" && strings.ToLower(params["builtin"]) != "yes") {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-error-pages --domain <domain> --tart <pushURL> [--dir <directory>]")
+		fmt.Fprintln(w, "       pushtart extension --extension HTTPProxy --operation set-error-pages --domain <domain> --builtin yes")
+		if len(missingFields) > 0 {
+			printMissingFields(missingFields, w)
+		}
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+
+	if strings.ToLower(params["builtin"]) == "yes" {
+		domEntry.ErrorPages = nil
+	} else {
+		_, pagesTart, ok := resourceOwnerFromParams(params, w, user)
+		if !ok {
+			return false
+		}
+		domEntry.ErrorPages = &config.ErrorPages{Tart: pagesTart, Dir: strings.Trim(path.Clean("/"+params["dir"]), "/")}
+	}
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxySetMaintenance(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "enabled"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-maintenance --domain <domain> --enabled yes/no [--retry-after <seconds>]")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+
+	if params["retry-after"] != "" {
+		retryAfter, err := strconv.Atoi(params["retry-after"])
+		if err != nil || retryAfter < 0 {
+			fmt.Fprintln(w, "Err: retry-after must be a number, 0 or more")
+			return false
+		}
+		domEntry.RetryAfter = retryAfter
+	}
+	domEntry.Maintenance = strings.ToLower(params["enabled"]) == "yes"
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxySetStaticSite(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "tart"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-static-site --domain <domain> --tart <pushURL> [--dir <directory>] [--spa yes/no] [--max-age <seconds>]")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	if !checkProxyDomain(params["domain"], w) {
+		return false
+	}
 	if config.All().Web.DomainProxies == nil {
 		config.All().Web.DomainProxies = map[string]config.DomainProxy{}
 	}
+	ownerUser, ownerTart, ok := resourceOwnerFromParams(params, w, user)
+	if !ok {
+		return false
+	}
+	existing, exists := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if exists && !checkCanModifyResource("domain proxy", params["domain"], existing.OwnerUser, existing.OwnerTart, w, user) {
+		return false
+	}
+
+	site := config.StaticSite{Tart: ownerTart}
+	if existing.Static != nil && existing.Static.Tart == ownerTart { //options which are not given are kept
+		site = *existing.Static
+	}
+	if _, ok := params["dir"]; ok {
+		site.Dir = strings.Trim(path.Clean("/"+params["dir"]), "/")
+	}
+	if params["spa"] != "" {
+		site.SPAFallback = strings.ToLower(params["spa"]) == "yes"
+	}
+	if params["max-age"] != "" {
+		maxAge, err := strconv.Atoi(params["max-age"])
+		if err != nil || maxAge < -1 {
+			fmt.Fprintln(w, "Err: max-age must be a number, -1 or more")
+			return false
+		}
+		site.MaxAge = maxAge
+	}
+
+	proxy := existing //auth rules and routes are kept
+	proxy.Static = &site
+	proxy.OwnerUser, proxy.OwnerTart = ownerUser, ownerTart
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = proxy
+	return true
+}
+
+func httpproxyAddUpstream(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "targetport", "targethost"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation add-upstream --domain <domain> --targethost <host> --targetport <port> [--scheme <scheme>] [--weight <n>]")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
 	port, err := strconv.Atoi(params["targetport"])
 	if err != nil {
 		fmt.Fprintln(w, "Err parsing port: "+err.Error())
 		return false
 	}
+	weight := 1
+	if params["weight"] != "" {
+		if weight, err = strconv.Atoi(params["weight"]); err != nil || weight < 1 {
+			fmt.Fprintln(w, "Err: --weight must be a positive number")
+			return false
+		}
+	}
+	scheme := "http"
+	if params["scheme"] != "" {
+		scheme = params["scheme"]
+	}
+
+	upstream := config.Upstream{Host: params["targethost"], Port: port, Scheme: scheme, Weight: weight}
+	domEntry.Upstreams = domEntry.Backends() //the target becomes the first upstream
+	if i := findUpstream(domEntry, upstream.Host, upstream.Port); i >= 0 {
+		domEntry.Upstreams[i] = upstream
+	} else {
+		domEntry.Upstreams = append(domEntry.Upstreams, upstream)
+	}
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxyRemoveUpstream(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "targetport", "targethost"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation remove-upstream --domain <domain> --targethost <host> --targetport <port>")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+	port, _ := strconv.Atoi(params["targetport"])
+	i := findUpstream(domEntry, params["targethost"], port)
+	switch {
+	case i < 0:
+		fmt.Fprintln(w, "Err: The domain proxy has no upstream "+params["targethost"]+":"+params["targetport"])
+		return false
+	case len(domEntry.Upstreams) <= 1:
+		fmt.Fprintln(w, "Err: Cannot remove the only upstream - use delete-domain-proxy instead")
+		return false
+	}
+
+	domEntry.Upstreams = append(domEntry.Upstreams[:i:i], domEntry.Upstreams[i+1:]...)
+	first := domEntry.Upstreams[0]
+	domEntry.TargetHost, domEntry.TargetPort, domEntry.TargetScheme = first.Host, first.Port, first.Scheme
+	if len(domEntry.Upstreams) == 1 && first.Weight <= 1 {
+		domEntry.Upstreams = nil
+	}
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxySetLoadBalancing(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "policy"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-load-balancing --domain <domain> --policy <policy>")
+		fmt.Fprintln(w, "\tPolicies: "+strings.Join(config.BalancePolicies, ", "))
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+	valid := false
+	for _, policy := range config.BalancePolicies {
+		valid = valid || policy == params["policy"]
+	}
+	if !valid {
+		fmt.Fprintln(w, "Err: Invalid policy - choose one of: "+strings.Join(config.BalancePolicies, ", "))
+		return false
+	}
 
+	domEntry.Balance = params["policy"]
+	if domEntry.Balance == config.BalanceRoundRobin {
+		domEntry.Balance = "" //the default
+	}
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxySetStreamingOptions(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain"}, params); len(missingFields) > 0 || (params["flush-interval"] == "" && params["idle-timeout"] == "") {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-streaming-options --domain <domain> [--flush-interval <milliseconds>] [--idle-timeout <seconds>]")
+		fmt.Fprintln(w, "\tStreaming responses are always flushed immediately; other responses are flushed at the interval, or after every write if it is -1. An idle timeout of 0 is 15 minutes, and -1 never.")
+		if len(missingFields) > 0 {
+			printMissingFields(missingFields, w)
+		}
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+
+	for _, option := range []struct {
+		param string
+		field *int
+	}{{"flush-interval", &domEntry.FlushInterval}, {"idle-timeout", &domEntry.IdleTimeout}} {
+		if params[option.param] == "" {
+			continue
+		}
+		value, err := strconv.Atoi(params[option.param])
+		if err != nil || value < -1 {
+			fmt.Fprintln(w, "Err: "+option.param+" must be a number, -1 or more")
+			return false
+		}
+		*option.field = value
+	}
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+//findUpstream returns the index of the upstream with the given host and port, or -1.
+func findUpstream(proxy config.DomainProxy, host string, port int) int {
+	for i, upstream := range proxy.Upstreams {
+		if upstream.Host == host && upstream.Port == port {
+			return i
+		}
+	}
+	return -1
+}
+
+func httpproxySetPathRoute(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "path", "targetport", "targethost"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation set-path-route --domain <domain> --path <prefix> --targethost <host> --targetport <port> [--scheme <scheme>] [--strip-prefix yes] [--position <n>]")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	prefix, err := normalisePathPrefix(params["path"])
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return false
+	}
+	port, err := strconv.Atoi(params["targetport"])
+	if err != nil {
+		fmt.Fprintln(w, "Err parsing port: "+err.Error())
+		return false
+	}
 	scheme := "http"
 	if params["scheme"] != "" {
 		scheme = params["scheme"]
 	}
 
-	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = config.DomainProxy{
+	ownerUser, ownerTart, ok := resourceOwnerFromParams(params, w, user)
+	if !ok {
+		return false
+	}
+	route := config.PathRoute{
+		Prefix:       prefix,
 		TargetHost:   params["targethost"],
 		TargetPort:   port,
 		TargetScheme: scheme,
+		StripPrefix:  params["strip-prefix"] == "yes",
+	}
+
+	routes := domEntry.Routes
+	position := len(routes)
+	if i := findPathRoute(domEntry, prefix); i >= 0 {
+		existing := routes[i]
+		if !checkCanModifyRoute(params["domain"], domEntry, existing, w, user) {
+			return false
+		}
+		if params["tart"] == "" { //keep existing ownership unless a new owning tart was specified
+			ownerUser, ownerTart = existing.OwnerUser, existing.OwnerTart
+		}
+		route.AuthRules = existing.AuthRules
+		routes = append(routes[:i:i], routes[i+1:]...)
+		position = i
+	} else if !checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user) {
+		return false
+	}
+	if params["position"] != "" {
+		if position, err = strconv.Atoi(params["position"]); err != nil || position < 1 {
+			fmt.Fprintln(w, "Err: --position must be a number, starting from 1")
+			return false
+		}
+		position--
+	}
+	if position > len(routes) {
+		position = len(routes)
 	}
+
+	route.OwnerUser, route.OwnerTart = ownerUser, ownerTart
+	domEntry.Routes = append(routes[:position:position], append([]config.PathRoute{route}, routes[position:]...)...)
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
+	return true
+}
+
+func httpproxyDeletePathRoute(params map[string]string, w io.Writer, user string) bool {
+	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "path"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation delete-path-route --domain <domain> --path <prefix>")
+		printMissingFields(missingFields, w)
+		return false
+	}
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok {
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return false
+	}
+	i := findPathRoute(domEntry, params["path"])
+	if i < 0 {
+		fmt.Fprintln(w, "Err: No path route for '"+params["path"]+"' on domain '"+params["domain"]+"'")
+		return false
+	}
+	if !checkCanModifyRoute(params["domain"], domEntry, domEntry.Routes[i], w, user) {
+		return false
+	}
+	domEntry.Routes = append(domEntry.Routes[:i:i], domEntry.Routes[i+1:]...)
+	config.All().Web.DomainProxies[strings.ToLower(params["domain"])] = domEntry
 	return true
 }
 
+//normalisePathPrefix returns the canonical form of a path route prefix, like /api.
+func normalisePathPrefix(prefix string) (string, error) {
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "?#") {
+		return "", errors.New("Path prefixes must start with '/', and cannot contain a query or fragment")
+	}
+	return path.Clean(prefix), nil
+}
+
+//findPathRoute returns the index of the route for prefix in the domain proxy, or -1 if there is not one.
+func findPathRoute(proxy config.DomainProxy, prefix string) int {
+	prefix, err := normalisePathPrefix(prefix)
+	if err != nil {
+		return -1
+	}
+	for i, route := range proxy.Routes {
+		if route.Prefix == prefix {
+			return i
+		}
+	}
+	return -1
+}
+
+//checkCanModifyRoute returns true if the calling user may modify a path route - as the owner of either the route or
+//its domain proxy - otherwise it writes an error and returns false.
+func checkCanModifyRoute(domain string, proxy config.DomainProxy, route config.PathRoute, w io.Writer, user string) bool {
+	if tartmanager.UserCanModifyResource(user, route.OwnerUser, route.OwnerTart) {
+		return true
+	}
+	return checkCanModifyResource("domain proxy", domain, proxy.OwnerUser, proxy.OwnerTart, w, user)
+}
+
+// resourceOwnerFromParams returns the owner to record against a new domain proxy or DNS record. If --tart is specified,
+// the resource is owned by that tart, which the calling user must be an owner of.
+func resourceOwnerFromParams(params map[string]string, w io.Writer, user string) (ownerUser, ownerTart string, ok bool) {
+	if params["tart"] == "" {
+		return user, "", true
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return "", "", false
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return "", "", false
+	}
+	return user, tart.PushURL, true
+}
+
+// checkCanModifyResource returns true if the calling user may modify a domain proxy or DNS record with the given owners,
+// otherwise it writes an error and returns false.
+func checkCanModifyResource(resourceType, domain, ownerUser, ownerTart string, w io.Writer, user string) bool {
+	if tartmanager.UserCanModifyResource(user, ownerUser, ownerTart) {
+		return true
+	}
+	fmt.Fprintln(w, "Err: The "+resourceType+" for '"+domain+"' is owned by "+describeOwner(ownerUser, ownerTart))
+	return false
+}
+
+func describeOwner(ownerUser, ownerTart string) string {
+	switch {
+	case ownerTart != "" && ownerUser != "":
+		return "tart " + ownerTart + " (created by " + ownerUser + ")"
+	case ownerTart != "":
+		return "tart " + ownerTart
+	case ownerUser != "":
+		return "user " + ownerUser
+	}
+	return "the console"
+}
+
 func lsProxyDomains(params map[string]string, w io.Writer, user string) {
 	for domain, obj := range config.All().Web.DomainProxies {
-		fmt.Fprintln(w, domain+": "+obj.TargetScheme+"://"+obj.TargetHost+":"+strconv.Itoa(obj.TargetPort))
+		if obj.Static != nil {
+			spa := ""
+			if obj.Static.SPAFallback {
+				spa = ", single-page app"
+			}
+			fmt.Fprintln(w, domain+": static site "+path.Join(obj.Static.Tart, obj.Static.Dir)+spa+" (owned by "+describeOwner(obj.OwnerUser, obj.OwnerTart)+")")
+		} else {
+			fmt.Fprintln(w, domain+": "+obj.TargetScheme+"://"+obj.TargetHost+":"+strconv.Itoa(obj.TargetPort)+" (owned by "+describeOwner(obj.OwnerUser, obj.OwnerTart)+")")
+		}
 		for _, authRule := range obj.AuthRules {
-			fmt.Fprintln(w, "\t"+authRule.RuleType+" "+authRule.Username)
+			fmt.Fprintln(w, "\t"+authRule.RuleType+" "+authRule.Username+authRule.Group+authRule.CIDR)
+		}
+		if obj.Static != nil {
+			//not proxied - no backends to show
+		} else if len(obj.Upstreams) > 0 {
+			balance := obj.Balance
+			if balance == "" {
+				balance = config.BalanceRoundRobin
+			}
+			fmt.Fprintln(w, "\tbalancing ("+balance+") between:")
+		}
+		for _, upstream := range obj.Backends() {
+			health, known := webproxy.BackendHealth(upstream)
+			if !known {
+				health = "no requests yet"
+			}
+			if len(obj.Upstreams) > 0 {
+				fmt.Fprintln(w, "\t\t"+upstream.Scheme+"://"+upstream.Host+":"+strconv.Itoa(upstream.Port)+" (weight "+strconv.Itoa(upstream.Weight)+"): "+health)
+			} else if known {
+				fmt.Fprintln(w, "\ttarget: "+health)
+			}
+		}
+		if obj.FlushInterval != 0 || obj.IdleTimeout != 0 {
+			fmt.Fprintln(w, "\tflush interval: "+strconv.Itoa(obj.FlushInterval)+"ms, idle timeout: "+strconv.Itoa(obj.IdleTimeout)+"s")
+		}
+		if obj.ErrorPages != nil {
+			fmt.Fprintln(w, "\terror pages: "+path.Join(obj.ErrorPages.Tart, obj.ErrorPages.Dir))
+		}
+		if obj.Maintenance {
+			fmt.Fprintln(w, "\tIN MAINTENANCE MODE")
+		}
+		for _, route := range obj.Routes {
+			strip := ""
+			if route.StripPrefix {
+				strip = ", prefix stripped"
+			}
+			fmt.Fprintln(w, "\troute "+route.Prefix+": "+route.TargetScheme+"://"+route.TargetHost+":"+strconv.Itoa(route.TargetPort)+strip+" (owned by "+describeOwner(route.OwnerUser, route.OwnerTart)+")")
+			for _, authRule := range route.AuthRules {
+				fmt.Fprintln(w, "\t\t"+authRule.RuleType+" "+authRule.Username+authRule.Group+authRule.CIDR)
+			}
 		}
 	}
 }
 
 func lsDNSDomains(params map[string]string, w io.Writer, user string) {
 	for domain, obj := range config.All().DNS.ARecord {
-		fmt.Fprintln(w, "Record Type A: "+domain+" => "+obj.Address+" (TTL="+strconv.Itoa(int(obj.TTL))+")")
+		fmt.Fprintln(w, "Record Type A: "+domain+" => "+obj.Address+" (TTL="+strconv.Itoa(int(obj.TTL))+", owned by "+describeOwner(obj.OwnerUser, obj.OwnerTart)+")")
 	}
 	for domain, obj := range config.All().DNS.AAAARecord {
-		fmt.Fprintln(w, "Record Type AAAA: "+domain+" => "+obj.Address+" (TTL="+strconv.Itoa(int(obj.TTL))+")")
+		fmt.Fprintln(w, "Record Type AAAA: "+domain+" => "+obj.Address+" (TTL="+strconv.Itoa(int(obj.TTL))+", owned by "+describeOwner(obj.OwnerUser, obj.OwnerTart)+")")
 	}
 }
 
-func httpproxyRemoveAuthorizationRule(params map[string]string, w io.Writer) bool {
+func httpproxyRemoveAuthorizationRule(params map[string]string, w io.Writer, user string) bool {
 	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "type"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation remove-authorization-rule --domain <domain> --type <type>")
-		fmt.Fprintln(w, "\tAvailable Types: ALLOW_ANY_USER,USR_DENY,USR_ALLOW")
-		fmt.Fprintln(w, "\tPass a username using --username for USR_ALLOW and USR_DENY")
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation remove-authorization-rule --domain <domain> --type <type> [--path <prefix>]")
+		fmt.Fprintln(w, "\tAvailable Types: ALLOW_ANY_USER,USR_DENY,USR_ALLOW,GRP_DENY,GRP_ALLOW,IP_DENY,IP_ALLOW")
+		fmt.Fprintln(w, "\tPass a username using --username for USR_ALLOW and USR_DENY, a group using --group for GRP_ALLOW and GRP_DENY, or an address or CIDR range using --cidr for IP_ALLOW and IP_DENY")
 		printMissingFields(missingFields, w)
 		return false
 	}
-	if _, ok := config.All().Web.DomainProxies[params["domain"]]; !ok { //make sure domain exists
-		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
-		return false
-	}
-	if !authRuleTypeValid(params, w) {
+	domEntry, routeIndex, ok := authRulesTarget(params, w, user)
+	if !ok {
 		return false
 	}
 
-	domEntry := config.All().Web.DomainProxies[params["domain"]]
-	for i := 0; i < len(domEntry.AuthRules); i++ {
-		existingEntry := domEntry.AuthRules[i]
-		if existingEntry.RuleType == params["type"] && existingEntry.Username == params["username"] {
-			domEntry.AuthRules = append(domEntry.AuthRules[:i], domEntry.AuthRules[i+1:]...)
-			config.All().Web.DomainProxies[params["domain"]] = domEntry
+	rules := domEntry.AuthRules
+	if routeIndex >= 0 {
+		rules = domEntry.Routes[routeIndex].AuthRules
+	}
+	for i := 0; i < len(rules); i++ {
+		existingEntry := rules[i]
+		if existingEntry.RuleType == params["type"] && existingEntry.Username == params["username"] && existingEntry.Group == params["group"] && existingEntry.CIDR == params["cidr"] {
+			saveAuthRules(strings.ToLower(params["domain"]), domEntry, routeIndex, append(rules[:i], rules[i+1:]...))
 			return true
 		}
 	}
-	fmt.Fprintln(w, "Err: No rules with that type/username.")
+	fmt.Fprintln(w, "Err: No rules with that type/username/group/cidr.")
 	return false
 }
 
-func httpproxyAddAuthorizationRule(params map[string]string, w io.Writer) bool {
+func httpproxyAddAuthorizationRule(params map[string]string, w io.Writer, user string) bool {
 	if missingFields := checkHasFields([]string{"extension", "operation", "domain", "type"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation add-authorization-rule --domain <domain> --type <type>")
-		fmt.Fprintln(w, "\tAvailable Types: ALLOW_ANY_USER,USR_DENY,USR_ALLOW")
-		fmt.Fprintln(w, "\tPass a username using --username for USR_ALLOW and USR_DENY")
+		fmt.Fprintln(w, "USAGE: pushtart extension --extension HTTPProxy --operation add-authorization-rule --domain <domain> --type <type> [--path <prefix>]")
+		fmt.Fprintln(w, "\tAvailable Types: ALLOW_ANY_USER,USR_DENY,USR_ALLOW,GRP_DENY,GRP_ALLOW,IP_DENY,IP_ALLOW")
+		fmt.Fprintln(w, "\tPass a username using --username for USR_ALLOW and USR_DENY, a group using --group for GRP_ALLOW and GRP_DENY, or an address or CIDR range using --cidr for IP_ALLOW and IP_DENY")
 		printMissingFields(missingFields, w)
 		return false
 	}
-	if _, ok := config.All().Web.DomainProxies[params["domain"]]; !ok { //make sure domain exists
-		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
-		return false
-	}
-	if !authRuleTypeValid(params, w) {
+	domEntry, routeIndex, ok := authRulesTarget(params, w, user)
+	if !ok {
 		return false
 	}
 	rule := config.AuthorizationRule{
 		RuleType: params["type"],
 		Username: params["username"],
+		Group:    params["group"],
+		CIDR:     params["cidr"],
+	}
+
+	rules := domEntry.AuthRules
+	if routeIndex >= 0 {
+		rules = domEntry.Routes[routeIndex].AuthRules
 	}
-	domEntry := config.All().Web.DomainProxies[params["domain"]]
-	if authorizationRuleExists(domEntry, rule) {
+	if authorizationRuleExists(rules, rule) {
 		fmt.Fprintln(w, "Err: An identical rule already exists")
 		return false
 	}
-	domEntry.AuthRules = append(domEntry.AuthRules, rule)
-	config.All().Web.DomainProxies[params["domain"]] = domEntry
+	saveAuthRules(strings.ToLower(params["domain"]), domEntry, routeIndex, append(rules, rule))
 	return true
 }
 
+//authRulesTarget validates an add or remove authorization rule command, returning the domain proxy it applies to, and
+//the index of the path route specified by --path (or -1, if the rule is for the domain itself).
+func authRulesTarget(params map[string]string, w io.Writer, user string) (config.DomainProxy, int, bool) {
+	domEntry, ok := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]
+	if !ok { //make sure domain exists
+		fmt.Fprintln(w, "Err: No domain proxy with domain '"+params["domain"]+"'")
+		return domEntry, -1, false
+	}
+	if !authRuleTypeValid(params, w) {
+		return domEntry, -1, false
+	}
+	if params["path"] == "" {
+		return domEntry, -1, checkCanModifyResource("domain proxy", params["domain"], domEntry.OwnerUser, domEntry.OwnerTart, w, user)
+	}
+
+	routeIndex := findPathRoute(domEntry, params["path"])
+	if routeIndex < 0 {
+		fmt.Fprintln(w, "Err: No path route for '"+params["path"]+"' on domain '"+params["domain"]+"'")
+		return domEntry, -1, false
+	}
+	return domEntry, routeIndex, checkCanModifyRoute(params["domain"], domEntry, domEntry.Routes[routeIndex], w, user)
+}
+
+func saveAuthRules(domain string, domEntry config.DomainProxy, routeIndex int, rules []config.AuthorizationRule) {
+	if routeIndex >= 0 {
+		domEntry.Routes[routeIndex].AuthRules = rules
+	} else {
+		domEntry.AuthRules = rules
+	}
+	config.All().Web.DomainProxies[domain] = domEntry
+}
+
 func authRuleTypeValid(params map[string]string, w io.Writer) bool {
 	switch params["type"] { //make sure type is a valid value
 	case "ALLOW_ANY_USER":
@@ -201,16 +824,36 @@ func authRuleTypeValid(params map[string]string, w io.Writer) bool {
 			fmt.Fprintln(w, "Err: Username not specified")
 			return false
 		}
+	case "GRP_ALLOW":
+		fallthrough
+	case "GRP_DENY":
+		if params["group"] == "" {
+			fmt.Fprintln(w, "Err: Group not specified")
+			return false
+		}
+	case "IP_ALLOW":
+		fallthrough
+	case "IP_DENY":
+		if params["cidr"] == "" {
+			fmt.Fprintln(w, "Err: Address range not specified")
+			return false
+		}
+		network, err := util.ParseCIDR(params["cidr"])
+		if err != nil {
+			fmt.Fprintln(w, "Err: "+err.Error())
+			return false
+		}
+		params["cidr"] = network.String() //normalised, so equivalent ranges are recognised as the same rule
 	default:
-		fmt.Fprintln(w, "Err: Invalid type - choose one of: ALLOW_ANY_USER,USR_DENY,USR_ALLOW")
+		fmt.Fprintln(w, "Err: Invalid type - choose one of: ALLOW_ANY_USER,USR_DENY,USR_ALLOW,GRP_DENY,GRP_ALLOW,IP_DENY,IP_ALLOW")
 		return false
 	}
 	return true
 }
 
-func authorizationRuleExists(proxy config.DomainProxy, rule config.AuthorizationRule) bool {
-	for _, r := range proxy.AuthRules {
-		if r.RuleType == rule.RuleType && r.Username == rule.Username {
+func authorizationRuleExists(rules []config.AuthorizationRule, rule config.AuthorizationRule) bool {
+	for _, r := range rules {
+		if r.RuleType == rule.RuleType && r.Username == rule.Username && r.Group == rule.Group && r.CIDR == rule.CIDR {
 			return true
 		}
 	}
@@ -225,7 +868,7 @@ func listDnsservOptions(w io.Writer) {
 	fmt.Fprintln(w, "\t Cache size = "+strconv.Itoa(config.All().DNS.LookupCacheSize))
 }
 
-func dnsservCommand(params map[string]string, w io.Writer) {
+func dnsservCommand(params map[string]string, w io.Writer, user string) {
 	if params["operation"] == "set-record" {
 		if missingFields := checkHasFields([]string{"extension", "operation", "type", "domain", "address", "ttl"}, params); len(missingFields) > 0 {
 			fmt.Fprintln(w, "USAGE: pushtart extension --extension DNSServ --operation set-record --type <DNS-record-type> --domain <domain> --address <ip-address> --ttl <expiry-seconds>")
@@ -274,9 +917,24 @@ func dnsservCommand(params map[string]string, w io.Writer) {
 				return
 			}
 
+			ownerUser, ownerTart, ok := resourceOwnerFromParams(params, w, user)
+			if !ok {
+				return
+			}
+			if existing, exists := config.All().DNS.ARecord[dnsserv.SanitizeDomain(params["domain"])]; exists {
+				if !checkCanModifyResource("DNS record", params["domain"], existing.OwnerUser, existing.OwnerTart, w, user) {
+					return
+				}
+				if params["tart"] == "" { //keep existing ownership unless a new owning tart was specified
+					ownerUser, ownerTart = existing.OwnerUser, existing.OwnerTart
+				}
+			}
+
 			config.All().DNS.ARecord[dnsserv.SanitizeDomain(params["domain"])] = config.ARecord{
-				Address: params["address"],
-				TTL:     uint32(ttl),
+				Address:   params["address"],
+				TTL:       uint32(ttl),
+				OwnerUser: ownerUser,
+				OwnerTart: ownerTart,
 			}
 		}
 	}
@@ -286,6 +944,11 @@ func dnsservCommand(params map[string]string, w io.Writer) {
 			return
 		}
 		if config.All().DNS.ARecord != nil {
+			if record, ok := config.All().DNS.ARecord[dnsserv.SanitizeDomain(params["domain"])]; ok {
+				if !checkCanModifyResource("DNS record", params["domain"], record.OwnerUser, record.OwnerTart, w, user) {
+					return
+				}
+			}
 			delete(config.All().DNS.ARecord, dnsserv.SanitizeDomain(params["domain"]))
 		}
 	}
@@ -307,15 +970,50 @@ func extensionHelp(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "")
 		fmt.Fprintln(w, "\tAdd reverse proxy: extension --extension HTTPProxy --operation set-domain-proxy --domain <domain> --targetport <destination-port> --scheme <destination-scheme> --targethost <host-field-at-destination>")
 		fmt.Fprintln(w, "\t - Scheme can be http or https. For most hosts domain and targethost will be identical.")
+		fmt.Fprintln(w, "\t - Pass --tart <pushURL> to make the proxy owned by a tart. Only owners of a proxy (or its tart) can change it.")
+		fmt.Fprintln(w, "\t - Domain can be a wildcard like *.apps.example.com, which covers every subdomain without a more specific proxy.")
 		fmt.Fprintln(w, "\tDelete reverse proxy: extension --extension HTTPProxy --operation delete-domain-proxy --domain <domain>")
 		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tAdd upstream: extension --extension HTTPProxy --operation add-upstream --domain <domain> --targetport <destination-port> --targethost <host> [--scheme <destination-scheme>] [--weight <n>]")
+		fmt.Fprintln(w, "\t - Requests are balanced between the proxy's target and its upstreams. Backends with connection errors are skipped for a while, and idempotent requests retried on another.")
+		fmt.Fprintln(w, "\tRemove upstream: extension --extension HTTPProxy --operation remove-upstream --domain <domain> --targethost <host> --targetport <port>")
+		fmt.Fprintln(w, "\tSet balancing policy: extension --extension HTTPProxy --operation set-load-balancing --domain <domain> --policy <"+strings.Join(config.BalancePolicies, "|")+">")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tTune streaming: extension --extension HTTPProxy --operation set-streaming-options --domain <domain> [--flush-interval <milliseconds>] [--idle-timeout <seconds>]")
+		fmt.Fprintln(w, "\t - WebSockets and other upgraded connections are tunnelled to the target, and closed once idle for the timeout (15 minutes by default). Streaming responses are flushed immediately, others at the flush interval.")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tServe static site: extension --extension HTTPProxy --operation set-static-site --domain <domain> --tart <pushURL> [--dir <directory>] [--spa yes/no] [--max-age <seconds>]")
+		fmt.Fprintln(w, "\t - Files are served straight from the tart's deployment (or a directory within it), without running the tart. With --spa yes, unknown paths serve the top-level index.html.")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tUse custom error pages: extension --extension HTTPProxy --operation set-error-pages --domain <domain> (--tart <pushURL> [--dir <directory>] | --builtin yes)")
+		fmt.Fprintln(w, "\t - Pages named 502.html, 503.html, 504.html and maintenance.html in the directory of the tart's deployment replace the built-in ones.")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tMaintenance mode: extension --extension HTTPProxy --operation set-maintenance --domain <domain> --enabled yes/no [--retry-after <seconds>]")
+		fmt.Fprintln(w, "\t - Every request is answered with a 503 maintenance page. Domains serving a tart also show it while a push to the tart is deployed, or if the tart is in maintenance mode (edit-tart --maintenance yes).")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tAdd path route: extension --extension HTTPProxy --operation set-path-route --domain <domain> --path <prefix> --targetport <destination-port> --targethost <host-field-at-destination> [--scheme <destination-scheme>] [--strip-prefix yes] [--position <n>]")
+		fmt.Fprintln(w, "\t - Requests under the prefix (like /api) go to this target instead. Routes are checked in order (--position, starting at 1), and the first match wins.")
+		fmt.Fprintln(w, "\t - Pass --path <prefix> to the authorization rule operations to give a route its own rules, which then apply instead of the domain's.")
+		fmt.Fprintln(w, "\tDelete path route: extension --extension HTTPProxy --operation delete-path-route --domain <domain> --path <prefix>")
+		fmt.Fprintln(w, "")
 		fmt.Fprintln(w, "\tAdd authorization rule: extension --extension HTTPProxy --operation add-authorization-rule --type USR_ALLOW --domain <domain> --username <username>")
 		fmt.Fprintln(w, "\t or,")
 		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type USR_DENY --domain testdomain --username <username>")
 		fmt.Fprintln(w, "\t or,")
+		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type GRP_ALLOW --domain testdomain --group <group>")
+		fmt.Fprintln(w, "\t or,")
+		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type GRP_DENY --domain testdomain --group <group>")
+		fmt.Fprintln(w, "\t or,")
+		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type IP_ALLOW --domain testdomain --cidr 10.0.0.0/8")
+		fmt.Fprintln(w, "\t or,")
+		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type IP_DENY --domain testdomain --cidr 10.1.2.3")
+		fmt.Fprintln(w, "\t or,")
 		fmt.Fprintln(w, "\t  extension --extension HTTPProxy --operation add-authorization-rule --type ALLOW_ANY_USER --domain testdomain")
-		fmt.Fprintln(w, "\tDelete authorization rule: extension --extension HTTPProxy --operation remove-authorization-rule --domain <domain> --type <rule-type> [--username <username>]")
-		fmt.Fprintln(w, "\t(If there are no rules set on a reverse proxy, ALLOW_ANY_USER is assumed.)")
+		fmt.Fprintln(w, "\tDelete authorization rule: extension --extension HTTPProxy --operation remove-authorization-rule --domain <domain> --type <rule-type> [--username <username>] [--group <group>] [--cidr <cidr>] [--path <prefix>]")
+		fmt.Fprintln(w, "\t(If there are no rules set on a reverse proxy, ALLOW_ANY_USER is assumed. IP_DENY rules are checked first, then USR_DENY and GRP_DENY, then ALLOW rules. IP_ALLOW grants access without logging in.)")
+		fmt.Fprintln(w, "")
+		fmt.Fprintln(w, "\tSet trusted proxies: extension --extension HTTPProxy --operation set-trusted-proxies --addresses <address-or-cidr>[,<address-or-cidr>...]")
+		fmt.Fprintln(w, "\t - X-Forwarded-For is only used to find the client address of requests from these. Omit --addresses to clear.")
 		fmt.Fprintln(w, "")
 
 	} else if strings.ToUpper(params["extension"]) == "DNSSERV" || params["extension"] == "" {
@@ -329,7 +1027,7 @@ func extensionHelp(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "")
 		fmt.Fprintln(w, "\tList our DNS Domains: ls-dns-domains")
 		fmt.Fprintln(w, "")
-		fmt.Fprintln(w, "\tAdd A record: extension --extension DNSServ --operation set-record --type A --domain <domain> --address <IP> --ttl <TTL-in-seconds>")
+		fmt.Fprintln(w, "\tAdd A record: extension --extension DNSServ --operation set-record --type A --domain <domain> --address <IP> --ttl <TTL-in-seconds> [--tart <owning-tart>]")
 		fmt.Fprintln(w, "\tDelete A record: extension --extension DNSServ --operation delete-record --domain <domain>")
 		fmt.Fprintln(w, "")
 	}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"pushtart/config"
+	"pushtart/user"
+	"sort"
+	"strings"
+)
+
+func makeGroup(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"group"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart make-group --group <group> [--description <description>]")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	if err := user.NewGroup(params["group"], params["description"]); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+	}
+}
+
+func deleteGroup(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"group"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart delete-group --group <group>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	if err := user.DeleteGroup(params["group"]); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+	}
+}
+
+func listGroups(params map[string]string, w io.Writer, callingUser string) {
+	var names []string
+	for name := range config.All().Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		group := config.All().Groups[name]
+		fmt.Fprint(w, name)
+		if group.Description != "" {
+			fmt.Fprint(w, " ("+group.Description+")")
+		}
+		fmt.Fprintln(w, ": ["+strings.Join(group.Members, ", ")+"]")
+	}
+}
+
+func groupAddMember(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"group", "username"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart group-add-member --group <group> --username <username>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	if err := user.AddGroupMember(params["group"], params["username"]); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+	}
+}
+
+func groupRemoveMember(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"group", "username"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart group-remove-member --group <group> --username <username>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	if err := user.RemoveGroupMember(params["group"], params["username"]); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+	}
+}
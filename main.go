@@ -5,12 +5,14 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"pushtart/audit"
 	"pushtart/config"
 	"pushtart/dnsserv"
 	"pushtart/logging"
 	"pushtart/sshserv"
 	"pushtart/sshserv/cmd_registry"
 	"pushtart/tartmanager"
+	"pushtart/user"
 	"pushtart/util"
 	"pushtart/webproxy"
 	"syscall"
@@ -29,24 +31,56 @@ func help(params map[string]string, w io.Writer, user string) {
 	fmt.Fprintln(w, "\tset-config-value --field <config-field> --value <new-value>")
 	fmt.Fprintln(w, " ")
 	if w == os.Stdout {
-		fmt.Fprintln(w, "\timport-ssh-key --username <username> [--pub-key-file <path-to-.pub-file>] (Not available from SSH shell)")
+		fmt.Fprintln(w, "\timport-ssh-key --username <username> [--pub-key-file <path-to-.pub-file>] [--label <label>] (Not available from SSH shell)")
 	}
-	fmt.Fprintln(w, "\tmake-user --username <username> [--password <password] [--name <name] [--allow-ssh-password yes/no]")
-	fmt.Fprintln(w, "\tedit-user --username <username> [--password <password] [--name <name] [--allow-ssh-password yes/no]")
+	fmt.Fprintln(w, "\tls-ssh-keys [--username <username>]")
+	fmt.Fprintln(w, "\tadd-ssh-key [--username <username>] --key \"<ssh-public-key>\" [--label <label>]")
+	fmt.Fprintln(w, "\tremove-ssh-key [--username <username>] --fingerprint <fingerprint>")
+	fmt.Fprintln(w, "\tenable-2fa [--username <username>]")
+	fmt.Fprintln(w, "\tdisable-2fa [--username <username>]")
+	fmt.Fprintln(w, " ")
+	fmt.Fprintln(w, "\tls-ssh-cas")
+	fmt.Fprintln(w, "\tadd-ssh-ca --label <label> --key \"<ca-public-key>\"")
+	fmt.Fprintln(w, "\tremove-ssh-ca --ca <ca-fingerprint>")
+	fmt.Fprintln(w, "\tssh-ca-map-principal --ca <ca-fingerprint> --principal <principal> [--username <username>]")
+	fmt.Fprintln(w, "\trevoke-ssh-cert --ca <ca-fingerprint> --serial <serial> | --key-fingerprint <fingerprint>")
+	fmt.Fprintln(w, "\tmake-user --username <username> [--password <password] [--name <name] [--allow-ssh-password yes/no] [--role admin/operator/developer]")
+	fmt.Fprintln(w, "\tedit-user --username <username> [--password <password] [--name <name] [--allow-ssh-password yes/no] [--role admin/operator/developer]")
 	fmt.Fprintln(w, "\tdelete-user --username <username>")
 
 	fmt.Fprintln(w, "\tls-users")
+	fmt.Fprintln(w, "\tmake-group --group <group> [--description <description>]")
+	fmt.Fprintln(w, "\tdelete-group --group <group>")
+	fmt.Fprintln(w, "\tls-groups")
+	fmt.Fprintln(w, "\tgroup-add-member --group <group> --username <username>")
+	fmt.Fprintln(w, "\tgroup-remove-member --group <group> --username <username>")
 	fmt.Fprintln(w, " ")
 	fmt.Fprintln(w, "\tls-tarts")
 	if w != os.Stdout {
 		fmt.Fprintln(w, "\tstart-tart --tart <pushURL>")
 		fmt.Fprintln(w, "\tstop-tart --tart <pushURL>")
 	}
-	fmt.Fprintln(w, "\tedit-tart --tart <pushURL>[--name <name>] [--set-env \"<name>=<value>\"] [--delete-env <name>] [--log-stdout yes/no]")
-	fmt.Fprintln(w, "\ttart-add-owner --tart <pushURL> --username <username>")
-	fmt.Fprintln(w, "\ttart-remove-owner --tart <pushURL> --username <username>")
+	fmt.Fprintln(w, "\tedit-tart --tart <pushURL>[--name <name>] [--set-env \"<name>=<value>\"] [--delete-env <name>] [--log-stdout yes/no] [--replicas <n>] [--maintenance yes/no]")
+	fmt.Fprintln(w, "\tdelete-tart --tart <pushURL> [--delete-owned yes/no]")
+	fmt.Fprintln(w, "\ttart-add-owner --tart <pushURL> --username <username> | --group <group>")
+	fmt.Fprintln(w, "\ttart-remove-owner --tart <pushURL> --username <username> | --group <group>")
+	fmt.Fprintln(w, "\ttart-add-reader --tart <pushURL> --username <username>")
+	fmt.Fprintln(w, "\ttart-remove-reader --tart <pushURL> --username <username>")
+	fmt.Fprintln(w, "\ttart-add-deploy-key --tart <pushURL> --label <label> --key \"<ssh-public-key>\"")
+	fmt.Fprintln(w, "\ttart-remove-deploy-key --tart <pushURL> --fingerprint <fingerprint>")
+	fmt.Fprintln(w, "\ttart-ls-deploy-keys --tart <pushURL>")
+	fmt.Fprintln(w, "\ttart-add-oidc-client --tart <pushURL> --label <label> --redirect-uri <uri>[,<uri>...]")
+	fmt.Fprintln(w, "\ttart-remove-oidc-client --tart <pushURL> --client-id <client-id>")
+	fmt.Fprintln(w, "\ttart-ls-oidc-clients --tart <pushURL>")
 	fmt.Fprintln(w, "\textension --extension <extension name> [command-specific-arguments...]")
-	fmt.Fprintln(w, "\tgenerate-api-key --service <service-name>")
+	fmt.Fprintln(w, "\tgenerate-api-key --service <service-name> --scopes <scope>[,<scope>...] [--tarts <pushURL>[,<pushURL>...]] [--expires <duration>] [--username <username>]")
+	fmt.Fprintln(w, "\tls-api-keys")
+	fmt.Fprintln(w, "\trevoke-api-key --id <key-id>")
+	fmt.Fprintln(w, "\taudit-log [--user <username>] [--actor <user/service/key-id>] [--source <source>] [--action <command>] [--tart <pushURL>] [--since <duration>] [--limit <count>]")
+	if w != os.Stdout {
+		fmt.Fprintln(w, "\tls-auth-blocks")
+		fmt.Fprintln(w, "\tclear-auth-block --address <ip-address> | --username <username> | --all yes")
+	}
 	fmt.Fprintln(w, "\textension-help [--extension <extension>]")
 
 	if w != os.Stdout {
@@ -72,6 +106,7 @@ func main() {
 			if err != nil {
 				logging.Error("main-sshserv", err.Error())
 			}
+			tartmanager.SyncAllSubdomains()
 			dnsserv.Init()
 			webproxy.Init()
 			go tartmanager.RunSentry()
@@ -105,6 +140,10 @@ func main() {
 			configInit(params["config"])
 			editTart(params, os.Stdout, "")
 
+		case "delete-tart":
+			configInit(params["config"])
+			deleteTart(params, os.Stdout, "")
+
 		case "tart-restart-mode":
 			configInit(params["config"])
 			tartRestartMode(params, os.Stdout, "")
@@ -113,6 +152,46 @@ func main() {
 			configInit(params["config"])
 			importSSHKey(params, os.Stdout, "")
 
+		case "ls-ssh-keys":
+			configInit(params["config"])
+			listSSHKeys(params, os.Stdout, "")
+
+		case "add-ssh-key":
+			configInit(params["config"])
+			addSSHKey(params, os.Stdout, "")
+
+		case "remove-ssh-key":
+			configInit(params["config"])
+			removeSSHKey(params, os.Stdout, "")
+
+		case "enable-2fa":
+			configInit(params["config"])
+			enable2FA(params, os.Stdout, "")
+
+		case "disable-2fa":
+			configInit(params["config"])
+			disable2FA(params, os.Stdout, "")
+
+		case "ls-ssh-cas":
+			configInit(params["config"])
+			listSSHCAs(params, os.Stdout, "")
+
+		case "add-ssh-ca":
+			configInit(params["config"])
+			addSSHCA(params, os.Stdout, "")
+
+		case "remove-ssh-ca":
+			configInit(params["config"])
+			removeSSHCA(params, os.Stdout, "")
+
+		case "ssh-ca-map-principal":
+			configInit(params["config"])
+			mapSSHCAPrincipal(params, os.Stdout, "")
+
+		case "revoke-ssh-cert":
+			configInit(params["config"])
+			revokeSSHCert(params, os.Stdout, "")
+
 		case "extension":
 			configInit(params["config"])
 			extensionCommand(params, os.Stdout, "")
@@ -133,6 +212,38 @@ func main() {
 			configInit(params["config"])
 			tartRemoveOwner(params, os.Stdout, "")
 
+		case "tart-add-reader":
+			configInit(params["config"])
+			tartAddReader(params, os.Stdout, "")
+
+		case "tart-remove-reader":
+			configInit(params["config"])
+			tartRemoveReader(params, os.Stdout, "")
+
+		case "tart-add-deploy-key":
+			configInit(params["config"])
+			tartAddDeployKey(params, os.Stdout, "")
+
+		case "tart-remove-deploy-key":
+			configInit(params["config"])
+			tartRemoveDeployKey(params, os.Stdout, "")
+
+		case "tart-ls-deploy-keys":
+			configInit(params["config"])
+			tartListDeployKeys(params, os.Stdout, "")
+
+		case "tart-add-oidc-client":
+			configInit(params["config"])
+			tartAddOIDCClient(params, os.Stdout, "")
+
+		case "tart-remove-oidc-client":
+			configInit(params["config"])
+			tartRemoveOIDCClient(params, os.Stdout, "")
+
+		case "tart-ls-oidc-clients":
+			configInit(params["config"])
+			tartListOIDCClients(params, os.Stdout, "")
+
 		case "digest-tartconfig":
 			configInit(params["config"])
 			digestTartConfig(params, os.Stdout, "")
@@ -153,40 +264,120 @@ func main() {
 			configInit(params["config"])
 			generateAPIKey(params, os.Stdout, "")
 
+		case "ls-api-keys":
+			configInit(params["config"])
+			listAPIKeys(params, os.Stdout, "")
+
+		case "revoke-api-key":
+			configInit(params["config"])
+			revokeAPIKey(params, os.Stdout, "")
+
 		case "delete-user":
 			configInit(params["config"])
 			deleteUser(params, os.Stdout, "")
 
+		case "make-group":
+			configInit(params["config"])
+			makeGroup(params, os.Stdout, "")
+
+		case "delete-group":
+			configInit(params["config"])
+			deleteGroup(params, os.Stdout, "")
+
+		case "ls-groups":
+			configInit(params["config"])
+			listGroups(params, os.Stdout, "")
+
+		case "group-add-member":
+			configInit(params["config"])
+			groupAddMember(params, os.Stdout, "")
+
+		case "group-remove-member":
+			configInit(params["config"])
+			groupRemoveMember(params, os.Stdout, "")
+
 		case "extension-help":
 			configInit(params["config"])
 			extensionHelp(params, os.Stdout, "")
+
+		case "audit-log":
+			configInit(params["config"])
+			auditLog(params, os.Stdout, "")
+
+		default:
+			return
+		}
+
+		if os.Args[1] != "run" {
+			auditConsoleCommand(os.Args[1], params)
 		}
 	}
 }
 
 func registerCommands() {
-	cmd_registry.Register("make-user", makeUser)
-	cmd_registry.Register("edit-user", editUser)
-	cmd_registry.Register("ls-users", listUser)
-	cmd_registry.Register("ls-tarts", listTarts)
-	cmd_registry.Register("start-tart", startTart)
-	cmd_registry.Register("stop-tart", stopTart)
-	cmd_registry.Register("edit-tart", editTart)
-	cmd_registry.Register("help", help)
-	cmd_registry.Register("logs", logMsgs)
-	cmd_registry.Register("tart-restart-mode", tartRestartMode)
-	cmd_registry.Register("extension", extensionCommand)
-	cmd_registry.Register("get-config-value", getConfigValue)
-	cmd_registry.Register("set-config-value", setConfigValue)
-	cmd_registry.Register("tart-add-owner", tartAddOwner)
-	cmd_registry.Register("tart-remove-owner", tartRemoveOwner)
-	cmd_registry.Register("digest-tartconfig", digestTartConfig)
-	cmd_registry.Register("ls-domain-proxies", lsProxyDomains)
-	cmd_registry.Register("ls-dns-domains", lsDNSDomains)
-	cmd_registry.Register("new-tart", newTart)
-	cmd_registry.Register("generate-api-key", generateAPIKey)
-	cmd_registry.Register("delete-user", deleteUser)
-	cmd_registry.Register("extension-help", extensionHelp)
+	cmd_registry.Register("make-user", user.RoleAdmin, makeUser)
+	cmd_registry.Register("edit-user", user.RoleAdmin, editUser)
+	cmd_registry.Register("ls-users", user.RoleOperator, listUser)
+	cmd_registry.Register("ls-ssh-keys", user.RoleDeveloper, listSSHKeys)
+	cmd_registry.Register("add-ssh-key", user.RoleDeveloper, addSSHKey)
+	cmd_registry.Register("remove-ssh-key", user.RoleDeveloper, removeSSHKey)
+	cmd_registry.Register("enable-2fa", user.RoleDeveloper, enable2FA)
+	cmd_registry.Register("disable-2fa", user.RoleDeveloper, disable2FA)
+	cmd_registry.Register("ls-ssh-cas", user.RoleAdmin, listSSHCAs)
+	cmd_registry.Register("add-ssh-ca", user.RoleAdmin, addSSHCA)
+	cmd_registry.Register("remove-ssh-ca", user.RoleAdmin, removeSSHCA)
+	cmd_registry.Register("ssh-ca-map-principal", user.RoleAdmin, mapSSHCAPrincipal)
+	cmd_registry.Register("revoke-ssh-cert", user.RoleAdmin, revokeSSHCert)
+	cmd_registry.Register("ls-tarts", user.RoleDeveloper, listTarts)
+	cmd_registry.Register("start-tart", user.RoleDeveloper, startTart)
+	cmd_registry.Register("stop-tart", user.RoleDeveloper, stopTart)
+	cmd_registry.Register("edit-tart", user.RoleDeveloper, editTart)
+	cmd_registry.Register("delete-tart", user.RoleDeveloper, deleteTart)
+	cmd_registry.Register("help", user.RoleDeveloper, help)
+	cmd_registry.Register("logs", user.RoleOperator, logMsgs)
+	cmd_registry.Register("tart-restart-mode", user.RoleDeveloper, tartRestartMode)
+	cmd_registry.Register("extension", user.RoleDeveloper, extensionCommand)
+	cmd_registry.Register("get-config-value", user.RoleAdmin, getConfigValue)
+	cmd_registry.Register("set-config-value", user.RoleAdmin, setConfigValue)
+	cmd_registry.Register("tart-add-owner", user.RoleDeveloper, tartAddOwner)
+	cmd_registry.Register("tart-remove-owner", user.RoleDeveloper, tartRemoveOwner)
+	cmd_registry.Register("tart-add-reader", user.RoleDeveloper, tartAddReader)
+	cmd_registry.Register("tart-remove-reader", user.RoleDeveloper, tartRemoveReader)
+	cmd_registry.Register("tart-add-deploy-key", user.RoleDeveloper, tartAddDeployKey)
+	cmd_registry.Register("tart-remove-deploy-key", user.RoleDeveloper, tartRemoveDeployKey)
+	cmd_registry.Register("tart-ls-deploy-keys", user.RoleDeveloper, tartListDeployKeys)
+	cmd_registry.Register("tart-add-oidc-client", user.RoleDeveloper, tartAddOIDCClient)
+	cmd_registry.Register("tart-remove-oidc-client", user.RoleDeveloper, tartRemoveOIDCClient)
+	cmd_registry.Register("tart-ls-oidc-clients", user.RoleDeveloper, tartListOIDCClients)
+	cmd_registry.Register("digest-tartconfig", user.RoleDeveloper, digestTartConfig)
+	cmd_registry.Register("ls-domain-proxies", user.RoleDeveloper, lsProxyDomains)
+	cmd_registry.Register("ls-dns-domains", user.RoleDeveloper, lsDNSDomains)
+	cmd_registry.Register("new-tart", user.RoleDeveloper, newTart)
+	cmd_registry.Register("generate-api-key", user.RoleAdmin, generateAPIKey)
+	cmd_registry.Register("ls-api-keys", user.RoleAdmin, listAPIKeys)
+	cmd_registry.Register("revoke-api-key", user.RoleAdmin, revokeAPIKey)
+	cmd_registry.Register("delete-user", user.RoleAdmin, deleteUser)
+	cmd_registry.Register("make-group", user.RoleAdmin, makeGroup)
+	cmd_registry.Register("delete-group", user.RoleAdmin, deleteGroup)
+	cmd_registry.Register("ls-groups", user.RoleOperator, listGroups)
+	cmd_registry.Register("group-add-member", user.RoleAdmin, groupAddMember)
+	cmd_registry.Register("group-remove-member", user.RoleAdmin, groupRemoveMember)
+	cmd_registry.Register("extension-help", user.RoleDeveloper, extensionHelp)
+	cmd_registry.Register("audit-log", user.RoleAdmin, auditLog)
+	cmd_registry.Register("ls-auth-blocks", user.RoleAdmin, listAuthBlocks)
+	cmd_registry.Register("clear-auth-block", user.RoleAdmin, clearAuthBlock)
+
+	cmd_registry.MarkReadOnly("help", "logs", "ls-users", "ls-groups", "ls-ssh-keys", "ls-ssh-cas", "ls-tarts", "tart-ls-deploy-keys", "tart-ls-oidc-clients",
+		"ls-domain-proxies", "ls-dns-domains", "get-config-value", "extension-help", "ls-api-keys", "audit-log", "ls-auth-blocks")
+}
+
+// auditConsoleCommand records a command run from the command line in the audit log, unless it is read-only.
+// Output is written directly to the terminal, so the result is not known.
+func auditConsoleCommand(cmd string, params map[string]string) {
+	registerCommands()
+	if cmd_registry.Audited(cmd) || cmd == "import-ssh-key" {
+		audit.Record(audit.Entry{Actor: audit.Actor{Source: audit.SourceConsole}, Action: cmd, Args: params, Result: "completed"})
+	}
 }
 
 // configInit loads the configuration file from the command line. If there was an error loading the file, a default configuration
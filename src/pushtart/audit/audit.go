@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"pushtart/config"
+	"pushtart/constants"
+	"pushtart/logging"
+	"sync"
+	"time"
+)
+
+//Sources an action may be performed from.
+const (
+	SourceConsole    = "console"    //The pushtart command line.
+	SourceShell      = "shell"      //The SSH management shell.
+	SourceExec       = "exec"       //A command run over SSH without a shell, such as import-ssh-key.
+	SourceRPC        = "rpc"        //The JSON-RPC interface.
+	SourceTartconfig = "tartconfig" //A tartconfig file run after a git push.
+	SourceGit        = "git"        //A git push.
+)
+
+//Actor identifies who performed an action.
+type Actor struct {
+	Source  string //One of the Source* constants.
+	User    string `json:",omitempty"` //User the action was performed as. Empty for the console, and API keys without a user.
+	Service string `json:",omitempty"` //Service name of the API key used, for RPC.
+	KeyID   string `json:",omitempty"` //ID of the API key used, for RPC.
+	Tart    string `json:",omitempty"` //Tart whose tartconfig file (or deploy key) performed the action.
+}
+
+//String returns a human-readable description of the actor.
+func (a Actor) String() string {
+	switch {
+	case a.Service != "" && a.User != "":
+		return "api key " + a.Service + " (" + a.KeyID + ") as " + a.User
+	case a.Service != "":
+		return "api key " + a.Service + " (" + a.KeyID + ")"
+	case a.Source == SourceTartconfig:
+		return "tartconfig of " + a.Tart + " (pushed by " + a.User + ")"
+	case a.Tart != "":
+		return "deploy key of " + a.Tart + " (as " + a.User + ")"
+	case a.User == "":
+		return "console"
+	}
+	return "user " + a.User
+}
+
+//Entry represents a single action in the audit log.
+type Entry struct {
+	Time   int64
+	Actor  Actor
+	Action string //Command, RPC method, or git-push.
+	Tart   string `json:",omitempty"` //Tart the action concerned, if any.
+	Args   map[string]string
+	Result string //"ok", "denied", or a description of the error.
+}
+
+var lock sync.Mutex
+
+func logPath() string {
+	if p := config.All().AuditLogPath; p != "" {
+		return p
+	}
+	return path.Join(path.Dir(config.All().Path), constants.DefaultAuditLogFileName)
+}
+
+//Record appends an entry to the audit log. Secrets in the arguments are redacted, and the time is set if missing.
+func Record(e Entry) {
+	if e.Time == 0 {
+		e.Time = time.Now().Unix()
+	}
+	e.Args = Redact(e.Action, e.Args)
+	if e.Tart == "" {
+		e.Tart = tartFromArgs(e.Args)
+	}
+
+	d, err := json.Marshal(e)
+	if err != nil {
+		logging.Error("audit", "Could not encode entry: "+err.Error())
+		return
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	f, err := os.OpenFile(logPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		logging.Error("audit", "Could not open audit log: "+err.Error())
+		return
+	}
+	defer f.Close()
+	if _, err = f.Write(append(d, '\n')); err != nil {
+		logging.Error("audit", "Could not write audit log: "+err.Error())
+	}
+}
+
+func tartFromArgs(args map[string]string) string {
+	if args["tart"] != "" {
+		return args["tart"]
+	}
+	return args["PushURL"]
+}
+
+//Filter selects entries from the audit log. Empty fields match any entry.
+type Filter struct {
+	User   string
+	Actor  string //Matches API key service names and IDs, as well as users.
+	Source string
+	Action string
+	Tart   string
+	Since  int64 //Unix timestamp.
+	Limit  int   //Maximum number of (most recent) entries to return, or 0 for no limit.
+}
+
+func (f Filter) matches(e Entry) bool {
+	switch {
+	case f.User != "" && e.Actor.User != f.User:
+		return false
+	case f.Actor != "" && e.Actor.User != f.Actor && e.Actor.Service != f.Actor && e.Actor.KeyID != f.Actor:
+		return false
+	case f.Source != "" && e.Actor.Source != f.Source:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Tart != "" && e.Tart != f.Tart && e.Actor.Tart != f.Tart:
+		return false
+	case e.Time < f.Since:
+		return false
+	}
+	return true
+}
+
+//Query returns the entries in the audit log which match the filter, oldest first.
+func Query(f Filter) ([]Entry, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	file, err := os.Open(logPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var out []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logging.Warning("audit", "Skipping malformed audit log entry: "+err.Error())
+			continue
+		}
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out, scanner.Err()
+}
@@ -0,0 +1,38 @@
+package audit
+
+import "strings"
+
+const redacted = "<redacted>"
+
+//sensitiveWords mark an argument (or configuration field) as secret if its name contains one of them.
+var sensitiveWords = []string{"password", "secret", "token", "apikey", "privpem", "privatekey"}
+
+func isSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range sensitiveWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+//Redact returns a copy of the arguments to the given action, with secrets removed. Environment variable values
+//are always redacted, as are configuration values for sensitive fields.
+func Redact(action string, args map[string]string) map[string]string {
+	out := map[string]string{}
+	for name, value := range args {
+		switch {
+		case name == "config" || strings.EqualFold(name, "APIKey"):
+			continue
+		case isSensitive(name):
+			value = redacted
+		case name == "set-env":
+			value = strings.SplitN(value, "=", 2)[0] + "=" + redacted
+		case strings.EqualFold(name, "value") && (action == "Tarts.SetEnv" || isSensitive(args["field"]+args["Field"])):
+			value = redacted
+		}
+		out[name] = value
+	}
+	return out
+}
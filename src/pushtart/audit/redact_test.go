@@ -0,0 +1,37 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		args   map[string]string
+		want   map[string]string
+	}{
+		{"nothing secret", "edit-tart", map[string]string{"tart": "/a/app", "name": "App"}, map[string]string{"tart": "/a/app", "name": "App"}},
+		{"password", "make-user", map[string]string{"username": "alice", "password": "hunter2"}, map[string]string{"username": "alice", "password": redacted}},
+		{"secret names in any case", "tart-add-oidc-client", map[string]string{"ClientSecret": "s", "refresh-TOKEN": "t", "PrivPEM": "k"}, map[string]string{"ClientSecret": redacted, "refresh-TOKEN": redacted, "PrivPEM": redacted}},
+		{"API key of an RPC call is dropped", "Tarts.Start", map[string]string{"APIKey": "key", "PushURL": "/a/app"}, map[string]string{"PushURL": "/a/app"}},
+		{"config path is dropped", "edit-tart", map[string]string{"config": "/etc/pushtart.json", "tart": "/a/app"}, map[string]string{"tart": "/a/app"}},
+		{"env value from the command line", "edit-tart", map[string]string{"set-env": "DB_URL=postgres://u:p@db/app"}, map[string]string{"set-env": "DB_URL=" + redacted}},
+		{"env without a value", "edit-tart", map[string]string{"set-env": "DEBUG"}, map[string]string{"set-env": "DEBUG=" + redacted}},
+		{"env value from RPC", "Tarts.SetEnv", map[string]string{"Key": "DB_URL", "Value": "postgres://u:p@db/app"}, map[string]string{"Key": "DB_URL", "Value": redacted}},
+		{"sensitive config field", "set-config-value", map[string]string{"field": "TLS.PrivPEM", "value": "-----BEGIN"}, map[string]string{"field": "TLS.PrivPEM", "value": redacted}},
+		{"other config field", "set-config-value", map[string]string{"field": "Name", "value": "pushtart"}, map[string]string{"field": "Name", "value": "pushtart"}},
+	}
+	for _, test := range tests {
+		if got := Redact(test.action, test.args); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: Redact() = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	args := map[string]string{"password": "hunter2"}
+	Redact("make-user", args)
+	if args["password"] != "hunter2" {
+		t.Error("Redact() modified the arguments it was given")
+	}
+}
@@ -0,0 +1,196 @@
+package authlimit
+
+import (
+	"errors"
+	"net"
+	"pushtart/logging"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//ErrBlocked is returned by Check if the remote address or username is temporarily locked out.
+var ErrBlocked = errors.New("Too many failed authentication attempts - try again later")
+
+const (
+	freeAttempts = 3                //Failures before further failures start to be delayed.
+	maxDelay     = 8 * time.Second  //Upper bound of the delay applied after each failure.
+	failureTTL   = 15 * time.Minute //Failures are forgotten this long after the most recent one.
+	LockoutTime  = 15 * time.Minute //How long an address or username is blocked for once it reaches its limit.
+
+	maxAddressFailures  = 10 //Failures from a single address before it is blocked.
+	maxUsernameFailures = 20 //Failures for a single username (from any address) before password logins for it are blocked.
+
+	maxTracked = 10000 //Expired entries are pruned once this many addresses or usernames are being tracked.
+)
+
+//Kinds of Block.
+const (
+	KindAddress  = "address"
+	KindUsername = "username"
+)
+
+type counter struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func (c *counter) expired(now time.Time) bool {
+	return now.Sub(c.lastFailure) > failureTTL && now.After(c.lockedUntil)
+}
+
+var (
+	lock      sync.Mutex
+	addresses = map[string]*counter{}
+	usernames = map[string]*counter{}
+)
+
+//Host returns the host part of a remote address (host:port) string, which is used to track failures by address.
+func Host(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+//Check returns ErrBlocked if the address or username is locked out. An empty username only checks the address.
+func Check(address, username string) error {
+	lock.Lock()
+	defer lock.Unlock()
+	now := time.Now()
+
+	for _, c := range []*counter{addresses[address], usernames[username]} {
+		if c != nil && now.Before(c.lockedUntil) {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+//Failure records a failed authentication attempt, blocking the address or username if it has reached its limit.
+//Service describes what was being authenticated to, for logging. Once there have been a few recent failures,
+//Failure sleeps for a delay which grows with each one, slowing down guessing.
+func Failure(address, username, service string) {
+	lock.Lock()
+	now := time.Now()
+	if len(addresses) > maxTracked || len(usernames) > maxTracked {
+		prune(now)
+	}
+	failures := recordFailure(addresses, KindAddress, address, maxAddressFailures, service, now)
+	if username != "" {
+		if f := recordFailure(usernames, KindUsername, username, maxUsernameFailures, service, now); f > failures {
+			failures = f
+		}
+	}
+	lock.Unlock()
+
+	if failures > freeAttempts {
+		delay := time.Second << uint(failures-freeAttempts-1)
+		if delay > maxDelay || delay <= 0 {
+			delay = maxDelay
+		}
+		time.Sleep(delay)
+	}
+}
+
+func recordFailure(counters map[string]*counter, kind, key string, limit int, service string, now time.Time) int {
+	c := counters[key]
+	if c == nil || c.expired(now) {
+		c = &counter{}
+		counters[key] = c
+	}
+	c.failures++
+	c.lastFailure = now
+	if c.failures >= limit && now.After(c.lockedUntil) {
+		c.lockedUntil = now.Add(LockoutTime)
+		logging.Warning("authlimit", "Blocked "+kind+" "+key+" for "+LockoutTime.String()+" after "+strconv.Itoa(c.failures)+" failed "+service+" logins")
+	}
+	return c.failures
+}
+
+//Success records a successful authentication, forgetting past failures for the username.
+func Success(username string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(usernames, username)
+}
+
+func prune(now time.Time) {
+	for _, counters := range []map[string]*counter{addresses, usernames} {
+		for key, c := range counters {
+			if c.expired(now) {
+				delete(counters, key)
+			}
+		}
+	}
+}
+
+//Block describes an address or username which has recently failed to authenticate.
+type Block struct {
+	Kind        string //KindAddress or KindUsername.
+	Key         string //The address or username.
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time //Zero, or in the past, if it is not currently blocked.
+}
+
+//Blocked returns true if the block is currently in effect.
+func (b Block) Blocked() bool {
+	return time.Now().Before(b.LockedUntil)
+}
+
+//List returns every address and username with recent failures, sorted by kind and key.
+func List() []Block {
+	lock.Lock()
+	defer lock.Unlock()
+	now := time.Now()
+	prune(now)
+
+	var out []Block
+	for kind, counters := range map[string]map[string]*counter{KindAddress: addresses, KindUsername: usernames} {
+		for key, c := range counters {
+			out = append(out, Block{Kind: kind, Key: key, Failures: c.failures, LastFailure: c.lastFailure, LockedUntil: c.lockedUntil})
+		}
+	}
+	sort.Sort(byKindAndKey(out))
+	return out
+}
+
+type byKindAndKey []Block
+
+func (b byKindAndKey) Len() int      { return len(b) }
+func (b byKindAndKey) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byKindAndKey) Less(i, j int) bool {
+	if b[i].Kind != b[j].Kind {
+		return b[i].Kind < b[j].Kind
+	}
+	return b[i].Key < b[j].Key
+}
+
+//Clear forgets the failures (and lifts any block) of the given address or username. False is returned if there were none.
+func Clear(kind, key string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	counters := addresses
+	if kind == KindUsername {
+		counters = usernames
+	}
+	_, ok := counters[key]
+	delete(counters, key)
+	if ok {
+		logging.Info("authlimit", "Cleared failures for "+kind+" "+key)
+	}
+	return ok
+}
+
+//ClearAll forgets all failures and lifts all blocks.
+func ClearAll() {
+	lock.Lock()
+	defer lock.Unlock()
+	addresses = map[string]*counter{}
+	usernames = map[string]*counter{}
+	logging.Info("authlimit", "Cleared all failures")
+}
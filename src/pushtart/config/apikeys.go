@@ -0,0 +1,88 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"pushtart/logging"
+	"time"
+)
+
+//Scopes an API key may be granted. The admin scope includes all the others.
+const (
+	APIKeyScopeReadOnly    = "read-only"    //List and inspect tarts and DNS records.
+	APIKeyScopeTartControl = "tart-control" //Create, start, stop and configure tarts.
+	APIKeyScopeDNS         = "dns"          //Set and delete DNS records.
+	APIKeyScopeAdmin       = "admin"        //Run commands, and read and change the configuration.
+)
+
+//APIKeyScopes lists every valid API key scope.
+var APIKeyScopes = []string{APIKeyScopeReadOnly, APIKeyScopeTartControl, APIKeyScopeDNS, APIKeyScopeAdmin}
+
+//ValidAPIKeyScope returns true if scope is one of APIKeyScopes.
+func ValidAPIKeyScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//HashAPIKey returns the value stored in APIKey.KeyHash for the given key.
+func HashAPIKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+//MatchesKey returns true if key is the key this APIKey was generated for.
+func (k APIKey) MatchesKey(key string) bool {
+	return subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(HashAPIKey(key))) == 1
+}
+
+//Expired returns true if the key has an expiry which has passed.
+func (k APIKey) Expired() bool {
+	return k.Expires != 0 && time.Now().Unix() > k.Expires
+}
+
+//HasScope returns true if the key has been granted the given scope, or the admin scope.
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == APIKeyScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+//CanAccessTart returns true if the key has no tart allowlist, or pushURL is on it.
+func (k APIKey) CanAccessTart(pushURL string) bool {
+	if len(k.Tarts) == 0 {
+		return true
+	}
+	for _, t := range k.Tarts {
+		if t == pushURL {
+			return true
+		}
+	}
+	return false
+}
+
+//migrateAPIKeys hashes API keys stored in plaintext. As keys were previously unscoped, they are given the admin scope.
+func migrateAPIKeys(conf *Config) {
+	for i, key := range conf.APIKeys {
+		if key.Key == "" {
+			continue
+		}
+		key.KeyHash = HashAPIKey(key.Key)
+		key.Key = ""
+		if key.ID == "" {
+			key.ID = key.KeyHash[:8] //stable across loads until the configuration is next flushed
+		}
+		if len(key.Scopes) == 0 {
+			key.Scopes = []string{APIKeyScopeAdmin}
+		}
+		conf.APIKeys[i] = key
+		logging.Info("config", "Migrated API key for "+key.Service+" to "+key.ID)
+	}
+}
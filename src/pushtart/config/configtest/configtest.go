@@ -0,0 +1,35 @@
+//Package configtest loads a throwaway global configuration for the tests of packages which read it.
+package configtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"pushtart/config"
+	"testing"
+)
+
+//Run loads an empty configuration from a temporary directory (which is also the data and deployment path), runs
+//the tests of a package and exits. It should be called from TestMain.
+func Run(m *testing.M) {
+	dir, err := ioutil.TempDir("", "pushtart-test")
+	if err != nil {
+		panic(err)
+	}
+	confPath := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(confPath, []byte(`{"RunSentryInterval": 1}`), 0600); err != nil {
+		panic(err)
+	}
+	if err := config.Load(confPath); err != nil {
+		panic(err)
+	}
+	config.All().DataPath, config.All().DeploymentPath = dir, dir
+	config.All().Users = map[string]config.User{}
+	config.All().Tarts = map[string]config.Tart{}
+	config.All().Web.DomainProxies = map[string]config.DomainProxy{}
+
+	code := m.Run()
+	config.UnlockConfig()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
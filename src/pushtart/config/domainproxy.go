@@ -0,0 +1,34 @@
+package config
+
+//Load balancing policies for domain proxies with several upstreams.
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceLeastConnections = "least-connections"
+	BalanceWeighted         = "weighted"
+)
+
+//BalancePolicies lists the valid values of DomainProxy.Balance.
+var BalancePolicies = []string{BalanceRoundRobin, BalanceLeastConnections, BalanceWeighted}
+
+//Backends returns the upstreams requests to the proxy are balanced between - just its target, if it has no upstreams.
+func (p DomainProxy) Backends() []Upstream {
+	if len(p.Upstreams) > 0 {
+		return p.Upstreams
+	}
+	return []Upstream{{Host: p.TargetHost, Port: p.TargetPort, Scheme: p.TargetScheme, Weight: 1}}
+}
+
+//SetTarget changes the target of the proxy, which is also its first upstream.
+func (p *DomainProxy) SetTarget(host string, port int, scheme string) {
+	p.TargetHost, p.TargetPort, p.TargetScheme = host, port, scheme
+	if len(p.Upstreams) == 0 {
+		return
+	}
+	p.Upstreams[0].Host, p.Upstreams[0].Port, p.Upstreams[0].Scheme = host, port, scheme
+	for i := 1; i < len(p.Upstreams); i++ { //the new target may already have been one of the others
+		if p.Upstreams[i].Host == host && p.Upstreams[i].Port == port {
+			p.Upstreams = append(p.Upstreams[:i], p.Upstreams[i+1:]...)
+			i--
+		}
+	}
+}
@@ -22,8 +22,9 @@ func Generate(fpath string) (err error) {
 
 	if gConfig == nil {
 		gConfig = &Config{
-			Name: "pushtart",
-			Path: fpath,
+			Name:              "pushtart",
+			Path:              fpath,
+			UserRolesMigrated: true, //there are no users from before roles
 		}
 	}
 
@@ -64,6 +65,16 @@ func Generate(fpath string) (err error) {
 		gConfig.Web.Listener = ":8080"
 	}
 
+	if gConfig.AuditLogPath == "" {
+		pwd, _ := os.Getwd()
+		gConfig.AuditLogPath = path.Join(pwd, constants.DefaultAuditLogFileName)
+	}
+
+	if gConfig.SecretKeyPath == "" {
+		pwd, _ := os.Getwd()
+		gConfig.SecretKeyPath = path.Join(pwd, constants.DefaultSecretKeyFileName)
+	}
+
 	if gConfig.RunSentryInterval == 0 {
 		gConfig.RunSentryInterval = 180 // 3 minutes
 	}
@@ -36,9 +36,50 @@ func Load(fpath string) error {
 		return errors.New("RunSentryInterval cannot be 0")
 	}
 
+	migrateUserSSHKeys(gConfig)
+	migrateAPIKeys(gConfig)
+	if migrateUserRoles(gConfig) {
+		writeConfig() //so users created from now on are not mistaken for users from before roles
+	}
 	return nil
 }
 
+//migrateUserRoles makes users from before roles existed admins, as they could previously run any command. This is
+//only done once, when the configuration is first loaded by a version with roles. Returns true if anything changed.
+func migrateUserRoles(conf *Config) bool {
+	if conf.UserRolesMigrated {
+		return false
+	}
+	for username, usr := range conf.Users {
+		if usr.Role != "" {
+			continue
+		}
+		usr.Role = "admin"
+		conf.Users[username] = usr
+		logging.Info("config", "Made "+username+" an admin, as users from before roles existed could run any command")
+	}
+	conf.UserRolesMigrated = true
+	return true
+}
+
+//migrateUserSSHKeys moves keys from the deprecated User.SSHPubKey field to User.SSHKeys.
+func migrateUserSSHKeys(conf *Config) {
+	for username, usr := range conf.Users {
+		if usr.SSHPubKey == "" {
+			continue
+		}
+		keys, err := ParseSSHKeys([]byte(usr.SSHPubKey), "imported")
+		if err != nil {
+			logging.Error("config", "Could not migrate SSH key for "+username+": "+err.Error())
+			continue
+		}
+		usr.SSHKeys = append(usr.SSHKeys, keys...)
+		usr.SSHPubKey = ""
+		conf.Users[username] = usr
+		logging.Info("config", "Migrated ", len(keys), " SSH key(s) for "+username)
+	}
+}
+
 //GetServerName returns the field 'Name' specified in the configuration.
 func GetServerName() string {
 	checkInitialisedOrPanic()
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"pushtart/constants"
+	"pushtart/logging"
+	"sync"
+)
+
+const secretKeySize = 32 //AES-256
+
+var secretKeyLock sync.Mutex
+var secretKey []byte
+
+func secretKeyPath() string {
+	if gConfig.SecretKeyPath != "" {
+		return gConfig.SecretKeyPath
+	}
+	return path.Join(path.Dir(gConfig.Path), constants.DefaultSecretKeyFileName)
+}
+
+//getSecretKey returns the key used to encrypt secrets in the configuration, generating and saving one if the key file does not exist.
+func getSecretKey() ([]byte, error) {
+	secretKeyLock.Lock()
+	defer secretKeyLock.Unlock()
+	if secretKey != nil {
+		return secretKey, nil
+	}
+
+	p := secretKeyPath()
+	key, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		logging.Info("config", "Generating secret key: "+p)
+		key = make([]byte, secretKeySize)
+		if _, err = io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(p, key, 0600)
+	}
+	if err != nil {
+		return nil, errors.New("Could not load secret key: " + err.Error())
+	}
+	if len(key) != secretKeySize {
+		return nil, errors.New("Secret key " + p + " is corrupt")
+	}
+	secretKey = key
+	return key, nil
+}
+
+func secretCipher() (cipher.AEAD, error) {
+	key, err := getSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+//EncryptSecret encrypts a secret for storage in the configuration, using the key in the secret key file.
+func EncryptSecret(plaintext string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+//DecryptSecret decrypts a secret encrypted by EncryptSecret.
+func DecryptSecret(ciphertext string) (string, error) {
+	gcm, err := secretCipher()
+	if err != nil {
+		return "", err
+	}
+	d, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	if len(d) < gcm.NonceSize() {
+		return "", errors.New("Encrypted secret is too short")
+	}
+	plaintext, err := gcm.Open(nil, d[:gcm.NonceSize()], d[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Could not decrypt secret - has the secret key changed?")
+	}
+	return string(plaintext), nil
+}
+
+//SignMessage returns a hex-encoded HMAC-SHA256 of msg, keyed (indirectly) by the secret key. It is used to verify that
+//tokens such as login sessions were issued by this server.
+func SignMessage(msg string) (string, error) {
+	key, err := getSecretKey()
+	if err != nil {
+		return "", err
+	}
+	//derive a separate key for signing, so the encryption key is not used for two purposes
+	kdf := hmac.New(sha256.New, key)
+	kdf.Write([]byte("pushtart-signing"))
+
+	mac := hmac.New(sha256.New, kdf.Sum(nil))
+	mac.Write([]byte(msg))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"pushtart/util"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+//ErrNoSSHKeys is returned by ParseSSHKeys if the input does not contain any keys.
+var ErrNoSSHKeys = errors.New("No SSH public keys found in input")
+
+//ParseSSHKeys parses every public key in the given authorized_keys formatted data, giving each the specified label.
+//If a key has a comment and label is empty, the comment is used as the label.
+func ParseSSHKeys(data []byte, label string) ([]SSHKey, error) {
+	var keys []SSHKey
+	for len(data) > 0 {
+		pubKey, comment, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			if len(keys) > 0 { //no further keys after the ones already parsed
+				break
+			}
+			return nil, err
+		}
+		keyLabel := label
+		if keyLabel == "" {
+			keyLabel = comment
+		}
+		keys = append(keys, SSHKey{
+			Label:       keyLabel,
+			Fingerprint: util.SSHKeyFingerprint(pubKey.Marshal()),
+			PubKey:      string(ssh.MarshalAuthorizedKey(pubKey)),
+			Added:       time.Now().Unix(),
+		})
+		data = rest
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoSSHKeys
+	}
+	return keys, nil
+}
@@ -8,6 +8,10 @@ type Config struct {
 	OverrideStatusColor string
 	Path                string   `json:"-"` //path used to represent where the file is currently stored.
 	RunSentryInterval   int      //Seconds between executions of the runsentry.
+	NamespaceTarts      bool     //If set, users may only create new tarts under /<username>/.
+	AuditLogPath        string   //File the audit log is appended to. If empty, audit.log alongside the configuration file is used.
+	SecretKeyPath       string   //File holding the key which encrypts secrets (such as TOTP secrets) in the configuration. If empty, secret.key alongside the configuration file is used.
+	UserRolesMigrated   bool     //Set once users from before roles existed have been made admins. Users without a role are developers after that.
 	TLS                 struct { //Relative file addresses of the .pem files needed for TLS.
 		Enabled       bool
 		ForceRedirect bool //If set, all HTTPPROXY requests for apps must go over HTTPS. HTTP traffic is redirected.
@@ -24,17 +28,30 @@ type Config struct {
 		Listener      string //Address:port (address can be omitted) where the HTTPS listener will bind.
 		DomainProxies map[string]DomainProxy
 		LogAllProxies bool
+
+		SessionLifetime     int    //Seconds a HTTPProxy login session lasts. If zero, sessions last 12 hours.
+		SessionCookieDomain string //If set (e.g. example.com), login sessions are shared by all proxied domains under it. Otherwise each domain has its own sessions.
+
+		TrustedProxies []string //Addresses or CIDR ranges of reverse proxies in front of pushtart. The X-Forwarded-For header is only believed from these.
+
+		TartSubdomains bool //If set, each tart is proxied at <tart-name>.<DefaultDomain>, to the port allocated to it.
+		TartPortStart  int  //Ports allocated to tarts (and passed to them as $PORT) start from here. If zero, 20000.
 	}
 
-	APIKeys []struct {
-		Service string
-		Key     string
+	APIKeys []APIKey
+
+	OIDC struct { //Pushtart acting as an OpenID Connect provider, so tarts can log users in with their pushtart accounts.
+		Issuer  string //Base URL of the provider, as seen by clients (e.g. https://pushtart.example.com). If empty, it is derived from Web.DefaultDomain.
+		PrivPEM string `getConfigValue:"block"` //Encrypted RSA key which signs ID tokens. Generated when first needed.
 	}
 
 	SSH struct {
-		PubPEM   string
-		PrivPEM  string
-		Listener string
+		PubPEM          string
+		PrivPEM         string
+		Listener        string
+		CertAuthorities []SSHCertAuthority //CAs whose signed user certificates are accepted for authentication.
+		RevokedKeys     []string           //Fingerprints of keys whose certificates are no longer accepted.
+		Require2FA      bool               //If set, users with two-factor authentication enabled may only log in with a password using keyboard-interactive authentication, which also asks for their verification code.
 	}
 
 	DNS struct {
@@ -46,8 +63,9 @@ type Config struct {
 		AAAARecord      map[string]ARecord
 	}
 
-	Users map[string]User
-	Tarts map[string]Tart
+	Users  map[string]User
+	Groups map[string]Group
+	Tarts  map[string]Tart
 }
 
 //DomainProxy represents a reverse proxy for requests received on a specific domain, to a specific host/port.
@@ -56,18 +74,77 @@ type DomainProxy struct {
 	TargetPort   int
 	TargetScheme string
 	AuthRules    []AuthorizationRule
+	OwnerUser    string //User who created the proxy. Empty if created from the console.
+	OwnerTart    string //pushURL of the tart which owns the proxy, if any.
+
+	//Routes are checked in order - the first whose prefix matches the path handles the request, otherwise the proxy's own target does.
+	Routes []PathRoute `json:",omitempty"`
+
+	//If set, requests are balanced between these backends. The first is always the proxy's own target.
+	Upstreams []Upstream `json:",omitempty"`
+	Balance   string     `json:",omitempty"` //round-robin (the default), least-connections or weighted.
+
+	//Tuning for streaming responses and upgraded (WebSocket) connections.
+	FlushInterval int `json:",omitempty"` //Milliseconds between flushes of responses to the client, or -1 to flush after every write. Streaming responses are always flushed immediately.
+	IdleTimeout   int `json:",omitempty"` //Seconds a connection to the backend may pass no data before it is closed. 0 is 15 minutes, -1 is never.
+
+	//If set, files are served from a tart's deployment instead of requests being proxied to the target.
+	Static *StaticSite `json:",omitempty"`
+
+	//Pages shown when the target cannot be reached, or the domain is down for maintenance.
+	ErrorPages  *ErrorPages `json:",omitempty"` //If set, pages like 502.html are served from a tart's deployment instead of the built-in ones.
+	Maintenance bool        `json:",omitempty"` //If set, every request is answered with a 503 maintenance page.
+	RetryAfter  int         `json:",omitempty"` //Seconds clients are asked to wait (in Retry-After) before retrying 503 responses. 0 is 60.
+}
+
+//ErrorPages are custom error and maintenance pages, read from the deployment of a tart.
+type ErrorPages struct {
+	Tart string //pushURL of the tart whose deployment holds the pages.
+	Dir  string `json:",omitempty"` //Directory within the deployment holding the pages. Empty is the top level.
+}
+
+//StaticSite serves files straight from the deployment of a tart.
+type StaticSite struct {
+	Tart        string //pushURL of the tart whose deployment is served.
+	Dir         string `json:",omitempty"` //Directory within the deployment to serve, like public. Empty serves the whole deployment.
+	SPAFallback bool   `json:",omitempty"` //Serve the top-level index.html for paths without a file, for single-page apps.
+	MaxAge      int    `json:",omitempty"` //Seconds browsers may cache files other than HTML for. 0 is an hour, -1 always revalidates.
+}
+
+//Upstream is one of the backends a domain proxy balances requests between.
+type Upstream struct {
+	Host   string
+	Port   int
+	Scheme string
+	Weight int `json:",omitempty"` //Relative share of requests, under the weighted policy. Zero is treated as 1.
+}
+
+//PathRoute sends requests under a path prefix of a domain to a different target than the rest of the domain.
+type PathRoute struct {
+	Prefix       string //Path prefix, like /api. Matches the prefix itself and anything beneath it.
+	TargetHost   string
+	TargetPort   int
+	TargetScheme string
+	StripPrefix  bool                //If set, the prefix is removed from the path before the request is proxied.
+	AuthRules    []AuthorizationRule //If there are none, the auth rules of the domain apply.
+	OwnerUser    string
+	OwnerTart    string
 }
 
 //AuthorizationRule is a ALLOW/DENY rule for a specific domain
 type AuthorizationRule struct {
 	RuleType string
 	Username string
+	Group    string `json:",omitempty"` //Group the rule applies to, for GRP_ALLOW and GRP_DENY rules.
+	CIDR     string `json:",omitempty"` //Address range the rule applies to, for IP_ALLOW and IP_DENY rules.
 }
 
 //ARecord represents a response that could be served to a DNS query of type A.
 type ARecord struct {
-	Address string
-	TTL     uint32
+	Address   string
+	TTL       uint32
+	OwnerUser string //User who created the record. Empty if created from the console.
+	OwnerTart string //pushURL of the tart which owns the record, if any.
 }
 
 //User represents an account which has access to the system.
@@ -75,7 +152,65 @@ type User struct {
 	Name             string
 	Password         string
 	AllowSSHPassword bool
-	SSHPubKey        string
+	Role             string //One of admin, operator or developer. Users without a role are developers.
+	SSHPubKey        string `json:",omitempty"` //Deprecated: keys here are moved to SSHKeys when the configuration is loaded.
+	SSHKeys          []SSHKey
+	TOTPSecret       string `json:",omitempty"` //Encrypted TOTP secret. Empty if two-factor authentication is not enabled.
+}
+
+//Group is a named set of users, which may own tarts and be used in HTTPProxy authorization rules.
+type Group struct {
+	Description string
+	Members     []string
+}
+
+//APIKey represents a key which a service may use to authenticate to the RPC interface.
+type APIKey struct {
+	ID       string //Public identifier, used to refer to the key in ls-api-keys and revoke-api-key.
+	Service  string
+	Key      string `json:",omitempty"` //Deprecated: plaintext keys are hashed into KeyHash when the configuration is loaded.
+	KeyHash  string //Hex-encoded SHA256 of the key.
+	Username string `json:",omitempty"` //User whose role and permissions the key acts with. Keys without a user are only limited by their scopes.
+	Scopes   []string
+	Tarts    []string `json:",omitempty"` //If set, the only tarts the key may access.
+	Created  int64    //Unix timestamp the key was generated.
+	Expires  int64    //Unix timestamp after which the key is invalid, or 0 if it never expires.
+	LastUsed int64    //Unix timestamp the key was last used, or 0 if never.
+}
+
+//SSHKey represents a SSH public key which a user may authenticate with.
+type SSHKey struct {
+	Label       string
+	Fingerprint string
+	PubKey      string //OpenSSH authorized_keys format.
+	Added       int64  //Unix timestamp the key was added.
+	LastUsed    int64  //Unix timestamp the key was last used to authenticate, or 0 if never.
+}
+
+//SSHCertAuthority represents a SSH certificate authority, whose user certificates are trusted for authentication.
+type SSHCertAuthority struct {
+	Label          string
+	Fingerprint    string
+	PubKey         string            //OpenSSH authorized_keys format.
+	PrincipalMap   map[string]string //Maps certificate principals to usernames. Unmapped principals are used as the username directly.
+	RevokedSerials []uint64
+}
+
+//DeployKey is a SSH public key which may only push to or fetch from a single tart.
+type DeployKey struct {
+	Label       string
+	Fingerprint string
+	PubKey      string //OpenSSH authorized_keys format.
+	Added       int64  //Unix timestamp the key was added.
+}
+
+//OIDCClient is an OpenID Connect client registered for a tart, so it can log users in with their pushtart accounts.
+type OIDCClient struct {
+	ID           string
+	Label        string
+	SecretHash   string   //Hex-encoded SHA256 of the client secret.
+	RedirectURIs []string //URIs users may be sent back to after logging in. Must match exactly.
+	Added        int64    //Unix timestamp the client was registered.
 }
 
 //Tart stores information for tarts which are stored in the system.
@@ -83,6 +218,10 @@ type Tart struct {
 	PushURL          string
 	Name             string
 	Owners           []string
+	OwnerGroups      []string `json:",omitempty"` //Groups whose members are also owners of the tart.
+	Readers          []string //Users who may fetch/clone the tart, in addition to owners.
+	DeployKeys       []DeployKey
+	OIDCClients      []OIDCClient `json:",omitempty"`
 	IsRunning        bool
 	LogStdout        bool
 	PID              int
@@ -91,4 +230,16 @@ type Tart struct {
 	RestartDelaySecs int
 	LastHash         string
 	LastGitMessage   string
+	Port             int       `json:",omitempty"` //Port allocated to the tart, passed to it as $PORT.
+	Subdomain        string    `json:",omitempty"` //Domain the tart is currently proxied at, if Web.TartSubdomains is set.
+	Replicas         int       `json:",omitempty"` //Number of instances of the tart to run. 0 is the same as 1.
+	Instances        []Replica `json:",omitempty"` //Instances after the first, whose state is kept in IsRunning, PID and Port.
+	Maintenance      bool      `json:",omitempty"` //If set, domains serving the tart answer with a 503 maintenance page.
+}
+
+//Replica stores the state of an additional instance of a tart.
+type Replica struct {
+	IsRunning bool
+	PID       int
+	Port      int
 }
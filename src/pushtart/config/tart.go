@@ -0,0 +1,42 @@
+package config
+
+//ReplicaCount returns the number of instances of the tart which should be run.
+func (t Tart) ReplicaCount() int {
+	if t.Replicas < 1 {
+		return 1
+	}
+	return t.Replicas
+}
+
+//Replica returns the state of the i'th (from 0) instance of the tart. The state of the first is kept in the tart itself.
+func (t Tart) Replica(i int) Replica {
+	if i == 0 {
+		return Replica{IsRunning: t.IsRunning, PID: t.PID, Port: t.Port}
+	}
+	if i-1 < len(t.Instances) {
+		return t.Instances[i-1]
+	}
+	return Replica{PID: -1}
+}
+
+//SetReplica updates the state of the i'th (from 0) instance of the tart.
+func (t *Tart) SetReplica(i int, r Replica) {
+	if i == 0 {
+		t.IsRunning, t.PID, t.Port = r.IsRunning, r.PID, r.Port
+		return
+	}
+	for len(t.Instances) < i {
+		t.Instances = append(t.Instances, Replica{PID: -1})
+	}
+	t.Instances[i-1] = r
+}
+
+//AnyRunning returns true if any instance of the tart is running.
+func (t Tart) AnyRunning() bool {
+	for i := 0; i <= len(t.Instances); i++ {
+		if t.Replica(i).IsRunning {
+			return true
+		}
+	}
+	return false
+}
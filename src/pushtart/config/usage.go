@@ -0,0 +1,42 @@
+package config
+
+import (
+	"sync"
+	"time"
+)
+
+//usageFlushInterval is the most often changes made through RecordUsage are written to disk.
+const usageFlushInterval = time.Minute
+
+var (
+	usageLock         sync.Mutex
+	usageFlushPending bool
+	lastUsageFlush    time.Time
+)
+
+//RecordUsage applies update, which should only record that something was used (like the LastUsed time of a key), to
+//the configuration while holding the lock configuration is written under. As such changes are frequent and of little
+//consequence if lost, they are written to disk at most once every usageFlushInterval. Update should not add or remove
+//map entries, as the configuration is read without locking elsewhere.
+func RecordUsage(update func(c *Config)) {
+	writeLock.Lock()
+	update(gConfig)
+	writeLock.Unlock()
+
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	if usageFlushPending {
+		return
+	}
+	usageFlushPending = true
+	wait := usageFlushInterval - time.Since(lastUsageFlush)
+	if wait < 0 {
+		wait = 0
+	}
+	time.AfterFunc(wait, func() {
+		usageLock.Lock()
+		usageFlushPending, lastUsageFlush = false, time.Now()
+		usageLock.Unlock()
+		writeConfig()
+	})
+}
@@ -3,5 +3,17 @@ package constants
 //DefaultConfigFileName is the default base file name of pushtart config files.
 var DefaultConfigFileName = "config.json"
 
+//DefaultAuditLogFileName is the default base file name of the audit log.
+var DefaultAuditLogFileName = "audit.log"
+
+//DefaultSecretKeyFileName is the default base file name of the key used to encrypt secrets in the configuration.
+var DefaultSecretKeyFileName = "secret.key"
+
+//MaxPushURLDepth is the maximum number of path segments permitted in a pushURL.
+var MaxPushURLDepth = 4
+
 //RsaKeySize is the default RSA SSH key size in bits. This value is used when generating a new key.
 var RsaKeySize = 2048
+
+//DefaultTartPortStart is the first port allocated to tarts, unless Web.TartPortStart is set.
+var DefaultTartPortStart = 20000
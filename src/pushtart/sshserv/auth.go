@@ -1,45 +1,112 @@
 package sshserv
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
+	"pushtart/authlimit"
+	"pushtart/config"
 	"pushtart/logging"
+	"pushtart/tartmanager"
 	"pushtart/user"
+	"pushtart/util"
 
 	"golang.org/x/crypto/ssh"
 )
 
 var errAuthDenied = errors.New("Authentication denied")
 
+//deployKeyTartExtension is set in the permissions of connections authenticated with a deploy key, to the pushURL of its tart.
+const deployKeyTartExtension = "pushtart-deploy-key-tart"
+
+//userKeyExtension is set in the permissions of connections authenticated with one of a user's SSH keys, to its fingerprint.
+const userKeyExtension = "pushtart-user-key"
+
 func passwordCheck(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
 	logging.Info("sshserv-auth", "Received authentication request for "+c.User()+" (Password)")
 
+	address := authlimit.Host(c.RemoteAddr().String())
+	if err := authlimit.Check(address, c.User()); err != nil {
+		logging.Warning("sshserv-auth", "Refused password authentication for "+c.User()+" from blocked "+address)
+		return nil, err
+	}
+
+	if config.All().SSH.Require2FA && user.Has2FA(c.User()) {
+		logging.Info("sshserv-auth", "Refused password authentication for "+c.User()+" - keyboard-interactive authentication with a verification code is required")
+		return nil, errAuthDenied
+	}
+
 	// Should use constant-time compare (or better, salt+hash) in
 	// a production setting.
 	if user.CheckUserPasswordSSH(c.User(), string(pass)) {
+		authlimit.Success(c.User())
 		return nil, nil
 	}
+	authlimit.Failure(address, c.User(), "SSH password")
 	return nil, fmt.Errorf("password rejected for %q", c.User())
 }
 
-func publicKeyCheck(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-	logging.Info("sshserv-auth", "Received authentication request for "+conn.User()+" (PublicKey)")
+//keyboardInteractiveCheck authenticates with a password, followed by a verification code if the user has two-factor authentication enabled.
+func keyboardInteractiveCheck(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	logging.Info("sshserv-auth", "Received authentication request for "+c.User()+" (Keyboard-interactive)")
 
-	pubKeyRaw := user.GetUserPubkey(conn.User())
-	if pubKeyRaw == "" {
-		logging.Warning("sshserv-auth", "No PublicKey known for "+conn.User())
-		return nil, errAuthDenied
+	address := authlimit.Host(c.RemoteAddr().String())
+	if err := authlimit.Check(address, c.User()); err != nil {
+		logging.Warning("sshserv-auth", "Refused keyboard-interactive authentication for "+c.User()+" from blocked "+address)
+		return nil, err
 	}
 
-	trustedPk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(pubKeyRaw))
+	questions, echos := []string{"Password: "}, []bool{false}
+	has2FA := user.Has2FA(c.User())
+	if has2FA {
+		questions, echos = append(questions, "Verification code: "), append(echos, true)
+	}
+	answers, err := client(c.User(), "", questions, echos)
 	if err != nil {
-		logging.Error("sshserv-auth", "Could not parse user's public key: "+err.Error())
+		return nil, err
+	}
+	if len(answers) != len(questions) {
 		return nil, errAuthDenied
 	}
 
-	if bytes.Equal(trustedPk.Marshal(), pubKey.Marshal()) { //authentication successful
+	if user.CheckUserPasswordSSH(c.User(), answers[0]) && (!has2FA || user.CheckTOTP(c.User(), answers[1])) {
+		authlimit.Success(c.User())
 		return nil, nil
 	}
+	authlimit.Failure(address, c.User(), "SSH keyboard-interactive")
+	return nil, fmt.Errorf("keyboard-interactive authentication rejected for %q", c.User())
+}
+
+func publicKeyCheck(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	logging.Info("sshserv-auth", "Received authentication request for "+conn.User()+" (PublicKey)")
+
+	fingerprint := util.SSHKeyFingerprint(pubKey.Marshal())
+	if user.CheckUserPubkey(conn.User(), pubKey) { //authentication successful
+		return &ssh.Permissions{Extensions: map[string]string{userKeyExtension: fingerprint}}, nil
+	}
+
+	if pushURL, key, ok := tartmanager.FindDeployKey(fingerprint); ok {
+		logging.Info("sshserv-auth", "Authenticated deploy key '"+key.Label+"' for tart "+pushURL)
+		return &ssh.Permissions{Extensions: map[string]string{deployKeyTartExtension: pushURL}}, nil
+	}
 	return nil, errAuthDenied
 }
+
+//deployKeyTart returns the pushURL of the tart the connection is restricted to, if it was authenticated with a deploy key.
+func deployKeyTart(conn *ssh.ServerConn) (pushURL string, isDeployKey bool) {
+	if conn.Permissions == nil {
+		return "", false
+	}
+	pushURL, isDeployKey = conn.Permissions.Extensions[deployKeyTartExtension]
+	return
+}
+
+//recordKeyUse records the use of the user SSH key a connection was authenticated with, if any. This is only done once
+//the handshake succeeds, as the public key callback is also called for keys the client has not proved it holds.
+func recordKeyUse(conn *ssh.ServerConn) {
+	if conn.Permissions == nil {
+		return
+	}
+	if fingerprint, ok := conn.Permissions.Extensions[userKeyExtension]; ok {
+		user.RecordSSHKeyUse(conn.User(), fingerprint)
+	}
+}
@@ -0,0 +1,101 @@
+package sshserv
+
+import (
+	"pushtart/authlimit"
+	"pushtart/config"
+	"pushtart/logging"
+	"pushtart/user"
+	"pushtart/util"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func publicKeyOrCertificateCheck(conn ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	//Key failures are not counted, as clients routinely offer several keys, but blocked addresses are refused.
+	if err := authlimit.Check(authlimit.Host(conn.RemoteAddr().String()), ""); err != nil {
+		logging.Warning("sshserv-auth", "Refused key authentication for "+conn.User()+" from blocked "+conn.RemoteAddr().String())
+		return nil, err
+	}
+	if cert, isCert := pubKey.(*ssh.Certificate); isCert {
+		return certificateCheck(conn, cert)
+	}
+	return publicKeyCheck(conn, pubKey)
+}
+
+func certificateCheck(conn ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	logging.Info("sshserv-auth", "Received authentication request for "+conn.User()+" (Certificate, serial ", cert.Serial, ")")
+	if cert.CertType != ssh.UserCert {
+		logging.Warning("sshserv-auth", "Rejected certificate which is not a user certificate")
+		return nil, errAuthDenied
+	}
+
+	ca, ok := findCertAuthority(util.SSHKeyFingerprint(cert.SignatureKey.Marshal()))
+	if !ok {
+		logging.Warning("sshserv-auth", "Rejected certificate signed by an unknown authority")
+		return nil, errAuthDenied
+	}
+
+	principal, ok := principalForUser(ca, cert, conn.User())
+	if !ok {
+		logging.Warning("sshserv-auth", "Rejected certificate from CA '"+ca.Label+"': no principal maps to "+conn.User())
+		return nil, errAuthDenied
+	}
+
+	checker := ssh.CertChecker{
+		IsAuthority: func(auth ssh.PublicKey) bool {
+			return util.SSHKeyFingerprint(auth.Marshal()) == ca.Fingerprint
+		},
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return certIsRevoked(ca, cert)
+		},
+	}
+	if err := checker.CheckCert(principal, cert); err != nil {
+		logging.Warning("sshserv-auth", "Rejected certificate from CA '"+ca.Label+"': "+err.Error())
+		return nil, errAuthDenied
+	}
+
+	logging.Info("sshserv-auth", "Authenticated "+conn.User()+" with certificate from CA '"+ca.Label+"' (principal "+principal+")")
+	return &ssh.Permissions{CriticalOptions: cert.CriticalOptions}, nil
+}
+
+func findCertAuthority(fingerprint string) (config.SSHCertAuthority, bool) {
+	for _, ca := range config.All().SSH.CertAuthorities {
+		if ca.Fingerprint == fingerprint {
+			return ca, true
+		}
+	}
+	return config.SSHCertAuthority{}, false
+}
+
+//principalForUser returns the certificate principal which maps to the given username. Certificates without
+//principals are never accepted.
+func principalForUser(ca config.SSHCertAuthority, cert *ssh.Certificate, username string) (string, bool) {
+	if !user.Exists(username) {
+		return "", false
+	}
+	for _, principal := range cert.ValidPrincipals {
+		mapped := principal
+		if u, ok := ca.PrincipalMap[principal]; ok {
+			mapped = u
+		}
+		if mapped == username {
+			return principal, true
+		}
+	}
+	return "", false
+}
+
+func certIsRevoked(ca config.SSHCertAuthority, cert *ssh.Certificate) bool {
+	for _, serial := range ca.RevokedSerials {
+		if serial == cert.Serial {
+			return true
+		}
+	}
+	keyFingerprint := util.SSHKeyFingerprint(cert.Key.Marshal())
+	for _, revoked := range config.All().SSH.RevokedKeys {
+		if revoked == keyFingerprint {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package sshserv
+
+import (
+	"crypto/rand"
+	"net"
+	"pushtart/config"
+	"pushtart/util"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// testConn is the metadata of a connection authenticating as a user.
+type testConn struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c testConn) User() string { return c.user }
+func (c testConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2222}
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// signCert returns a certificate for key signed by ca, valid for an hour unless changed by edit.
+func signCert(t *testing.T, ca, key ssh.Signer, serial uint64, principals []string, edit func(*ssh.Certificate)) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:             key.PublicKey(),
+		Serial:          serial,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if edit != nil {
+		edit(cert)
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCertificateCheck(t *testing.T) {
+	ca, otherCA, key, revokedKey := newSigner(t), newSigner(t), newSigner(t), newSigner(t)
+	config.All().Users["alice"] = config.User{}
+	config.All().Users["bob"] = config.User{}
+	config.All().SSH.CertAuthorities = []config.SSHCertAuthority{{
+		Label:          "corp",
+		Fingerprint:    util.SSHKeyFingerprint(ca.PublicKey().Marshal()),
+		PrincipalMap:   map[string]string{"alice@corp": "alice", "bob": "alice"},
+		RevokedSerials: []uint64{13},
+	}}
+	config.All().SSH.RevokedKeys = []string{util.SSHKeyFingerprint(revokedKey.PublicKey().Marshal())}
+	defer func() {
+		config.All().SSH.CertAuthorities, config.All().SSH.RevokedKeys = nil, nil
+		delete(config.All().Users, "alice")
+		delete(config.All().Users, "bob")
+	}()
+
+	tests := []struct {
+		name     string
+		username string
+		cert     *ssh.Certificate
+		want     bool
+	}{
+		{"one of several principals", "alice", signCert(t, ca, key, 1, []string{"carol", "alice"}, nil), true},
+		{"unmapped principal is the username", "alice", signCert(t, ca, key, 1, []string{"alice"}, nil), true},
+		{"mapped principal", "alice", signCert(t, ca, key, 1, []string{"alice@corp"}, nil), true},
+		{"principal mapped to another user", "bob", signCert(t, ca, key, 1, []string{"alice@corp"}, nil), false},
+		{"principal mapped away from its own name", "bob", signCert(t, ca, key, 1, []string{"bob"}, nil), false},
+		{"mapped to a user who does not exist", "carol", signCert(t, ca, key, 1, []string{"carol"}, nil), false},
+		{"no principals", "alice", signCert(t, ca, key, 1, nil, nil), false},
+		{"unknown CA", "alice", signCert(t, otherCA, key, 1, []string{"alice"}, nil), false},
+		{"revoked serial", "alice", signCert(t, ca, key, 13, []string{"alice"}, nil), false},
+		{"revoked key", "alice", signCert(t, ca, revokedKey, 1, []string{"alice"}, nil), false},
+		{"expired", "alice", signCert(t, ca, key, 1, []string{"alice"}, func(c *ssh.Certificate) {
+			c.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix())
+		}), false},
+		{"not yet valid", "alice", signCert(t, ca, key, 1, []string{"alice"}, func(c *ssh.Certificate) {
+			c.ValidAfter = uint64(time.Now().Add(time.Hour).Unix())
+		}), false},
+		{"host certificate", "alice", signCert(t, ca, key, 1, []string{"alice"}, func(c *ssh.Certificate) { c.CertType = ssh.HostCert }), false},
+	}
+	for _, test := range tests {
+		_, err := publicKeyOrCertificateCheck(testConn{user: test.username}, test.cert)
+		if (err == nil) != test.want {
+			t.Errorf("%s: authenticated = %v, want %v (err: %v)", test.name, err == nil, test.want, err)
+		}
+	}
+
+	//a certificate whose signature was tampered with after signing is refused
+	cert := signCert(t, ca, key, 1, []string{"alice"}, nil)
+	cert.ValidPrincipals = []string{"alice", "bob"}
+	if _, err := publicKeyOrCertificateCheck(testConn{user: "bob"}, cert); err == nil {
+		t.Error("accepted a certificate modified after it was signed")
+	}
+}
@@ -8,10 +8,13 @@ import (
 	"io/ioutil"
 	"os/exec"
 	"path"
+	"pushtart/audit"
 	"pushtart/config"
 	"pushtart/logging"
+	"pushtart/sshserv/cmd_registry"
 	"pushtart/tartmanager"
 	"pushtart/user"
+	"pushtart/util"
 	"strconv"
 	"strings"
 	"sync"
@@ -20,12 +23,18 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func extractPushURL(cmdStr string) string {
-	return strings.Replace(cmdStr[17:], "'", "", -1)
+//extractPushURL returns the (validated) repository path given as the argument to git-receive-pack / git-upload-pack.
+func extractPushURL(cmdStr string) (string, error) {
+	spl := strings.SplitN(cmdStr, " ", 2)
+	if len(spl) < 2 {
+		return "", tartmanager.ErrInvalidPushURL
+	}
+	pushURL := strings.Trim(strings.TrimSpace(spl[1]), "'")
+	return pushURL, tartmanager.ValidatePushURL(pushURL)
 }
 
-func getPath(cmdStr string) string {
-	return path.Join(config.All().DataPath, extractPushURL(cmdStr))
+func getPath(pushURL string) string {
+	return path.Join(config.All().DataPath, pushURL)
 }
 
 func execCmd(conn *ssh.ServerConn, channel ssh.Channel, payload []byte) {
@@ -38,40 +47,48 @@ func execCmd(conn *ssh.ServerConn, channel ssh.Channel, payload []byte) {
 		logging.Info("sshserv-exec", "Channel closing: "+cmdStr)
 	}()
 
-	if strings.HasPrefix(cmdStr, "git-receive-pack") {
-		err := tartmanager.PreGitRecieve(extractPushURL(cmdStr), conn.User())
-		if err != nil { //err is already logged.
-			sendExitStatus(channel, 1)
-			return
-		}
-
-		cmd := exec.Command("git-receive-pack", getPath(cmdStr))
-		err = runCommandAcrossSSHChannel(cmd, channel)
+	if strings.HasPrefix(cmdStr, "git-receive-pack") || strings.HasPrefix(cmdStr, "git-upload-pack") {
+		pushURL, err := extractPushURL(cmdStr)
 		if err != nil {
-			logging.Error("sshserv-exec", "runCommandAcrossSSHChannel() returned error: "+err.Error())
+			logging.Warning("sshserv-exec", "Rejected repository path ("+err.Error()+"): "+cmdStr)
+			channel.Stderr().Write([]byte("ERR: " + err.Error() + "\r\n"))
 			sendExitStatus(channel, 1)
 			return
 		}
 
-		err = tartmanager.PostGitRecieve(extractPushURL(cmdStr), conn.User())
-		if err != nil { //err is already logged
-			sendExitStatus(channel, 1)
-			return
+		usr := conn.User()
+		if deployTart, isDeployKey := deployKeyTart(conn); isDeployKey {
+			var ok bool
+			if usr, ok = tartmanager.DeployKeyActingUser(deployTart, pushURL); !ok {
+				logging.Warning("sshserv-exec", "Deploy key for "+deployTart+" refused access to "+pushURL)
+				channel.Stderr().Write([]byte("ERR: " + tartmanager.ErrRepositoryNotFound.Error() + "\r\n"))
+				sendExitStatus(channel, 1)
+				return
+			}
+			logging.Info("sshserv-exec", "Deploy key for "+deployTart+" acting as "+usr)
+			pushURL = deployTart
 		}
 
-		sendExitStatus(channel, 0)
-	} else if strings.HasPrefix(cmdStr, "git-upload-pack") {
-		cmd := exec.Command("git-upload-pack", getPath(cmdStr))
-		err := runCommandAcrossSSHChannel(cmd, channel)
-		if err != nil {
-			logging.Error("sshserv-exec", "runCommandAcrossSSHChannel() returned error: "+err.Error())
-			sendExitStatus(channel, 1)
-			return
+		if strings.HasPrefix(cmdStr, "git-receive-pack") {
+			actor := audit.Actor{Source: audit.SourceGit, User: usr}
+			if _, isDeployKey := deployKeyTart(conn); isDeployKey {
+				actor.Tart = pushURL
+			}
+			gitReceivePack(channel, pushURL, actor)
+		} else {
+			gitUploadPack(channel, pushURL, usr)
 		}
-		sendExitStatus(channel, 0)
+	} else if _, isDeployKey := deployKeyTart(conn); isDeployKey {
+		logging.Warning("sshserv-exec", "Exec request disallowed for deploy key: "+cmdStr)
+		channel.Write([]byte("Deploy keys may only be used to git push/fetch.\r\n"))
 	} else if strings.HasPrefix(cmdStr, "import-ssh-key ") {
 		runImportSSHKey(channel, conn, cmdStr)
 	} else if cmdStr == "logs" {
+		if !cmd_registry.Permitted("logs", conn.User()) {
+			logging.Warning("sshserv-exec", "["+conn.User()+"]: Denied logs (requires "+cmd_registry.Role("logs")+")")
+			channel.Write([]byte("Err: logs requires the " + cmd_registry.Role("logs") + " role\r\n"))
+			return
+		}
 		runLog(channel, conn, cmdStr)
 	} else {
 		logging.Warning("sshserv-exec", "Exec request disallowed: "+cmdStr)
@@ -79,6 +96,55 @@ func execCmd(conn *ssh.ServerConn, channel ssh.Channel, payload []byte) {
 	}
 }
 
+func gitReceivePack(channel ssh.Channel, pushURL string, actor audit.Actor) {
+	err := tartmanager.PreGitRecieve(pushURL, actor.User)
+	if err != nil { //err is already logged.
+		audit.Record(audit.Entry{Actor: actor, Action: "git-push", Tart: pushURL, Result: err.Error()})
+		channel.Stderr().Write([]byte("ERR: " + err.Error() + "\r\n"))
+		sendExitStatus(channel, 1)
+		return
+	}
+
+	cmd := exec.Command("git-receive-pack", getPath(pushURL))
+	err = runCommandAcrossSSHChannel(cmd, channel)
+	if err != nil {
+		logging.Error("sshserv-exec", "runCommandAcrossSSHChannel() returned error: "+err.Error())
+		tartmanager.AbortGitRecieve(pushURL)
+		audit.Record(audit.Entry{Actor: actor, Action: "git-push", Tart: pushURL, Result: err.Error()})
+		sendExitStatus(channel, 1)
+		return
+	}
+
+	stderr := io.Writer(channel.Stderr())
+	err = tartmanager.PostGitRecieve(pushURL, actor.User, &stderr)
+	if err != nil { //err is already logged
+		audit.Record(audit.Entry{Actor: actor, Action: "git-push", Tart: pushURL, Result: err.Error()})
+		sendExitStatus(channel, 1)
+		return
+	}
+
+	audit.Record(audit.Entry{Actor: actor, Action: "git-push", Tart: pushURL, Result: "ok"})
+	sendExitStatus(channel, 0)
+}
+
+func gitUploadPack(channel ssh.Channel, pushURL, usr string) {
+	err := tartmanager.PreGitUpload(pushURL, usr)
+	if err != nil { //err is already logged.
+		channel.Stderr().Write([]byte("ERR: " + err.Error() + "\r\n"))
+		sendExitStatus(channel, 1)
+		return
+	}
+
+	cmd := exec.Command("git-upload-pack", getPath(pushURL))
+	err = runCommandAcrossSSHChannel(cmd, channel)
+	if err != nil {
+		logging.Error("sshserv-exec", "runCommandAcrossSSHChannel() returned error: "+err.Error())
+		sendExitStatus(channel, 1)
+		return
+	}
+	sendExitStatus(channel, 0)
+}
+
 func runLog(channel ssh.Channel, conn *ssh.ServerConn, cmdStr string) {
 	bklog := logging.GetBacklog()
 	for _, msg := range bklog {
@@ -101,9 +167,17 @@ func runLog(channel ssh.Channel, conn *ssh.ServerConn, cmdStr string) {
 }
 
 func runImportSSHKey(channel ssh.Channel, conn *ssh.ServerConn, cmdStr string) {
-	spl := strings.Split(cmdStr, " ")
-	if len(spl) < 3 || spl[1] != "--username" {
-		channel.Write([]byte("USAGE: import-ssh-key --username <username>\r\n"))
+	params := util.ParseCommands(util.TokeniseCommandString(cmdStr[len("import-ssh-key"):]))
+	if params["username"] == "" {
+		channel.Write([]byte("USAGE: import-ssh-key --username <username> [--label <label>]\r\n"))
+		return
+	}
+	username := params["username"]
+	actor := audit.Actor{Source: audit.SourceExec, User: conn.User()}
+	if !user.CanManageCredentials(conn.User(), username) {
+		audit.Record(audit.Entry{Actor: actor, Action: "import-ssh-key", Args: params, Result: "denied"})
+		logging.Warning("sshserv-exec-importssh", conn.User()+" may not import keys for "+username)
+		channel.Write([]byte("ERR: You (" + conn.User() + ") may only import your own SSH keys.\r\nAbort.\r\n"))
 		return
 	}
 
@@ -116,16 +190,24 @@ func runImportSSHKey(channel ssh.Channel, conn *ssh.ServerConn, cmdStr string) {
 
 	channel.Write([]byte("Read " + strconv.Itoa(len(d)) + " bytes from input.\r\n"))
 
-	if !user.Exists(spl[2]) {
-		logging.Error("sshserv-exec-importssh", "User not found: "+spl[2])
-		channel.Write([]byte("ERR: user (" + spl[2] + ") not found.\r\nAbort.\r\n"))
+	if !user.Exists(username) {
+		logging.Error("sshserv-exec-importssh", "User not found: "+username)
+		channel.Write([]byte("ERR: user (" + username + ") not found.\r\nAbort.\r\n"))
 		return
 	}
 
-	usr := user.Get(spl[2])
-	usr.SSHPubKey = string(d)
-	user.Save(spl[2], usr)
-	channel.Write([]byte("SSH for " + spl[2] + " saved successfully.\r\n"))
+	added, err := user.AddSSHKeys(username, d, params["label"])
+	if err != nil {
+		audit.Record(audit.Entry{Actor: actor, Action: "import-ssh-key", Args: params, Result: err.Error()})
+		logging.Error("sshserv-exec-importssh", "Could not add key: "+err.Error())
+		channel.Write([]byte("ERR: " + err.Error() + ".\r\nAbort.\r\n"))
+		return
+	}
+	for _, key := range added {
+		params["fingerprint"] = key.Fingerprint
+		audit.Record(audit.Entry{Actor: actor, Action: "import-ssh-key", Args: params, Result: "ok"})
+		channel.Write([]byte("Added key " + key.Fingerprint + " (" + key.Label + ") for " + username + ".\r\n"))
+	}
 }
 
 func sendExitStatus(channel ssh.Channel, code int) {
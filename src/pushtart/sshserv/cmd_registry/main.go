@@ -1,21 +1,112 @@
 package cmd_registry
 
-import "io"
+import (
+	"errors"
+	"io"
+	"pushtart/audit"
+	"pushtart/user"
+	"strings"
+)
 
-var commands map[string]func(map[string]string, io.Writer, string)
+//ErrCommandNotFound is returned by Run if the command does not exist.
+var ErrCommandNotFound = errors.New("Command not found")
 
-//Register adds the given command and its function pointer to the registry.
-func Register(cmd string, function func(map[string]string, io.Writer, string)) {
+//ErrPermissionDenied is returned by Run if the calling user does not have the role required by the command.
+var ErrPermissionDenied = errors.New("Permission denied")
+
+type command struct {
+	function func(map[string]string, io.Writer, string)
+	role     string
+	readOnly bool
+}
+
+var commands map[string]command
+
+//Register adds the given command, the minimum role needed to run it, and its function pointer to the registry.
+func Register(cmd, role string, function func(map[string]string, io.Writer, string)) {
 	if commands == nil {
-		commands = map[string]func(map[string]string, io.Writer, string){}
+		commands = map[string]command{}
 	}
-	commands[cmd] = function
+	commands[cmd] = command{function: function, role: role}
 }
 
 //Command returns the function pointer for the specified command. false, nil is returned if the command does not exist.
 func Command(cmd string) (ok bool, function func(map[string]string, io.Writer, string)) {
-	function, ok = commands[cmd]
-	return
+	c, ok := commands[cmd]
+	return ok, c.function
+}
+
+//Role returns the minimum role needed to run the specified command.
+func Role(cmd string) string {
+	return commands[cmd].role
+}
+
+//Permitted returns true if the specified command exists and the given user has the role needed to run it.
+func Permitted(cmd, username string) bool {
+	c, ok := commands[cmd]
+	return ok && user.HasRole(username, c.role)
+}
+
+//MarkReadOnly marks the specified commands as not changing anything, so running them is not recorded in the audit log.
+func MarkReadOnly(cmds ...string) {
+	for _, cmd := range cmds {
+		if c, ok := commands[cmd]; ok {
+			c.readOnly = true
+			commands[cmd] = c
+		}
+	}
+}
+
+//Audited returns true if the specified command exists and running it should be recorded in the audit log.
+func Audited(cmd string) bool {
+	c, ok := commands[cmd]
+	return ok && !c.readOnly
+}
+
+//Run runs the specified command as the given actor, if their user has the role needed to run it. Unless the command
+//is read-only, the attempt and its result are recorded in the audit log.
+func Run(cmd string, params map[string]string, w io.Writer, actor audit.Actor) error {
+	c, ok := commands[cmd]
+	if !ok {
+		return ErrCommandNotFound
+	}
+	if !user.HasRole(actor.User, c.role) {
+		audit.Record(audit.Entry{Actor: actor, Action: cmd, Args: params, Result: "denied"})
+		return ErrPermissionDenied
+	}
+	if c.readOnly {
+		c.function(params, w, actor.User)
+		return nil
+	}
+
+	rw := &resultWriter{Out: w}
+	c.function(params, rw, actor.User)
+	audit.Record(audit.Entry{Actor: actor, Action: cmd, Args: params, Result: rw.Result()})
+	return nil
+}
+
+//resultWriter passes through command output, remembering the first error or usage message written.
+type resultWriter struct {
+	Out io.Writer
+	err string
+}
+
+func (r *resultWriter) Write(p []byte) (n int, err error) {
+	if r.err == "" {
+		line := strings.TrimSpace(strings.SplitN(string(p), "\n", 2)[0])
+		if strings.HasPrefix(line, "Err") || strings.HasPrefix(line, "USAGE") {
+			r.err = line
+		}
+	}
+	return r.Out.Write(p)
+}
+
+//Result returns "ok", or the first error or usage message the command wrote.
+func (r *resultWriter) Result() string {
+	if r.err == "" {
+		return "ok"
+	}
+	return r.err
 }
 
 //List returns a []string of all registered commands.
@@ -30,9 +121,10 @@ func List() []string {
 //Init registers ssh-only commands with the registry.
 func Init() {
 	if commands == nil {
-		commands = map[string]func(map[string]string, io.Writer, string){}
+		commands = map[string]command{}
 	}
-	Register("exit", exit)
+	Register("exit", user.RoleDeveloper, exit)
+	MarkReadOnly("exit")
 }
 
 func exit(params map[string]string, w io.Writer, user string) {
@@ -2,6 +2,7 @@ package sshserv
 
 import (
 	"io"
+	"pushtart/audit"
 	"pushtart/logging"
 	"pushtart/sshserv/cmd_registry"
 	"pushtart/util"
@@ -31,8 +32,11 @@ func shell(conn *ssh.ServerConn, channel ssh.Channel) {
 			break
 		}
 
-		if ok, runFunc := cmd_registry.Command(spl[0]); ok {
-			runFunc(util.ParseCommands(util.TokeniseCommandString(line[len(spl[0]):])), &commandOutputRewriter{Out: term}, conn.User())
+		out := &commandOutputRewriter{Out: term}
+		err = cmd_registry.Run(spl[0], util.ParseCommands(util.TokeniseCommandString(line[len(spl[0]):])), out, audit.Actor{Source: audit.SourceShell, User: conn.User()})
+		if err == cmd_registry.ErrPermissionDenied {
+			logging.Warning("sshserv-shell", "["+conn.User()+"]: Denied "+spl[0]+" (requires "+cmd_registry.Role(spl[0])+")")
+			out.Write([]byte("Err: " + spl[0] + " requires the " + cmd_registry.Role(spl[0]) + " role\n"))
 		}
 	}
 }
@@ -8,8 +8,9 @@ import (
 
 func initServConfig() (err error) {
 	gConfig = &ssh.ServerConfig{
-		PasswordCallback:  passwordCheck,
-		PublicKeyCallback: publicKeyCheck,
+		PasswordCallback:            passwordCheck,
+		PublicKeyCallback:           publicKeyOrCertificateCheck,
+		KeyboardInteractiveCallback: keyboardInteractiveCheck,
 	}
 
 	private, err := ssh.ParsePrivateKey([]byte(config.All().SSH.PrivPEM))
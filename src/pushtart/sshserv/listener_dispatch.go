@@ -39,6 +39,7 @@ func handshakeSocket(newConn net.Conn) {
 		logging.Error("sshserv-handshake", err.Error()+": "+newConn.RemoteAddr().String())
 		return
 	}
+	recordKeyUse(sshConn)
 	go ssh.DiscardRequests(reqs) // The incoming Request channel must be serviced.
 	serviceSSHConnection(sshConn, chans)
 }
@@ -67,6 +68,13 @@ func serviceSSHChannel(conn *ssh.ServerConn, channel ssh.Channel, requests <-cha
 		switch req.Type {
 
 		case "shell":
+			if _, isDeployKey := deployKeyTart(conn); isDeployKey {
+				logging.Warning("sshserv-service", "Shell request refused for deploy key ("+conn.RemoteAddr().String()+")")
+				req.Reply(false, nil)
+				channel.Stderr().Write([]byte("Deploy keys may only be used to git push/fetch.\r\n"))
+				channel.Close()
+				continue
+			}
 			req.Reply(true, nil)
 			go shell(conn, channel)
 
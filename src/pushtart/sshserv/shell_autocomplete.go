@@ -38,6 +38,12 @@ func autocomplete(line string, pos int, key rune) (newLine string, newPos int, o
 				return newLine, len(newLine), true
 			}
 
+		case spl[len(spl)-2] == "--group":
+			if match := util.BestPrefixMatch(spl[len(spl)-1], user.ListGroups()); match != "" {
+				newLine := strings.Join(spl[0:len(spl)-1], " ") + " " + match
+				return newLine, len(newLine), true
+			}
+
 		case spl[len(spl)-2] == "--extension":
 			if match := util.BestPrefixMatch(spl[len(spl)-1], []string{"DNSServ", "HTTPProxy"}); match != "" {
 				newLine := strings.Join(spl[0:len(spl)-1], " ") + " " + match
@@ -88,23 +94,49 @@ func tryMatchExtensionOperation(line string, spl []string) (newLine string, curs
 
 var operationsByExtension = map[string][]string{
 	"DNSSERV":   []string{"set-record", "delete-record", "enable", "enable-recursion", "disable", "disable-recursion", "set-listener"},
-	"HTTPPROXY": []string{"enable", "disable", "set-listener", "set-default-domain", "set-domain-proxy", "delete-domain-proxy", "add-authorization-rule", "remove-authorization-rule"},
+	"HTTPPROXY": []string{"enable", "disable", "set-listener", "set-default-domain", "set-domain-proxy", "delete-domain-proxy", "set-path-route", "delete-path-route", "add-upstream", "remove-upstream", "set-load-balancing", "set-streaming-options", "set-static-site", "set-error-pages", "set-maintenance", "add-authorization-rule", "remove-authorization-rule", "set-trusted-proxies"},
 }
 
 var commandParams = map[string][]string{
-	"edit-user":         []string{"--username", "--password", "--name", "--allow-ssh-password"},
-	"make-user":         []string{"--username", "--password", "--name", "--allow-ssh-password"},
-	"delete-user":       []string{"--username"},
-	"start-tart":        []string{"--tart"},
-	"stop-tart":         []string{"--tart"},
-	"edit-tart":         []string{"--tart", "--name", "--set-env", "--delete-env", "--log-stdout"},
-	"tart-restart-mode": []string{"--tart", "--enabled", "--lull-period"},
-	"extension":         []string{"--extension", "--operation", "--domain", "--type"},
-	"set-config-value":  []string{"--field", "--value"},
-	"get-config-value":  []string{"--field"},
-	"tart-add-owner":    []string{"--username", "--tart"},
-	"tart-remove-owner": []string{"--username", "--tart"},
-	"digest-tartconfig": []string{"--tart"},
-	"new-tart":          []string{"--tart"},
-	"extension-help":    []string{"--extension"},
+	"edit-user":               []string{"--username", "--password", "--name", "--allow-ssh-password", "--role"},
+	"make-user":               []string{"--username", "--password", "--name", "--allow-ssh-password", "--role"},
+	"delete-user":             []string{"--username"},
+	"make-group":              []string{"--group", "--description"},
+	"delete-group":            []string{"--group"},
+	"group-add-member":        []string{"--group", "--username"},
+	"group-remove-member":     []string{"--group", "--username"},
+	"ls-ssh-keys":             []string{"--username"},
+	"add-ssh-key":             []string{"--username", "--key", "--label"},
+	"remove-ssh-key":          []string{"--username", "--fingerprint"},
+	"enable-2fa":              []string{"--username"},
+	"disable-2fa":             []string{"--username"},
+	"add-ssh-ca":              []string{"--label", "--key"},
+	"remove-ssh-ca":           []string{"--ca"},
+	"ssh-ca-map-principal":    []string{"--ca", "--principal", "--username"},
+	"revoke-ssh-cert":         []string{"--ca", "--serial", "--key-fingerprint"},
+	"generate-api-key":        []string{"--service", "--scopes", "--tarts", "--expires", "--username"},
+	"audit-log":               []string{"--user", "--actor", "--source", "--action", "--tart", "--since", "--limit"},
+	"clear-auth-block":        []string{"--address", "--username", "--all"},
+	"revoke-api-key":          []string{"--id"},
+	"start-tart":              []string{"--tart"},
+	"stop-tart":               []string{"--tart"},
+	"edit-tart":               []string{"--tart", "--name", "--set-env", "--delete-env", "--log-stdout", "--replicas", "--maintenance"},
+	"delete-tart":             []string{"--tart", "--delete-owned"},
+	"tart-restart-mode":       []string{"--tart", "--enabled", "--lull-period"},
+	"extension":               []string{"--extension", "--operation", "--domain", "--type", "--username", "--group", "--cidr", "--path", "--targethost", "--targetport", "--scheme", "--strip-prefix", "--position", "--weight", "--policy", "--flush-interval", "--idle-timeout", "--dir", "--spa", "--max-age", "--builtin", "--enabled", "--retry-after", "--tart", "--addresses"},
+	"set-config-value":        []string{"--field", "--value"},
+	"get-config-value":        []string{"--field"},
+	"tart-add-owner":          []string{"--username", "--group", "--tart"},
+	"tart-remove-owner":       []string{"--username", "--group", "--tart"},
+	"tart-add-reader":         []string{"--username", "--tart"},
+	"tart-remove-reader":      []string{"--username", "--tart"},
+	"tart-add-deploy-key":     []string{"--tart", "--label", "--key"},
+	"tart-remove-deploy-key":  []string{"--tart", "--fingerprint"},
+	"tart-ls-deploy-keys":     []string{"--tart"},
+	"tart-add-oidc-client":    []string{"--tart", "--label", "--redirect-uri"},
+	"tart-remove-oidc-client": []string{"--tart", "--client-id"},
+	"tart-ls-oidc-clients":    []string{"--tart"},
+	"digest-tartconfig":       []string{"--tart"},
+	"new-tart":                []string{"--tart"},
+	"extension-help":          []string{"--extension"},
 }
@@ -1,7 +1,10 @@
 package tartmanager
 
 import (
+	"os"
 	"pushtart/config"
+	"pushtart/logging"
+	"pushtart/user"
 )
 
 //Exists returns true if a tart with the given pushURL exists.
@@ -47,6 +50,81 @@ func New(pushURL, owner string) {
 	})
 }
 
+//Delete removes the tart with the given pushURL from the system, stopping it and erasing its repository and deployment.
+//Domain proxies and DNS records owned by the tart are deleted if deleteOwned is set, otherwise they are detached from the tart.
+func Delete(pushURL string, deleteOwned bool) error {
+	if !Exists(pushURL) {
+		return ErrTartNotFound
+	}
+	if err := ValidatePushURL(pushURL); err != nil {
+		return err
+	}
+
+	if Get(pushURL).AnyRunning() {
+		if err := Stop(pushURL); err != nil {
+			return err
+		}
+	}
+
+	releaseOwnedResources(pushURL, deleteOwned)
+	delete(config.All().Tarts, pushURL)
+	config.Flush()
+
+	if err := os.RemoveAll(getDeploymentPath(pushURL)); err != nil {
+		logging.Error("tartmanager-delete", "Failed to delete deployment directory: "+err.Error())
+		return err
+	}
+	os.RemoveAll(getDeploymentPath(pushURL) + "~old") //left by a rolling deploy which failed
+	if err := os.RemoveAll(getRepoPath(pushURL)); err != nil {
+		logging.Error("tartmanager-delete", "Failed to delete repository directory: "+err.Error())
+		return err
+	}
+	logging.Info("tartmanager-delete", "Deleted "+pushURL)
+	return nil
+}
+
+func releaseOwnedResources(pushURL string, deleteOwned bool) {
+	for domain, proxy := range config.All().Web.DomainProxies {
+		if proxy.OwnerTart == pushURL {
+			if deleteOwned {
+				delete(config.All().Web.DomainProxies, domain)
+				continue
+			}
+			proxy.OwnerTart = ""
+		}
+		if proxy.Static != nil && proxy.Static.Tart == pushURL { //never serve the files of a later tart at this pushURL
+			proxy.Static = nil
+		}
+		if proxy.ErrorPages != nil && proxy.ErrorPages.Tart == pushURL {
+			proxy.ErrorPages = nil
+		}
+		var routes []config.PathRoute
+		for _, route := range proxy.Routes {
+			if route.OwnerTart == pushURL {
+				if deleteOwned {
+					continue
+				}
+				route.OwnerTart = ""
+			}
+			routes = append(routes, route)
+		}
+		proxy.Routes = routes
+		config.All().Web.DomainProxies[domain] = proxy
+	}
+	for _, records := range []map[string]config.ARecord{config.All().DNS.ARecord, config.All().DNS.AAAARecord} {
+		for domain, record := range records {
+			if record.OwnerTart == pushURL {
+				if deleteOwned {
+					delete(records, domain)
+				} else {
+					record.OwnerTart = ""
+					records[domain] = record
+				}
+			}
+		}
+	}
+}
+
 //List returns a []string of all the tarts in the system.
 func List() []string {
 	var output []string
@@ -65,3 +143,39 @@ func UserHasTartOwnership(user string, owners []string) bool {
 	}
 	return false
 }
+
+//UserOwnsTart returns true if the given user is an owner of the given tart, directly or through one of its owner groups.
+func UserOwnsTart(username string, tart config.Tart) bool {
+	if username == DeployKeyUser(tart.PushURL) {
+		return true
+	}
+	return UserHasTartOwnership(username, tart.Owners) || user.InAnyGroup(username, tart.OwnerGroups)
+}
+
+//UserCanManageTart returns true if the given user is an owner of the given tart, or an operator or admin.
+func UserCanManageTart(username string, tart config.Tart) bool {
+	return user.HasRole(username, user.RoleOperator) || UserOwnsTart(username, tart)
+}
+
+//UserCanReadTart returns true if the given user is an owner or reader of the given tart, or an operator or admin.
+func UserCanReadTart(username string, tart config.Tart) bool {
+	return UserCanManageTart(username, tart) || UserHasTartOwnership(username, tart.Readers)
+}
+
+//UserCanModifyResource returns true if the given user may modify a domain proxy or DNS record with the given owners.
+//Admins may modify anything, and operators anything which is owned. Resources without an owner may only be modified by admins.
+func UserCanModifyResource(username, ownerUser, ownerTart string) bool {
+	if user.HasRole(username, user.RoleAdmin) {
+		return true
+	}
+	if ownerUser == "" && ownerTart == "" {
+		return false
+	}
+	if ownerUser == username || user.HasRole(username, user.RoleOperator) {
+		return true
+	}
+	if ownerTart != "" && Exists(ownerTart) {
+		return UserOwnsTart(username, Get(ownerTart))
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"pushtart/user"
+	"testing"
+)
+
+func TestUserCanModifyResource(t *testing.T) {
+	config.All().Users["admin"] = config.User{Role: user.RoleAdmin}
+	config.All().Users["op"] = config.User{Role: user.RoleOperator}
+	config.All().Users["dev"] = config.User{Role: user.RoleDeveloper}
+	config.All().Users["member"] = config.User{Role: user.RoleDeveloper}
+	config.All().Users["reader"] = config.User{Role: user.RoleDeveloper}
+	config.All().Groups = map[string]config.Group{"team": {Members: []string{"member"}}, "empty": {}}
+	config.All().Tarts["/team/app"] = config.Tart{PushURL: "/team/app", OwnerGroups: []string{"team"}, Readers: []string{"reader"}}
+	config.All().Tarts["/dev/app"] = config.Tart{PushURL: "/dev/app", Owners: []string{"dev"}, OwnerGroups: []string{"empty"}}
+	defer func() {
+		for _, username := range []string{"admin", "op", "dev", "member", "reader"} {
+			delete(config.All().Users, username)
+		}
+		config.All().Groups = nil
+		delete(config.All().Tarts, "/team/app")
+		delete(config.All().Tarts, "/dev/app")
+	}()
+
+	tests := []struct {
+		name      string
+		username  string
+		ownerUser string
+		ownerTart string
+		want      bool
+	}{
+		{"admin, unowned", "admin", "", "", true},
+		{"operator, unowned", "op", "", "", false},
+		{"operator, owned", "op", "dev", "", true},
+		{"owner user", "dev", "dev", "", true},
+		{"other user", "member", "dev", "", false},
+		{"direct owner of the tart", "dev", "", "/dev/app", true},
+		{"member of an owner group", "member", "", "/team/app", true},
+		{"member of an owner group of another tart", "member", "", "/dev/app", false},
+		{"reader of the tart", "reader", "", "/team/app", false},
+		{"non-member", "dev", "", "/team/app", false},
+		{"tart which no longer exists", "member", "", "/gone/app", false},
+	}
+	for _, test := range tests {
+		if got := UserCanModifyResource(test.username, test.ownerUser, test.ownerTart); got != test.want {
+			t.Errorf("%s: UserCanModifyResource(%q, %q, %q) = %v, want %v", test.name, test.username, test.ownerUser, test.ownerTart, got, test.want)
+		}
+	}
+
+	//removing a user from the group takes their ownership away immediately
+	config.All().Groups["team"] = config.Group{}
+	if UserCanModifyResource("member", "", "/team/app") {
+		t.Error("former group member may still modify the tart's resources")
+	}
+}
@@ -0,0 +1,34 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"strings"
+)
+
+//FindDeployKey returns the pushURL of the tart which has a deploy key with the given fingerprint, and the key itself.
+func FindDeployKey(fingerprint string) (pushURL string, key config.DeployKey, ok bool) {
+	for pushURL, tart := range config.All().Tarts {
+		for _, key := range tart.DeployKeys {
+			if key.Fingerprint == fingerprint {
+				return pushURL, key, true
+			}
+		}
+	}
+	return "", config.DeployKey{}, false
+}
+
+//DeployKeyUser returns the identity git operations by a deploy key of the tart are performed as. It is not a real
+//user, so it has only the developer role, and it owns only the tart - a tartconfig pushed with a deploy key runs
+//with no more permissions than that, rather than those of one of the tart's owners.
+func DeployKeyUser(pushURL string) string {
+	return "deploy-key:" + pushURL
+}
+
+//DeployKeyActingUser returns the identity used for git operations on pushURL, performed by a deploy key of the tart
+//deployKeyTart. ok is false if the deploy key may not be used for pushURL.
+func DeployKeyActingUser(deployKeyTart, pushURL string) (user string, ok bool) {
+	if strings.TrimPrefix(deployKeyTart, "/") != strings.TrimPrefix(pushURL, "/") || !Exists(deployKeyTart) {
+		return "", false
+	}
+	return DeployKeyUser(deployKeyTart), true
+}
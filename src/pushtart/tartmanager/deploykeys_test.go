@@ -0,0 +1,58 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"pushtart/user"
+	"testing"
+)
+
+func TestDeployKeyActingUser(t *testing.T) {
+	config.All().Users["admin"] = config.User{Role: user.RoleAdmin}
+	config.All().Tarts["/admin/app"] = config.Tart{PushURL: "/admin/app", Owners: []string{"admin"}}
+	config.All().Tarts["/team/app"] = config.Tart{PushURL: "/team/app", OwnerGroups: []string{"team"}}
+	config.All().Tarts["/other/app"] = config.Tart{PushURL: "/other/app", Owners: []string{"admin"}}
+	defer func() {
+		delete(config.All().Users, "admin")
+		for _, pushURL := range []string{"/admin/app", "/team/app", "/other/app"} {
+			delete(config.All().Tarts, pushURL)
+		}
+	}()
+
+	tests := []struct {
+		name          string
+		deployKeyTart string
+		pushURL       string
+		ok            bool
+	}{
+		{"own tart", "/admin/app", "/admin/app", true},
+		{"own tart without leading slash", "/admin/app", "admin/app", true},
+		{"tart owned only by a group", "/team/app", "/team/app", true},
+		{"other tart", "/admin/app", "/other/app", false},
+		{"tart which no longer exists", "/gone/app", "/gone/app", false},
+	}
+	for _, test := range tests {
+		usr, ok := DeployKeyActingUser(test.deployKeyTart, test.pushURL)
+		if ok != test.ok {
+			t.Errorf("%s: DeployKeyActingUser() ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if ok && usr != DeployKeyUser(test.deployKeyTart) {
+			t.Errorf("%s: DeployKeyActingUser() = %q, want the deploy key's own identity", test.name, usr)
+		}
+	}
+
+	//the identity does not take on the role of the tart's owner, and can only manage its own tart
+	identity := DeployKeyUser("/admin/app")
+	if role := user.Role(identity); role != user.RoleDeveloper {
+		t.Errorf("deploy key role = %q, want %q", role, user.RoleDeveloper)
+	}
+	if !UserCanManageTart(identity, Get("/admin/app")) {
+		t.Error("deploy key cannot manage its own tart")
+	}
+	if UserCanManageTart(identity, Get("/other/app")) || UserCanReadTart(identity, Get("/other/app")) {
+		t.Error("deploy key can access another tart of the same owner")
+	}
+	if UserCanModifyResource(identity, "admin", "") || !UserCanModifyResource(identity, "", "/admin/app") {
+		t.Error("deploy key may only modify resources owned by its tart")
+	}
+}
@@ -0,0 +1,10 @@
+package tartmanager
+
+import (
+	"pushtart/config/configtest"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	configtest.Run(m)
+}
@@ -0,0 +1,75 @@
+package tartmanager
+
+import (
+	"pushtart/logging"
+	"sync"
+	"time"
+)
+
+//maxDeployTime is how long a tart is considered to be deploying after a push begins, in case the push never finishes.
+const maxDeployTime = 10 * time.Minute
+
+var (
+	deployLock sync.Mutex
+	deploying  = map[string]time.Time{} //When pushes to tarts which are down until they are deployed began, keyed by pushURL.
+)
+
+//InMaintenance returns true if the tart has been put in maintenance mode, or is down while a push to it is deployed.
+func InMaintenance(pushURL string) bool {
+	if pushURL == "" {
+		return false
+	}
+	deployLock.Lock()
+	started, isDeploying := deploying[pushURL]
+	deployLock.Unlock()
+	if isDeploying && time.Since(started) < maxDeployTime {
+		return true
+	}
+	return Get(pushURL).Maintenance
+}
+
+//SetMaintenance turns maintenance mode on or off for a tart.
+func SetMaintenance(pushURL string, enabled bool) {
+	tart := Get(pushURL)
+	tart.Maintenance = enabled
+	Save(pushURL, tart)
+	if enabled {
+		logging.Info("tartmanager-maintenance", "Enabled maintenance mode for "+pushURL)
+	} else {
+		logging.Info("tartmanager-maintenance", "Disabled maintenance mode for "+pushURL)
+	}
+}
+
+func beginDeploy(pushURL string) {
+	deployLock.Lock()
+	defer deployLock.Unlock()
+	deploying[pushURL] = time.Now()
+}
+
+func endDeploy(pushURL string) {
+	deployLock.Lock()
+	defer deployLock.Unlock()
+	delete(deploying, pushURL)
+}
+
+//endDeployWhenListening ends the deploy of a tart once it accepts connections on its port, so its domains show the
+//maintenance page rather than errors while it starts.
+func endDeployWhenListening(pushURL string) {
+	deployLock.Lock()
+	started := deploying[pushURL]
+	deployLock.Unlock()
+	if tart := Get(pushURL); tart.Port != 0 && usesAllocatedPort(tart) {
+		waitForReplica(pushURL, 0)
+	}
+
+	deployLock.Lock()
+	defer deployLock.Unlock()
+	if deploying[pushURL] == started { //not if another push has begun since
+		delete(deploying, pushURL)
+	}
+}
+
+//AbortGitRecieve should be called if a push accepted by PreGitRecieve fails before PostGitRecieve is called.
+func AbortGitRecieve(pushURL string) {
+	endDeploy(pushURL)
+}
@@ -0,0 +1,71 @@
+package tartmanager
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"pushtart/config"
+	"pushtart/util"
+	"time"
+)
+
+func hashClientSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+//NewOIDCClient generates a client ID and secret for an OpenID Connect client which may redirect to the given URIs.
+//The secret is returned, and only its hash is stored in the client.
+func NewOIDCClient(label string, redirectURIs []string) (config.OIDCClient, string, error) {
+	if len(redirectURIs) == 0 {
+		return config.OIDCClient{}, "", errors.New("At least one redirect URI is required")
+	}
+	for _, uri := range redirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Fragment != "" {
+			return config.OIDCClient{}, "", errors.New("Redirect URIs must be absolute http or https URLs, without a fragment: " + uri)
+		}
+	}
+
+	secret := util.RandAlphaKey(40)
+	return config.OIDCClient{
+		ID:           util.RandAlphaKey(20),
+		Label:        label,
+		SecretHash:   hashClientSecret(secret),
+		RedirectURIs: redirectURIs,
+		Added:        time.Now().Unix(),
+	}, secret, nil
+}
+
+//FindOIDCClient returns the pushURL of the tart which has an OpenID Connect client with the given ID, and the client itself.
+func FindOIDCClient(clientID string) (pushURL string, client config.OIDCClient, ok bool) {
+	for pushURL, tart := range config.All().Tarts {
+		for _, client := range tart.OIDCClients {
+			if client.ID == clientID {
+				return pushURL, client, true
+			}
+		}
+	}
+	return "", config.OIDCClient{}, false
+}
+
+//CheckOIDCClient returns the client with the given ID, if secret is its client secret.
+func CheckOIDCClient(clientID, secret string) (config.OIDCClient, bool) {
+	_, client, ok := FindOIDCClient(clientID)
+	if !ok || subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashClientSecret(secret))) != 1 {
+		return config.OIDCClient{}, false
+	}
+	return client, true
+}
+
+//OIDCClientAllowsRedirect returns true if uri is one of the client's registered redirect URIs.
+func OIDCClientAllowsRedirect(client config.OIDCClient, uri string) bool {
+	for _, allowed := range client.RedirectURIs {
+		if allowed == uri {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"pushtart/audit"
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/sshserv/cmd_registry"
@@ -18,6 +19,10 @@ import (
 // ErrTartOperationNotAuthorized is returned if the pushing user is not allowed to perform that action.
 var ErrTartOperationNotAuthorized = errors.New("You are not authorized to perform the requested action.")
 
+// ErrRepositoryNotFound is returned if a fetch is requested for a tart which does not exist, or which the user cannot read.
+// The same error is used in both cases so the existence of a tart is not disclosed.
+var ErrRepositoryNotFound = errors.New("Repository not found, or you do not have permission to read it.")
+
 func getRepoPath(pushURL string) string {
 	return path.Join(config.All().DataPath, pushURL)
 }
@@ -30,9 +35,18 @@ func checkCreateRepo(pushURL, owner string) error {
 
 	if tartExists := Exists(pushURL); !tartExists {
 		logging.Info("tartmanager-git-hooks", "Recieving git push for previously-unknown tart ("+pushURL+").")
+		if err := CheckPushURLNamespace(pushURL, owner); err != nil {
+			logging.Warning("tartmanager-git-hooks", "Aborting git-recieve for tart '"+pushURL+"': "+err.Error())
+			return err
+		}
+		if err := checkPushURLOverlap(pushURL); err != nil {
+			logging.Warning("tartmanager-git-hooks", "Aborting git-recieve for tart '"+pushURL+"': "+err.Error())
+			return err
+		}
+
 		exist, _ := util.DirExists(repoPath)
 		if !exist {
-			err := os.Mkdir(repoPath, 0777)
+			err := os.MkdirAll(repoPath, 0777)
 			if err != nil {
 				logging.Error("tartmanager-git-hooks", "Error creating repository directory: "+err.Error())
 				return err
@@ -52,12 +66,15 @@ func checkCreateRepo(pushURL, owner string) error {
 		logging.Info("tartmanager-git-hooks", "Receiving git push for existing tart: "+pushURL)
 		tart := Get(pushURL)
 
-		if !UserHasTartOwnership(owner, tart.Owners) {
+		if !UserCanManageTart(owner, tart) {
 			logging.Warning("tartmanager-git-hooks", "Aborting git-recieve for tart '"+pushURL+"'. Pushing user is not the owner of the tart.")
 			return ErrTartOperationNotAuthorized
 		}
 
-		if tart.IsRunning {
+		if !rollingDeploy(tart) { //domains serving the tart show a maintenance page until it is deployed
+			beginDeploy(pushURL)
+		}
+		if tart.AnyRunning() && !rollingDeploy(tart) {
 			err := Stop(pushURL)
 			if err != nil {
 				logging.Info("tartmanager-git-hooks", "Failed to stop tart: "+err.Error())
@@ -72,15 +89,47 @@ func checkCreateRepo(pushURL, owner string) error {
 // PreGitRecieve is called by the sshserv package when a git push is received. It initializes a new repository if one does not already
 // exist.
 func PreGitRecieve(pushURL, owner string) error {
+	if err := ValidatePushURL(pushURL); err != nil {
+		logging.Warning("tartmanager-git-hooks", "Aborting git-recieve for tart '"+pushURL+"': "+err.Error())
+		return err
+	}
 	return checkCreateRepo(pushURL, owner)
 }
 
+// PreGitUpload is called by the sshserv package when a git fetch/clone is received. It returns ErrRepositoryNotFound
+// unless the tart exists and the user is allowed to read it.
+func PreGitUpload(pushURL, user string) error {
+	if !Exists(pushURL) || !UserCanReadTart(user, Get(pushURL)) {
+		logging.Warning("tartmanager-git-hooks", "Refusing git-upload for tart '"+pushURL+"' to "+user+".")
+		return ErrRepositoryNotFound
+	}
+	return nil
+}
+
+// rollingDeploy returns true if a push to the tart should restart its replicas one at a time, rather than stopping
+// it while the push is received.
+func rollingDeploy(tart config.Tart) bool {
+	return tart.ReplicaCount() > 1 && tart.AnyRunning()
+}
+
 // PostGitRecieve is called after a successful git push. It erases the old deployment if one exists, deploys the new files,
-// updates (or creates) the tart object, and finally launches the tart.
-func PostGitRecieve(pushURL, owner string) error {
+// updates (or creates) the tart object, and finally launches the tart. Output from the tartconfig (if any) is written to writer.
+// Running tarts with several replicas are deployed alongside the old deployment, then restarted one replica at a time.
+func PostGitRecieve(pushURL, owner string, writer *io.Writer) error {
+	started := false //once the tart is started, it is shown as deploying until it is listening
+	defer func() {
+		if !started {
+			endDeploy(pushURL)
+		}
+	}()
+	rolling := Exists(pushURL) && rollingDeploy(Get(pushURL))
+	deploymentPath := getDeploymentPath(pushURL)
 	if !Exists(pushURL) {
 		logging.Info("tartmanager-git-hooks", "Registering new tart.")
 		New(pushURL, owner)
+	} else if rolling {
+		deploymentPath += "~next" //~ cannot appear in a pushURL
+		os.RemoveAll(deploymentPath)
 	} else {
 		logging.Info("tartmanager-git-hooks", "Deleting old deployment directory.")
 		cmd := exec.Command("rm", "-rf", getDeploymentPath(pushURL))
@@ -89,35 +138,71 @@ func PostGitRecieve(pushURL, owner string) error {
 
 	saveCurrentCommitInformation(pushURL)
 
-	cmd := exec.Command("mkdir", getDeploymentPath(pushURL))
-	_, err := cmd.Output()
+	err := os.MkdirAll(deploymentPath, 0777)
 	if err != nil {
 		logging.Error("tartmanager-git-hooks", "Failed to create deployment directory: "+err.Error())
 		return err
 	}
 
-	cmd = exec.Command("git", "clone", getRepoPath(pushURL), "./")
-	cmd.Dir = getDeploymentPath(pushURL)
+	cmd := exec.Command("git", "clone", getRepoPath(pushURL), "./")
+	cmd.Dir = deploymentPath
 	_, err = cmd.Output()
 	if err != nil {
 		logging.Error("tartmanager-git-hooks", "Failed to clone repository to deployment directory: "+err.Error())
 		return err
 	}
 
+	oldDeploymentPath := getDeploymentPath(pushURL) + "~old"
+	if rolling { //running replicas keep their working directory when the old deployment is moved aside
+		os.RemoveAll(oldDeploymentPath)
+		if err = os.Rename(getDeploymentPath(pushURL), oldDeploymentPath); err == nil {
+			err = os.Rename(deploymentPath, getDeploymentPath(pushURL))
+		}
+		if err != nil {
+			logging.Error("tartmanager-git-hooks", "Failed to replace deployment directory: "+err.Error())
+			return err
+		}
+	}
+
 	//Check if there is a tartconfig file
 	if exists, _ := util.FileExists(path.Join(getDeploymentPath(pushURL), "tartconfig")); exists {
-		err = ExecuteCommandFile(path.Join(getDeploymentPath(pushURL), "tartconfig"), pushURL, nil)
+		err = ExecuteCommandFile(path.Join(getDeploymentPath(pushURL), "tartconfig"), pushURL, owner, writer)
 		if err != nil {
 			logging.Error("tartmanager-git-hooks", "Failed to execute tartconfig: "+err.Error())
 			return err
 		}
 	}
 
+	if rolling && Get(pushURL).AnyRunning() {
+		err = rollingRestart(pushURL)
+		if err != nil {
+			logging.Error("tartmanager-git-hooks", "Failed to restart tart: "+err.Error())
+			return err
+		}
+		return os.RemoveAll(oldDeploymentPath) //only once no replica is running from it
+	}
 	err = Start(pushURL)
+	if err == ErrNoStartupScript && servesStaticSite(pushURL) {
+		logging.Info("tartmanager-git-hooks", "Deployed static site "+pushURL+" - nothing to start.")
+		return nil
+	}
 	if err != nil {
 		logging.Error("tartmanager-git-hooks", "Failed to start tart: "+err.Error())
+		return err
+	}
+	started = true
+	go endDeployWhenListening(pushURL)
+	return nil
+}
+
+// servesStaticSite returns true if any domain proxy serves files from the tart's deployment.
+func servesStaticSite(pushURL string) bool {
+	for _, proxy := range config.All().Web.DomainProxies {
+		if proxy.Static != nil && proxy.Static.Tart == pushURL {
+			return true
+		}
 	}
-	return err
+	return false
 }
 
 func saveCurrentCommitInformation(pushURL string) {
@@ -144,11 +229,16 @@ func saveCurrentCommitInformation(pushURL string) {
 }
 
 // ExecuteCommandFile takes the given file, and executes all the lines of the file as tart commands, in the context of the given pushURL.
-func ExecuteCommandFile(fPath, pushURL string, writer *io.Writer) error {
+// Commands are run with the permissions of the given user, and only commands which affect the tart are permitted. Refused lines are
+// logged and written to writer (if not nil).
+func ExecuteCommandFile(fPath, pushURL, user string, writer *io.Writer) error {
 	b, err := ioutil.ReadFile(fPath)
 	if err != nil {
 		return err
 	}
+	if user == "" {
+		return ErrTartconfigNoUser
+	}
 
 	getVarFunc := func(vari string) string {
 		return getVarName(pushURL, vari)
@@ -165,12 +255,29 @@ func ExecuteCommandFile(fPath, pushURL string, writer *io.Writer) error {
 		}
 		spl := strings.Split(line, " ")
 		logging.Info("tartconfig-exec", "["+pushURL+"] "+line)
-		if ok, runFunc := cmd_registry.Command(spl[0]); ok {
-			cmd := util.ParseCommands(util.TokeniseCommandString(line[len(spl[0]):]))
-			if _, ok := cmd["tart"]; !ok {
-				cmd["tart"] = pushURL
+		cmd := util.ParseCommands(util.TokeniseCommandString(line[len(spl[0]):]))
+		if _, ok := cmd["tart"]; !ok {
+			cmd["tart"] = pushURL
+		}
+		if err := checkTartconfigCommand(spl[0], cmd, pushURL); err != nil {
+			logging.Warning("tartconfig-exec", "["+pushURL+"] Refused '"+line+"': "+err.Error())
+			if writer != nil {
+				(*writer).Write([]byte("Refused: " + err.Error() + "\r\n"))
+			}
+			continue
+		}
+		err := cmd_registry.Run(spl[0], cmd, &commandOutputRewriter{PushURL: pushURL, Out: writer}, audit.Actor{Source: audit.SourceTartconfig, User: user, Tart: pushURL})
+		switch {
+		case err == cmd_registry.ErrPermissionDenied:
+			logging.Warning("tartconfig-exec", "["+pushURL+"] Refused '"+line+"': "+user+" does not have the "+cmd_registry.Role(spl[0])+" role")
+			if writer != nil {
+				(*writer).Write([]byte("Refused: " + spl[0] + " requires the " + cmd_registry.Role(spl[0]) + " role\r\n"))
+			}
+		case err != nil:
+			logging.Warning("tartconfig-exec", "["+pushURL+"] Failed '"+line+"': "+err.Error())
+			if writer != nil {
+				(*writer).Write([]byte("Failed: " + err.Error() + "\r\n"))
 			}
-			runFunc(cmd, &commandOutputRewriter{PushURL: pushURL}, "")
 		}
 	}
 	return nil
@@ -191,9 +298,13 @@ func getVarName(pushURL, vari string) string {
 
 type commandOutputRewriter struct {
 	PushURL string
+	Out     *io.Writer
 }
 
 func (c *commandOutputRewriter) Write(p []byte) (n int, err error) {
 	logging.Info("tartconfig-exec", "["+c.PushURL+"] "+strings.Replace(string(p), "\n", "", -1))
+	if c.Out != nil {
+		(*c.Out).Write([]byte(strings.Replace(string(p), "\n", "\r\n", -1)))
+	}
 	return len(p), nil
 }
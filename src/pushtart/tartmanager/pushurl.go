@@ -0,0 +1,70 @@
+package tartmanager
+
+import (
+	"errors"
+	"pushtart/config"
+	"pushtart/constants"
+	"pushtart/user"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidPushURL is returned if a pushURL contains characters or path elements which are not permitted.
+var ErrInvalidPushURL = errors.New("Invalid pushURL - only letters, numbers, '.', '_' and '-' are allowed, in segments separated by '/'")
+
+// ErrPushURLTooDeep is returned if a pushURL has more segments than constants.MaxPushURLDepth.
+var ErrPushURLTooDeep = errors.New("pushURL may have at most " + strconv.Itoa(constants.MaxPushURLDepth) + " segments")
+
+// ErrPushURLNotInNamespace is returned if tart namespacing is enabled, and a user attempts to create a tart outside /<username>/.
+var ErrPushURLNotInNamespace = errors.New("New tarts must be created under /<your-username>/")
+
+// ErrPushURLOverlaps is returned if a new pushURL would be nested within an existing tart, or an existing tart within it.
+var ErrPushURLOverlaps = errors.New("pushURL overlaps with an existing tart")
+
+//segments may not begin with a '.', which also rules out '.' and '..'.
+var pushURLSegmentRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-][a-zA-Z0-9._-]*$`)
+
+func pushURLSegments(pushURL string) []string {
+	return strings.Split(strings.TrimPrefix(pushURL, "/"), "/")
+}
+
+// ValidatePushURL returns an error if the given pushURL is malformed, or could resolve to a path outside of the data directories.
+func ValidatePushURL(pushURL string) error {
+	segments := pushURLSegments(pushURL)
+	if len(segments) > constants.MaxPushURLDepth {
+		return ErrPushURLTooDeep
+	}
+	for _, segment := range segments {
+		if !pushURLSegmentRegexp.MatchString(segment) {
+			return ErrInvalidPushURL
+		}
+	}
+	return nil
+}
+
+// CheckPushURLNamespace returns ErrPushURLNotInNamespace if tart namespacing is enabled and the given user may not
+// create a tart at pushURL. Admins (including the console user) are exempt.
+func CheckPushURLNamespace(pushURL, username string) error {
+	if !config.All().NamespaceTarts || user.HasRole(username, user.RoleAdmin) {
+		return nil
+	}
+	segments := pushURLSegments(pushURL)
+	if len(segments) < 2 || segments[0] != username {
+		return ErrPushURLNotInNamespace
+	}
+	return nil
+}
+
+// checkPushURLOverlap returns ErrPushURLOverlaps if a repository or deployment for pushURL would be nested
+// within that of an existing tart (or vice versa).
+func checkPushURLOverlap(pushURL string) error {
+	newURL := "/" + strings.TrimPrefix(pushURL, "/") + "/"
+	for existing := range config.All().Tarts {
+		existingURL := "/" + strings.TrimPrefix(existing, "/") + "/"
+		if strings.HasPrefix(newURL, existingURL) || strings.HasPrefix(existingURL, newURL) {
+			return ErrPushURLOverlaps
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"testing"
+)
+
+func TestValidatePushURL(t *testing.T) {
+	tests := []struct {
+		pushURL string
+		want    error
+	}{
+		{"/alice/app", nil},
+		{"alice/app", nil},
+		{"/alice/my-app_2.0", nil},
+		{"/a/b/c/d", nil},
+		{"/a/b/c/d/e", ErrPushURLTooDeep},
+		{"/alice/..", ErrInvalidPushURL},
+		{"/../etc", ErrInvalidPushURL},
+		{"/alice/../bob/app", ErrInvalidPushURL},
+		{"/alice/.", ErrInvalidPushURL},
+		{"/alice/.git", ErrInvalidPushURL},
+		{"/.alice/app", ErrInvalidPushURL},
+		{"/alice//app", ErrInvalidPushURL},
+		{"/alice/app/", ErrInvalidPushURL},
+		{"/", ErrInvalidPushURL},
+		{"/alice/app~next", ErrInvalidPushURL},
+		{"/alice/a pp", ErrInvalidPushURL},
+		{"/alice/app\\..\\x", ErrInvalidPushURL},
+	}
+	for _, test := range tests {
+		if got := ValidatePushURL(test.pushURL); got != test.want {
+			t.Errorf("ValidatePushURL(%q) = %v, want %v", test.pushURL, got, test.want)
+		}
+	}
+}
+
+func TestCheckPushURLNamespace(t *testing.T) {
+	config.All().Users["alice"] = config.User{Role: "developer"}
+	config.All().Users["root"] = config.User{Role: "admin"}
+	defer func() {
+		config.All().NamespaceTarts = false
+		delete(config.All().Users, "alice")
+		delete(config.All().Users, "root")
+	}()
+
+	tests := []struct {
+		namespaced bool
+		pushURL    string
+		username   string
+		want       error
+	}{
+		{false, "/bob/app", "alice", nil},
+		{true, "/alice/app", "alice", nil},
+		{true, "alice/app", "alice", nil},
+		{true, "/alice/team/app", "alice", nil},
+		{true, "/bob/app", "alice", ErrPushURLNotInNamespace},
+		{true, "/alice", "alice", ErrPushURLNotInNamespace},
+		{true, "/alicex/app", "alice", ErrPushURLNotInNamespace},
+		{true, "/bob/app", "root", nil},
+		{true, "/bob/app", "", nil}, //the console
+	}
+	for _, test := range tests {
+		config.All().NamespaceTarts = test.namespaced
+		if got := CheckPushURLNamespace(test.pushURL, test.username); got != test.want {
+			t.Errorf("CheckPushURLNamespace(%q, %q) with namespacing %v = %v, want %v", test.pushURL, test.username, test.namespaced, got, test.want)
+		}
+	}
+}
+
+func TestCheckPushURLOverlap(t *testing.T) {
+	config.All().Tarts["/alice/app"] = config.Tart{PushURL: "/alice/app"}
+	defer delete(config.All().Tarts, "/alice/app")
+
+	tests := []struct {
+		pushURL string
+		want    error
+	}{
+		{"/alice/app2", nil},
+		{"/alice/ap", nil},
+		{"/alice/other", nil},
+		{"/bob/app", nil},
+		{"/alice/app", ErrPushURLOverlaps},
+		{"alice/app", ErrPushURLOverlaps},
+		{"/alice/app/sub", ErrPushURLOverlaps},
+		{"/alice", ErrPushURLOverlaps},
+	}
+	for _, test := range tests {
+		if got := checkPushURLOverlap(test.pushURL); got != test.want {
+			t.Errorf("checkPushURLOverlap(%q) = %v, want %v", test.pushURL, got, test.want)
+		}
+	}
+}
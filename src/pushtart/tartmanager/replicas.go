@@ -0,0 +1,190 @@
+package tartmanager
+
+import (
+	"errors"
+	"net"
+	"pushtart/config"
+	"pushtart/constants"
+	"pushtart/logging"
+	"strconv"
+	"time"
+)
+
+//MaxDeveloperReplicas is the most replicas a developer may run a tart as. Operators may run more.
+const MaxDeveloperReplicas = 8
+
+//replicaStartTimeout is how long a rolling restart waits for a replica to accept connections on its port.
+const replicaStartTimeout = 30 * time.Second
+
+//allocatePort returns the port allocated to the i'th (from 0) instance of the tart, allocating the lowest free port
+//if it does not have one yet. Ports tarts set in their own PORT env are not free.
+func allocatePort(pushURL string, i int) int {
+	tart := Get(pushURL)
+	replica := tart.Replica(i)
+	if replica.Port != 0 {
+		return replica.Port
+	}
+
+	used := map[int]bool{}
+	for _, t := range config.All().Tarts {
+		for r := 0; r <= len(t.Instances); r++ {
+			used[t.Replica(r).Port] = true
+		}
+		used[envPort(t.Env)] = true
+	}
+	port := config.All().Web.TartPortStart
+	if port <= 0 {
+		port = constants.DefaultTartPortStart
+	}
+	for used[port] {
+		port++
+	}
+
+	replica.Port = port
+	tart.SetReplica(i, replica)
+	Save(pushURL, tart)
+	logging.Info("tartmanager-run", "Allocated port ", port, " to "+pushURL)
+	return port
+}
+
+func isLocalHost(host string) bool {
+	return host == "127.0.0.1" || host == "localhost" || host == "::1"
+}
+
+//SyncReplicaUpstreams points the domain proxies bound to the tart - those owned by it, whose backends are all local
+//ports of its replicas (or the port in its PORT env) - at its running replicas, so requests are balanced between them.
+func SyncReplicaUpstreams(pushURL string) {
+	tart := Get(pushURL)
+	replicaPorts := map[int]bool{}
+	if port := envPort(tart.Env); port != 0 { //proxies made for the tart's own port follow it onto allocated ports
+		replicaPorts[port] = true
+	}
+	var running, all []int
+	for i := 0; i <= len(tart.Instances); i++ {
+		replica := tart.Replica(i)
+		if replica.Port == 0 {
+			continue
+		}
+		replicaPorts[replica.Port] = true
+		if i < tart.ReplicaCount() {
+			all = append(all, replica.Port)
+			if replica.IsRunning {
+				running = append(running, replica.Port)
+			}
+		}
+	}
+	if len(running) == 0 { //stopped - leave the proxies pointing at the tart
+		running = all
+	}
+	if len(running) == 0 {
+		return
+	}
+
+	changed := false
+	for domain, proxy := range config.All().Web.DomainProxies {
+		if proxy.OwnerTart != pushURL || !boundToPorts(proxy, replicaPorts) {
+			continue
+		}
+		proxy.TargetPort = running[0]
+		proxy.Upstreams = nil
+		if len(running) > 1 {
+			for _, port := range running {
+				proxy.Upstreams = append(proxy.Upstreams, config.Upstream{Host: proxy.TargetHost, Port: port, Scheme: proxy.TargetScheme, Weight: 1})
+			}
+		}
+		config.All().Web.DomainProxies[domain] = proxy
+		changed = true
+	}
+	if changed {
+		config.Flush()
+	}
+}
+
+func boundToPorts(proxy config.DomainProxy, ports map[int]bool) bool {
+	for _, backend := range proxy.Backends() {
+		if !isLocalHost(backend.Host) || !ports[backend.Port] {
+			return false
+		}
+	}
+	return true
+}
+
+//SetReplicas changes the number of instances of the tart which are run. If the tart is running, replicas are started
+//or stopped to match.
+func SetReplicas(pushURL string, n int) error {
+	if !Exists(pushURL) {
+		return ErrTartNotFound
+	}
+	if n < 1 {
+		return errors.New("A tart must have at least one replica")
+	}
+	tart := Get(pushURL)
+	running := tart.AnyRunning()
+	previous := tart.ReplicaCount()
+	tart.Replicas = n
+	Save(pushURL, tart)
+
+	if running {
+		for i := n; i <= len(tart.Instances); i++ {
+			if Get(pushURL).Replica(i).IsRunning {
+				if err := stopReplica(pushURL, i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if tart = Get(pushURL); len(tart.Instances) > n-1 {
+		tart.Instances = tart.Instances[:n-1]
+		Save(pushURL, tart)
+	}
+	if running {
+		for i := previous; i < n; i++ {
+			if err := startReplica(pushURL, i); err != nil {
+				return err
+			}
+		}
+	}
+	SyncReplicaUpstreams(pushURL)
+	return nil
+}
+
+//rollingRestart restarts the replicas of the tart one at a time, waiting for each to accept connections before moving
+//on to the next, so the tart keeps serving requests throughout.
+func rollingRestart(pushURL string) error {
+	for i := 0; i < Get(pushURL).ReplicaCount(); i++ {
+		if Get(pushURL).Replica(i).IsRunning {
+			if err := stopReplica(pushURL, i); err != nil {
+				return err
+			}
+		}
+		if err := startReplica(pushURL, i); err != nil {
+			return err
+		}
+		err := waitForReplica(pushURL, i)
+		SyncReplicaUpstreams(pushURL) //only now is the replica sent requests
+		if err != nil {
+			return err
+		}
+	}
+	logging.Info("tartmanager-run", "Restarted all replicas of "+pushURL)
+	return nil
+}
+
+//waitForReplica waits for the i'th instance of the tart to accept connections on its port, returning an error if it
+//exits first. A replica which is not listening after replicaStartTimeout is assumed not to serve on its port.
+func waitForReplica(pushURL string, i int) error {
+	deadline := time.Now().Add(replicaStartTimeout)
+	for time.Now().Before(deadline) {
+		replica := Get(pushURL).Replica(i)
+		if !replica.IsRunning {
+			return errors.New("Replica " + strconv.Itoa(i+1) + " exited while starting")
+		}
+		if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+strconv.Itoa(replica.Port), time.Second); err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	logging.Warning("tartmanager-run", "Replica ", i+1, " of "+pushURL+" is not listening on its port, continuing anyway")
+	return nil
+}
@@ -0,0 +1,213 @@
+package tartmanager
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"pushtart/config"
+	"strconv"
+	"testing"
+)
+
+func TestEnvPort(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+		want int
+	}{
+		{"no env", nil, 0},
+		{"no PORT", []string{"HOME=/tmp"}, 0},
+		{"PORT", []string{"HOME=/tmp", "PORT=8080"}, 8080},
+		{"prefix of another name", []string{"PORTS=8080"}, 0},
+		{"not a number", []string{"PORT=http"}, 0},
+		{"out of range", []string{"PORT=70000"}, 0},
+		{"zero", []string{"PORT=0"}, 0},
+	}
+	for _, test := range tests {
+		if got := envPort(test.env); got != test.want {
+			t.Errorf("%s: envPort(%q) = %d, want %d", test.name, test.env, got, test.want)
+		}
+	}
+}
+
+func TestAllocatePort(t *testing.T) {
+	config.All().Web.TartPortStart = 9100
+	config.All().Tarts["/a/app"] = config.Tart{PushURL: "/a/app", Port: 9100, Instances: []config.Replica{{Port: 9102}}}
+	config.All().Tarts["/b/app"] = config.Tart{PushURL: "/b/app", Env: []string{"PORT=9101"}}
+	config.All().Tarts["/c/app"] = config.Tart{PushURL: "/c/app", Replicas: 3}
+	defer func() {
+		config.All().Web.TartPortStart = 0
+		for _, pushURL := range []string{"/a/app", "/b/app", "/c/app"} {
+			delete(config.All().Tarts, pushURL)
+		}
+	}()
+
+	if port := allocatePort("/c/app", 0); port != 9103 {
+		t.Errorf("first replica allocated %d, want 9103 (the lowest port not used or set in a PORT env)", port)
+	}
+	if port := allocatePort("/c/app", 2); port != 9104 {
+		t.Errorf("third replica allocated %d, want 9104", port)
+	}
+	if port := allocatePort("/c/app", 0); port != 9103 {
+		t.Errorf("first replica reallocated %d, want its existing port 9103", port)
+	}
+	if port := allocatePort("/c/app", 1); port != 9105 {
+		t.Errorf("second replica allocated %d, want 9105", port)
+	}
+}
+
+func TestSyncReplicaUpstreams(t *testing.T) {
+	local := func(ports ...int) config.DomainProxy {
+		proxy := config.DomainProxy{OwnerTart: "/a/app", TargetHost: "127.0.0.1", TargetPort: ports[0], TargetScheme: "http"}
+		if len(ports) > 1 {
+			for _, port := range ports {
+				proxy.Upstreams = append(proxy.Upstreams, config.Upstream{Host: "127.0.0.1", Port: port, Scheme: "http", Weight: 1})
+			}
+		}
+		return proxy
+	}
+	foreign := local(9200)
+	foreign.OwnerTart = "/b/app"
+	remote := local(9200)
+	remote.TargetHost = "10.0.0.5"
+	mixed := local(9200, 9300)
+
+	tests := []struct {
+		name  string
+		tart  config.Tart
+		proxy config.DomainProxy
+		want  []int //Ports the proxy should send requests to.
+	}{
+		{"all replicas running", config.Tart{Replicas: 3, IsRunning: true, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}, {IsRunning: true, Port: 9202}}}, local(9200), []int{9200, 9201, 9202}},
+		{"one replica stopped", config.Tart{Replicas: 3, IsRunning: true, Port: 9200, Instances: []config.Replica{{Port: 9201}, {IsRunning: true, Port: 9202}}}, local(9200, 9201, 9202), []int{9200, 9202}},
+		{"only one running", config.Tart{Replicas: 3, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}, {Port: 9202}}}, local(9200, 9201), []int{9201}},
+		{"stopped tart keeps every replica", config.Tart{Replicas: 2, Port: 9200, Instances: []config.Replica{{Port: 9201}}}, local(9201), []int{9200, 9201}},
+		{"replicas removed", config.Tart{Replicas: 1, IsRunning: true, Port: 9200, Instances: []config.Replica{{Port: 9201}}}, local(9200, 9201), []int{9200}},
+		{"proxy made for the tart's own PORT", config.Tart{Replicas: 2, IsRunning: true, Env: []string{"PORT=8080"}, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}}}, local(8080), []int{9200, 9201}},
+		{"proxy of another tart", config.Tart{Replicas: 2, IsRunning: true, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}}}, foreign, []int{9200}},
+		{"remote target", config.Tart{Replicas: 2, IsRunning: true, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}}}, remote, []int{9200}},
+		{"upstream which is not a replica", config.Tart{Replicas: 2, IsRunning: true, Port: 9200, Instances: []config.Replica{{IsRunning: true, Port: 9201}}}, mixed, []int{9200, 9300}},
+	}
+	for _, test := range tests {
+		test.tart.PushURL = "/a/app"
+		config.All().Tarts["/a/app"] = test.tart
+		config.All().Web.DomainProxies["app.example.com"] = test.proxy
+		SyncReplicaUpstreams("/a/app")
+
+		var got []int
+		for _, backend := range config.All().Web.DomainProxies["app.example.com"].Backends() {
+			got = append(got, backend.Port)
+		}
+		if !samePorts(got, test.want) {
+			t.Errorf("%s: proxy backends = %v, want %v", test.name, got, test.want)
+		}
+	}
+	delete(config.All().Tarts, "/a/app")
+	delete(config.All().Web.DomainProxies, "app.example.com")
+}
+
+func samePorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//runningPorts returns the ports of the running replicas of the tart, failing the test if their count is not want.
+func runningPorts(t *testing.T, pushURL string, want int) (ports []int, pids map[int]bool) {
+	tart := Get(pushURL)
+	pids = map[int]bool{}
+	for i := 0; i <= len(tart.Instances); i++ {
+		if replica := tart.Replica(i); replica.IsRunning {
+			ports = append(ports, replica.Port)
+			pids[replica.PID] = true
+		}
+	}
+	if len(ports) != want || len(pids) != want {
+		t.Fatalf("%d replicas running with %d processes, want %d", len(ports), len(pids), want)
+	}
+	return ports, pids
+}
+
+func TestReplicas(t *testing.T) {
+	pushURL := "/replicas/app"
+	if err := os.MkdirAll(getDeploymentPath(pushURL), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(getDeploymentPath(pushURL), runScriptSh), []byte("exec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	config.All().Web.TartPortStart = 9300
+	config.All().Tarts[pushURL] = config.Tart{PushURL: pushURL, Name: "replicas", Replicas: 3}
+	config.All().Web.DomainProxies["replicas.example.com"] = config.DomainProxy{OwnerTart: pushURL, TargetHost: "127.0.0.1", TargetPort: 9300, TargetScheme: "http"}
+	defer func() {
+		if Exists(pushURL) && Get(pushURL).AnyRunning() {
+			Stop(pushURL)
+		}
+		config.All().Web.TartPortStart = 0
+		delete(config.All().Tarts, pushURL)
+		delete(config.All().Web.DomainProxies, "replicas.example.com")
+	}()
+
+	if err := Start(pushURL); err != nil {
+		t.Fatal(err)
+	}
+	ports, pids := runningPorts(t, pushURL, 3)
+	if backends := config.All().Web.DomainProxies["replicas.example.com"].Backends(); len(backends) != 3 {
+		t.Errorf("proxy has %d backends after start, want 3", len(backends))
+	}
+
+	//stand in for the replicas accepting connections, so the rolling restart does not wait for them
+	for _, port := range ports {
+		l, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+	}
+	if err := rollingRestart(pushURL); err != nil {
+		t.Fatal(err)
+	}
+	newPorts, newPids := runningPorts(t, pushURL, 3)
+	if !samePorts(ports, newPorts) {
+		t.Errorf("replicas moved from ports %v to %v on restart", ports, newPorts)
+	}
+	for pid := range newPids {
+		if pids[pid] {
+			t.Errorf("process %d was not restarted", pid)
+		}
+	}
+
+	if err := SetReplicas(pushURL, 1); err != nil {
+		t.Fatal(err)
+	}
+	runningPorts(t, pushURL, 1)
+	if tart := Get(pushURL); len(tart.Instances) != 0 {
+		t.Errorf("%d instances kept after scaling down to 1, want 0", len(tart.Instances))
+	}
+	if backends := config.All().Web.DomainProxies["replicas.example.com"].Backends(); len(backends) != 1 || backends[0].Port != ports[0] {
+		t.Errorf("proxy backends = %v after scaling down, want only port %d", backends, ports[0])
+	}
+
+	if err := SetReplicas(pushURL, 2); err != nil {
+		t.Fatal(err)
+	}
+	runningPorts(t, pushURL, 2)
+
+	//deleting the tart stops every replica
+	if err := Delete(pushURL, false); err != nil {
+		t.Fatal(err)
+	}
+	if Exists(pushURL) {
+		t.Error("tart still exists after delete")
+	}
+	if proxy := config.All().Web.DomainProxies["replicas.example.com"]; proxy.OwnerTart != "" {
+		t.Errorf("proxy still owned by %q after delete", proxy.OwnerTart)
+	}
+}
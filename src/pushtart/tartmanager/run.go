@@ -5,8 +5,10 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/util"
+	"strconv"
 	"strings"
 	"time"
 
@@ -19,33 +21,70 @@ var ErrTartNotFound = errors.New("Tart not found")
 //ErrTartWrongState is returned if a stop is requested on a stopped tart, or a start is requested on a running tart.
 var ErrTartWrongState = errors.New("Tart is in the wrong state to execute that command.")
 
+//ErrNoStartupScript is returned if a tart is started without a startup.sh or startup.py in its deployment.
+var ErrNoStartupScript = errors.New("No startup script")
+
 const runScriptSh = "startup.sh"
 const runScriptPy = "startup.py"
 
-//Start commences execution of the given tart.
+//Start commences execution of the given tart, starting each of its replicas.
 func Start(pushURL string) error {
 	if !Exists(pushURL) {
 		return ErrTartNotFound
 	}
 	tart := Get(pushURL)
-	if tart.IsRunning {
+	if tart.AnyRunning() {
 		return ErrTartWrongState
 	}
-	deploymentFolder := getDeploymentPath(pushURL)
+	if _, err := startupCommand(pushURL); err != nil {
+		return err
+	}
 
-	var cmd *exec.Cmd
-	if shExists, _ := util.FileExists(path.Join(deploymentFolder, runScriptSh)); shExists {
-		cmd = exec.Command("bash", runScriptSh)
-	} else if pyExists, _ := util.FileExists(path.Join(deploymentFolder, runScriptPy)); pyExists {
-		cmd = exec.Command("python", runScriptPy)
-	} else {
-		return errors.New("No startup script")
+	if usesAllocatedPort(tart) {
+		AllocatePort(pushURL)
 	}
+	SyncSubdomain(pushURL)
+	defer SyncReplicaUpstreams(pushURL)
+	for i := 0; i < tart.ReplicaCount(); i++ {
+		if err := startReplica(pushURL, i); err != nil {
+			for started := 0; started < i; started++ { //so a failed start does not leave the tart partly running
+				if stopErr := stopReplica(pushURL, started); stopErr != nil {
+					logging.Error("tartmanager-run", "Failed to stop replica ", started+1, " of "+pushURL+": "+stopErr.Error())
+				}
+			}
+			return err
+		}
+	}
+	logging.Info("tartmanager-run", "Started "+pushURL)
+	return nil
+}
 
-	cmd.Dir = deploymentFolder
+//usesAllocatedPort returns true if the tart is allocated a port and passed it in $PORT. Tarts which set their own PORT
+//in their environment keep it, unless tart subdomains are enabled (as the subdomain is proxied to the allocated port)
+//or the tart has several replicas (which cannot share a port).
+func usesAllocatedPort(tart config.Tart) bool {
+	return config.All().Web.TartSubdomains || tart.ReplicaCount() > 1 || !hasEnv(tart.Env, "PORT")
+}
+
+//startReplica starts the i'th (from 0) instance of the tart, passing it its own port in $PORT (or recording the port
+//it sets itself, if it is not allocated one). Callers should SyncReplicaUpstreams once it is ready for requests.
+func startReplica(pushURL string, i int) error {
+	tart := Get(pushURL)
+	cmd, err := startupCommand(pushURL)
+	if err != nil {
+		return err
+	}
 	if tart.Env != nil {
 		cmd.Env = tart.Env
 	}
+	port := envPort(tart.Env)
+	if usesAllocatedPort(tart) {
+		port = allocatePort(pushURL, i)
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(withoutEnv(cmd.Env, "PORT"), "PORT="+strconv.Itoa(port))
+	}
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -56,7 +95,6 @@ func Start(pushURL string) error {
 		stdout.Close()
 		return err
 	}
-	go tartLogRoutine(tart, stdout, stderr)
 
 	err = cmd.Start()
 	if err != nil {
@@ -64,27 +102,94 @@ func Start(pushURL string) error {
 	}
 
 	blacklistPidFromSentry(cmd.Process.Pid)
-	tart.PID = cmd.Process.Pid
-	tart.IsRunning = true
+	tart = Get(pushURL)
+	tart.SetReplica(i, config.Replica{IsRunning: true, PID: cmd.Process.Pid, Port: port})
 	Save(pushURL, tart)
-	logging.Info("tartmanager-run", "Started "+pushURL)
+	go tartLogRoutine(tart, i, cmd.Process.Pid, stdout, stderr)
+	if tart.ReplicaCount() > 1 {
+		logging.Info("tartmanager-run", "Started replica ", i+1, " of "+pushURL+" on port ", port)
+	}
 	return nil
 }
 
-//Stop halts execution of the given tart.
+//startupCommand returns the command which runs the startup script of the tart's deployment.
+func startupCommand(pushURL string) (*exec.Cmd, error) {
+	deploymentFolder := getDeploymentPath(pushURL)
+
+	var cmd *exec.Cmd
+	if shExists, _ := util.FileExists(path.Join(deploymentFolder, runScriptSh)); shExists {
+		cmd = exec.Command("bash", runScriptSh)
+	} else if pyExists, _ := util.FileExists(path.Join(deploymentFolder, runScriptPy)); pyExists {
+		cmd = exec.Command("python", runScriptPy)
+	} else {
+		return nil, ErrNoStartupScript
+	}
+	cmd.Dir = deploymentFolder
+	return cmd, nil
+}
+
+//withoutEnv returns env without any value for name.
+func withoutEnv(env []string, name string) []string {
+	var out []string
+	for _, e := range env {
+		if !strings.HasPrefix(e, name+"=") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+//envPort returns the port set by PORT in env, or 0 if it does not set a valid one.
+func envPort(env []string) int {
+	for _, e := range env {
+		if strings.HasPrefix(e, "PORT=") {
+			if port, err := strconv.Atoi(strings.TrimPrefix(e, "PORT=")); err == nil && port > 0 && port < 65536 {
+				return port
+			}
+		}
+	}
+	return 0
+}
+
+func hasEnv(env []string, name string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+//Stop halts execution of the given tart, stopping each of its running replicas.
 func Stop(pushURL string) error {
 	if !Exists(pushURL) {
 		return ErrTartNotFound
 	}
 	tart := Get(pushURL)
-	if !tart.IsRunning {
+	if !tart.AnyRunning() {
 		return ErrTartWrongState
 	}
 
-	logging.Info("tartmanager-run", "Killing running tart with PID ", tart.PID)
+	var firstErr error
+	for i := 0; i <= len(tart.Instances); i++ {
+		if !tart.Replica(i).IsRunning {
+			continue
+		}
+		if err := stopReplica(pushURL, i); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+//stopReplica halts the i'th (from 0) instance of the tart. It is removed from the tart's domain proxies first.
+func stopReplica(pushURL string, i int) error {
+	tart := Get(pushURL)
+	replica := tart.Replica(i)
+	logging.Info("tartmanager-run", "Killing running tart with PID ", replica.PID)
 
-	removePidFromSentryBlacklist(tart.PID)
-	proc, err := os.FindProcess(tart.PID)
+	removePidFromSentryBlacklist(replica.PID)
+	proc, err := os.FindProcess(replica.PID)
 	if err != nil {
 		if strings.Contains(err.Error(), "process already finished") {
 			logging.Warning("tartmanager-run", "Aborting stop operation on "+pushURL+", process already terminated.")
@@ -93,9 +198,9 @@ func Stop(pushURL string) error {
 		}
 	}
 
-	tart.PID = -1
-	tart.IsRunning = false
+	tart.SetReplica(i, config.Replica{IsRunning: false, PID: -1, Port: replica.Port})
 	Save(pushURL, tart)
+	SyncReplicaUpstreams(pushURL)
 	proc.Signal(os.Interrupt)
 	time.Sleep(400 * time.Millisecond)
 
@@ -38,18 +38,20 @@ func runSentry() {
 	tarts := config.All().Tarts
 
 	for pushURL, tart := range tarts {
-		if tart.IsRunning && tart.PID > 0 && !pidInSentryBlacklist(tart.PID) {
-			ps := gsig.ProcState{}
-			if err := ps.Get(tart.PID); err != nil {
-				logging.Warning("run-sentry", "Error getting process info for "+pushURL+": "+err.Error())
-				logging.Warning("run-sentry", "Cleaning up execution.")
-				sentryLock.Unlock()
-				err = Stop(pushURL)
-				sentryLock.Lock()
-				if err != nil {
-					logging.Error("run-sentry", err.Error())
+		for i := 0; i <= len(tart.Instances); i++ {
+			replica := tart.Replica(i)
+			if replica.IsRunning && replica.PID > 0 && !pidInSentryBlacklist(replica.PID) {
+				ps := gsig.ProcState{}
+				if err := ps.Get(replica.PID); err != nil {
+					logging.Warning("run-sentry", "Error getting process info for "+pushURL+": "+err.Error())
+					logging.Warning("run-sentry", "Cleaning up execution.")
+					sentryLock.Unlock()
+					err = stopReplica(pushURL, i)
+					sentryLock.Lock()
+					if err != nil {
+						logging.Error("run-sentry", err.Error())
+					}
 				}
-				continue
 			}
 		}
 	}
@@ -8,7 +8,9 @@ import (
 	"time"
 )
 
-func tartLogRoutine(tart config.Tart, reader io.ReadCloser, errReader io.ReadCloser) {
+//tartLogRoutine logs the output of the i'th instance of a tart, and supervises it - marking it as stopped when its
+//process (pid) exits, and restarting it if the tart is set to restart on stop.
+func tartLogRoutine(tart config.Tart, i, pid int, reader io.ReadCloser, errReader io.ReadCloser) {
 	buf := make([]byte, 4096*2)
 
 	go func() {
@@ -42,20 +44,22 @@ func tartLogRoutine(tart config.Tart, reader io.ReadCloser, errReader io.ReadClo
 			logging.Info("tartmanager-service", tart.Name+" is shutting down.")
 
 			tart = Get(tart.PushURL)
-
-			if tart.RestartOnStop {
-				time.Sleep(time.Duration(tart.RestartDelaySecs) * time.Second)
-				tart = Get(tart.PushURL)
+			if tart.Replica(i).PID != pid { //stopped or replaced deliberately
+				break
 			}
-
-			tart.IsRunning = false
-			tart.PID = -1
+			tart.SetReplica(i, config.Replica{IsRunning: false, PID: -1, Port: tart.Replica(i).Port})
 			Save(tart.PushURL, tart)
-			removePidFromSentryBlacklist(tart.PID)
+			removePidFromSentryBlacklist(pid)
+			SyncReplicaUpstreams(tart.PushURL)
 
 			if tart.RestartOnStop {
-				logging.Info("tartmanager-service", tart.Name+" is restarting.")
-				Start(tart.PushURL)
+				time.Sleep(time.Duration(tart.RestartDelaySecs) * time.Second)
+				tart = Get(tart.PushURL)
+				if !tart.Replica(i).IsRunning && i < tart.ReplicaCount() {
+					logging.Info("tartmanager-service", tart.Name+" is restarting.")
+					startReplica(tart.PushURL, i)
+					SyncReplicaUpstreams(tart.PushURL)
+				}
 			}
 
 			break
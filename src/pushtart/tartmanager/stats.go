@@ -15,16 +15,34 @@ type RunMetrics struct {
 	Children []*RunMetrics
 }
 
-// GetStats returns a RunMetrics struct, which describe the running state of a tart.
+// GetStats returns a RunMetrics struct, which describe the running state of a tart. If the tart has several replicas,
+// the first running one is described, with the others included as children.
 func GetStats(pushURL string) (*RunMetrics, error) {
 	if !Exists(pushURL) {
 		return nil, ErrTartNotFound
 	}
 	tart := Get(pushURL)
-	if !tart.IsRunning {
+	if !tart.AnyRunning() {
 		return nil, ErrTartWrongState
 	}
-	return getStats(tart.PID)
+
+	var ret *RunMetrics
+	for i := 0; i <= len(tart.Instances); i++ {
+		if !tart.Replica(i).IsRunning {
+			continue
+		}
+		stats, err := getStats(tart.Replica(i).PID)
+		if err != nil {
+			return nil, err
+		}
+		if ret == nil {
+			ret = stats
+		} else {
+			ret.Children = append(ret.Children, stats)
+			ret.sumReduce([]*RunMetrics{stats})
+		}
+	}
+	return ret, nil
 }
 
 func getStats(pid int) (*RunMetrics, error) {
@@ -0,0 +1,109 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"pushtart/dnsserv"
+	"pushtart/logging"
+	"regexp"
+	"strings"
+)
+
+var subdomainInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+//SubdomainLabel returns the DNS label a tart is exposed under: its name, lowercased, with anything other than
+//letters and numbers replaced by hyphens. A tart named /alice/blog is exposed at alice-blog.<DefaultDomain>.
+func SubdomainLabel(tart config.Tart) string {
+	name := tart.Name
+	if name == "" {
+		name = tart.PushURL
+	}
+	label := strings.Trim(subdomainInvalidChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(label) > 63 {
+		label = strings.Trim(label[:63], "-")
+	}
+	return label
+}
+
+//AllocatePort returns the port allocated to the tart, allocating the lowest free port if it does not have one yet.
+func AllocatePort(pushURL string) int {
+	return allocatePort(pushURL, 0)
+}
+
+//SyncSubdomain creates, moves or removes the domain proxy (and DNSServ records) which expose the tart at
+//<tart-name>.<DefaultDomain>, according to Web.TartSubdomains and the tart's current name and port. A domain which
+//already has a proxy belonging to something else is left alone.
+func SyncSubdomain(pushURL string) {
+	tart := Get(pushURL)
+	want := ""
+	if label := SubdomainLabel(tart); config.All().Web.TartSubdomains && config.All().Web.DefaultDomain != "" && tart.Port != 0 && label != "" {
+		want = label + "." + strings.ToLower(config.All().Web.DefaultDomain)
+	}
+	if tart.Subdomain == "" && want == "" {
+		return
+	}
+
+	if tart.Subdomain != "" && tart.Subdomain != want {
+		removeSubdomain(pushURL, tart.Subdomain)
+		logging.Info("tartmanager-subdomains", "Removed "+tart.Subdomain+" for "+pushURL)
+		tart.Subdomain = ""
+	}
+	if want != "" {
+		if existing, ok := config.All().Web.DomainProxies[want]; ok && existing.OwnerTart != pushURL {
+			logging.Warning("tartmanager-subdomains", "Not exposing "+pushURL+" at "+want+": a proxy for that domain already exists")
+			want = ""
+		}
+	}
+	if want != "" {
+		addSubdomain(pushURL, want, tart.Port)
+		logging.Info("tartmanager-subdomains", "Exposed "+pushURL+" at "+want)
+		tart.Subdomain = want
+	}
+	Save(pushURL, tart)
+	SyncReplicaUpstreams(pushURL)
+}
+
+//SyncAllSubdomains calls SyncSubdomain for every tart, allocating ports to tarts without one if subdomains are enabled.
+func SyncAllSubdomains() {
+	for _, pushURL := range List() {
+		if config.All().Web.TartSubdomains {
+			AllocatePort(pushURL)
+		}
+		SyncSubdomain(pushURL)
+	}
+}
+
+func addSubdomain(pushURL, domain string, port int) {
+	if config.All().Web.DomainProxies == nil {
+		config.All().Web.DomainProxies = map[string]config.DomainProxy{}
+	}
+	proxy := config.All().Web.DomainProxies[domain] //auth rules and routes of an existing proxy are kept
+	proxy.SetTarget("127.0.0.1", port, "http")
+	proxy.OwnerTart = pushURL
+	config.All().Web.DomainProxies[domain] = proxy
+
+	if !config.All().DNS.Enabled {
+		return
+	}
+	//records for the subdomain point wherever the default domain does
+	parent := dnsserv.SanitizeDomain(strings.ToLower(config.All().Web.DefaultDomain))
+	for _, records := range []map[string]config.ARecord{config.All().DNS.ARecord, config.All().DNS.AAAARecord} {
+		base, ok := records[parent]
+		if !ok {
+			continue
+		}
+		if existing, exists := records[dnsserv.SanitizeDomain(domain)]; !exists || existing.OwnerTart == pushURL {
+			records[dnsserv.SanitizeDomain(domain)] = config.ARecord{Address: base.Address, TTL: base.TTL, OwnerTart: pushURL}
+		}
+	}
+}
+
+func removeSubdomain(pushURL, domain string) {
+	if proxy, ok := config.All().Web.DomainProxies[domain]; ok && proxy.OwnerTart == pushURL {
+		delete(config.All().Web.DomainProxies, domain)
+	}
+	for _, records := range []map[string]config.ARecord{config.All().DNS.ARecord, config.All().DNS.AAAARecord} {
+		if record, ok := records[dnsserv.SanitizeDomain(domain)]; ok && record.OwnerTart == pushURL {
+			delete(records, dnsserv.SanitizeDomain(domain))
+		}
+	}
+}
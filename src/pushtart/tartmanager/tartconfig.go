@@ -0,0 +1,67 @@
+package tartmanager
+
+import (
+	"errors"
+	"pushtart/config"
+	"pushtart/dnsserv"
+	"strings"
+)
+
+// ErrTartconfigNoUser is returned if a tartconfig is executed without a user to run the commands as.
+var ErrTartconfigNoUser = errors.New("tartconfig must be run in the context of a user")
+
+// tartconfigCommands lists the commands which may be run from a tartconfig file.
+var tartconfigCommands = map[string]bool{
+	"edit-tart":          true,
+	"tart-restart-mode":  true,
+	"tart-add-owner":     true,
+	"tart-remove-owner":  true,
+	"tart-add-reader":    true,
+	"tart-remove-reader": true,
+	"extension":          true,
+}
+
+// tartconfigExtensionOperations lists the extension operations which may be run from a tartconfig file, by extension.
+var tartconfigExtensionOperations = map[string][]string{
+	"DNSSERV":   []string{"set-record", "delete-record"},
+	"HTTPPROXY": []string{"set-domain-proxy", "delete-domain-proxy", "set-path-route", "delete-path-route", "add-upstream", "remove-upstream", "set-load-balancing", "set-streaming-options", "set-static-site", "set-error-pages", "add-authorization-rule", "remove-authorization-rule"},
+}
+
+// checkTartconfigCommand returns an error if the given command should not be run from the tartconfig of pushURL.
+func checkTartconfigCommand(command string, params map[string]string, pushURL string) error {
+	if _, ok := tartconfigCommands[command]; !ok {
+		return errors.New("'" + command + "' cannot be run from a tartconfig")
+	}
+	if strings.TrimPrefix(params["tart"], "/") != strings.TrimPrefix(pushURL, "/") {
+		return errors.New("a tartconfig may only modify its own tart")
+	}
+
+	if command == "extension" {
+		if _, ok := params["cache-size"]; ok {
+			return errors.New("extension settings cannot be changed from a tartconfig")
+		}
+		for _, op := range tartconfigExtensionOperations[strings.ToUpper(params["extension"])] {
+			if op == params["operation"] {
+				return checkTartconfigTarget(params, pushURL)
+			}
+		}
+		return errors.New("operation '" + params["operation"] + "' cannot be run from a tartconfig")
+	}
+	return nil
+}
+
+// checkTartconfigTarget returns an error if an extension operation would change a domain proxy or DNS record which
+// exists but is not owned by the tart, as the pusher's permissions may extend to domains the tart has nothing to do with.
+func checkTartconfigTarget(params map[string]string, pushURL string) error {
+	switch strings.ToUpper(params["extension"]) {
+	case "HTTPPROXY":
+		if proxy, exists := config.All().Web.DomainProxies[strings.ToLower(params["domain"])]; exists && proxy.OwnerTart != pushURL {
+			return errors.New("a tartconfig may only modify domain proxies owned by its tart")
+		}
+	case "DNSSERV":
+		if record, exists := config.All().DNS.ARecord[dnsserv.SanitizeDomain(params["domain"])]; exists && record.OwnerTart != pushURL {
+			return errors.New("a tartconfig may only modify DNS records owned by its tart")
+		}
+	}
+	return nil
+}
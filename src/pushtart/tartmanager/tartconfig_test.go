@@ -0,0 +1,58 @@
+package tartmanager
+
+import (
+	"pushtart/config"
+	"testing"
+)
+
+func TestCheckTartconfigCommand(t *testing.T) {
+	config.All().Web.DomainProxies = map[string]config.DomainProxy{
+		"own.example.com":     {OwnerTart: "/alice/app", OwnerUser: "alice"},
+		"foreign.example.com": {OwnerTart: "/bob/app", OwnerUser: "bob"},
+		"manual.example.com":  {OwnerUser: "alice"},
+	}
+	config.All().DNS.ARecord = map[string]config.ARecord{
+		"own.example.com.":     {OwnerTart: "/alice/app"},
+		"foreign.example.com.": {OwnerTart: "/bob/app"},
+	}
+	defer func() {
+		config.All().Web.DomainProxies = map[string]config.DomainProxy{}
+		config.All().DNS.ARecord = nil
+	}()
+
+	proxyOp := func(operation, domain string) map[string]string {
+		return map[string]string{"tart": "/alice/app", "extension": "HTTPProxy", "operation": operation, "domain": domain}
+	}
+	dnsOp := func(operation, domain string) map[string]string {
+		return map[string]string{"tart": "/alice/app", "extension": "DNSServ", "operation": operation, "domain": domain}
+	}
+	tests := []struct {
+		name    string
+		command string
+		params  map[string]string
+		allowed bool
+	}{
+		{"edit own tart", "edit-tart", map[string]string{"tart": "/alice/app"}, true},
+		{"own tart without leading slash", "edit-tart", map[string]string{"tart": "alice/app"}, true},
+		{"edit other tart", "edit-tart", map[string]string{"tart": "/bob/app"}, false},
+		{"command not permitted", "new-user", map[string]string{"tart": "/alice/app"}, false},
+		{"operation not permitted", "extension", proxyOp("set-listener", "own.example.com"), false},
+		{"extension settings", "extension", map[string]string{"tart": "/alice/app", "extension": "DNSServ", "operation": "set-record", "cache-size": "10"}, false},
+		{"new domain", "extension", proxyOp("set-domain-proxy", "new.example.com"), true},
+		{"own domain", "extension", proxyOp("set-domain-proxy", "own.example.com"), true},
+		{"own domain in another case", "extension", proxyOp("add-authorization-rule", "Own.Example.com"), true},
+		{"foreign domain", "extension", proxyOp("set-domain-proxy", "foreign.example.com"), false},
+		{"foreign domain in another case", "extension", proxyOp("remove-authorization-rule", "FOREIGN.example.com"), false},
+		{"delete foreign domain", "extension", proxyOp("delete-domain-proxy", "foreign.example.com"), false},
+		{"domain not owned by a tart", "extension", proxyOp("set-path-route", "manual.example.com"), false},
+		{"new record", "extension", dnsOp("set-record", "new.example.com"), true},
+		{"own record", "extension", dnsOp("set-record", "own.example.com"), true},
+		{"foreign record", "extension", dnsOp("set-record", "foreign.example.com"), false},
+		{"delete foreign record", "extension", dnsOp("delete-record", "foreign.example.com."), false},
+	}
+	for _, test := range tests {
+		if err := checkTartconfigCommand(test.command, test.params, "/alice/app"); (err == nil) != test.allowed {
+			t.Errorf("%s: checkTartconfigCommand() = %v, want allowed = %v", test.name, err, test.allowed)
+		}
+	}
+}
@@ -39,6 +39,7 @@ func Delete(username string) error {
 		}
 	}
 
+	removeFromAllGroups(username)
 	delete(config.All().Users, username)
 	config.Flush()
 	return nil
@@ -46,7 +47,60 @@ func Delete(username string) error {
 
 //New creates a new user wit the given username, writing it to global configuration before flushing to disk.
 func New(username string) {
-	Save(username, config.User{})
+	Save(username, config.User{Role: RoleDeveloper})
+}
+
+//AddSSHKeys parses all the public keys in data and adds them to the user, skipping any the user already has.
+//The keys which were added are returned.
+func AddSSHKeys(username string, data []byte, label string) ([]config.SSHKey, error) {
+	if !Exists(username) {
+		return nil, errors.New("User does not exist")
+	}
+	keys, err := config.ParseSSHKeys(data, label)
+	if err != nil {
+		return nil, err
+	}
+
+	usr := Get(username)
+	var added []config.SSHKey
+	for _, key := range keys {
+		if !hasSSHKey(usr, key.Fingerprint) {
+			usr.SSHKeys = append(usr.SSHKeys, key)
+			added = append(added, key)
+		}
+	}
+	Save(username, usr)
+	return added, nil
+}
+
+//RemoveSSHKey removes the key with the given fingerprint from the user.
+func RemoveSSHKey(username, fingerprint string) error {
+	if !Exists(username) {
+		return errors.New("User does not exist")
+	}
+	usr := Get(username)
+	if !hasSSHKey(usr, fingerprint) {
+		return errors.New("User has no key with that fingerprint")
+	}
+
+	var keys []config.SSHKey
+	for _, key := range usr.SSHKeys {
+		if key.Fingerprint != fingerprint {
+			keys = append(keys, key)
+		}
+	}
+	usr.SSHKeys = keys
+	Save(username, usr)
+	return nil
+}
+
+func hasSSHKey(usr config.User, fingerprint string) bool {
+	for _, key := range usr.SSHKeys {
+		if key.Fingerprint == fingerprint {
+			return true
+		}
+	}
+	return false
 }
 
 //List returns a []string of all the users in the system.
@@ -4,18 +4,39 @@ import (
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/util"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
 )
 
-//GetUserPubkey returns the openssh-friendly user public key.
-func GetUserPubkey(username string) string {
+// CheckUserPubkey returns true if the given public key is one of the user's SSH keys. It is also called for keys
+// the client has not yet proved it holds, so records nothing - see RecordSSHKeyUse.
+func CheckUserPubkey(username string, pubKey ssh.PublicKey) bool {
 	usrStruct, ok := config.All().Users[username]
 	if !ok {
-		return ""
+		return false
+	}
+
+	fingerprint := util.SSHKeyFingerprint(pubKey.Marshal())
+	for _, key := range usrStruct.SSHKeys {
+		if key.Fingerprint == fingerprint {
+			return true
+		}
 	}
+	return false
+}
 
-	return usrStruct.SSHPubKey
+// RecordSSHKeyUse updates the LastUsed time of the user's SSH key with the given fingerprint, once a connection has
+// been authenticated with it.
+func RecordSSHKeyUse(username, fingerprint string) {
+	config.RecordUsage(func(c *config.Config) {
+		for i, key := range c.Users[username].SSHKeys {
+			if key.Fingerprint == fingerprint {
+				c.Users[username].SSHKeys[i].LastUsed = time.Now().Unix() //in place, so the map is not written to
+			}
+		}
+	})
 }
 
 // CheckUserPasswordSSH checks the given username to see if the stored password matches the one provided.
@@ -43,27 +64,38 @@ func CheckUserPasswordSSH(username, password string) bool {
 }
 
 // CheckUserPasswordWeb checks the given username to see if the stored password matches the one provided.
-// If the user does not exist, or the passwords do not match, false is returned.
+// If the user does not exist, or the passwords do not match, false is returned. Users with two-factor
+// authentication enabled must append their current verification code to their password.
 func CheckUserPasswordWeb(username, password string) bool {
 	usrStruct, ok := config.All().Users[username]
 	if !ok {
 		return false
 	}
 
-	// Speedup for web clients making heaps of requests - bcrypt is expensive
-	if isValidFromCacheHit(username, password) {
-		return true
+	pass, code := password, ""
+	if usrStruct.TOTPSecret != "" {
+		if len(password) < TOTPCodeLength {
+			return false
+		}
+		pass, code = password[:len(password)-TOTPCodeLength], password[len(password)-TOTPCodeLength:]
 	}
 
-	err := util.ComparePassHash(usrStruct.Password, username, password)
-	if err == nil { //if err == nil the passwords match
-		cacheCorrectAuthEntry(username, password)
-		return true
+	// Speedup for web clients making heaps of requests - bcrypt is expensive
+	// Only the password is cached, so verification codes are still checked (and only accepted once) every time.
+	if !isValidFromCacheHit(username, pass) {
+		err := util.ComparePassHash(usrStruct.Password, username, pass)
+		if err != nil {
+			if err != bcrypt.ErrMismatchedHashAndPassword {
+				logging.Error("httpproxy-auth", "Hash compare error: "+err.Error())
+			}
+			return false
+		}
+		cacheCorrectAuthEntry(username, pass)
 	}
 
-	if err != bcrypt.ErrMismatchedHashAndPassword {
-		logging.Error("httpproxy-auth", "Hash compare error: "+err.Error())
+	if usrStruct.TOTPSecret != "" && !CheckTOTP(username, code) {
+		logging.Info("httpproxy-auth", "Incorrect verification code for "+username)
+		return false
 	}
-
-	return false
+	return true
 }
@@ -0,0 +1,61 @@
+package user
+
+import (
+	"pushtart/config"
+	"pushtart/util"
+	"testing"
+)
+
+func TestCheckUserPasswordWeb(t *testing.T) {
+	hash, err := util.HashPassword("web-plain", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.All().Users["web-plain"] = config.User{Password: hash}
+
+	for i := 0; i < 2; i++ { //the second time, from the cache
+		if !CheckUserPasswordWeb("web-plain", "hunter2") {
+			t.Errorf("attempt %d: correct password rejected", i)
+		}
+		if CheckUserPasswordWeb("web-plain", "hunter3") {
+			t.Errorf("attempt %d: incorrect password accepted", i)
+		}
+	}
+	if CheckUserPasswordWeb("web-nobody", "hunter2") {
+		t.Error("password accepted for a user who does not exist")
+	}
+}
+
+func TestCheckUserPasswordWebTOTPReplay(t *testing.T) {
+	hash, err := util.HashPassword("web-totp", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := currentPeriod()
+	secret := addTOTPUser(t, "web-totp", hash)
+	code, next := totpCode(secret, now), totpCode(secret, now+1)
+	wrong := "000000"
+	for wrong == totpCode(secret, now-1) || wrong == code || wrong == next {
+		wrong = wrong[1:] + "1"
+	}
+
+	if CheckUserPasswordWeb("web-totp", "hunter2") {
+		t.Error("password accepted without a verification code")
+	}
+	if CheckUserPasswordWeb("web-totp", "hunter3"+code) {
+		t.Error("incorrect password accepted with a valid verification code")
+	}
+	if !CheckUserPasswordWeb("web-totp", "hunter2"+code) {
+		t.Fatal("correct password and verification code rejected")
+	}
+	//the password is now cached, but the code must not be
+	if CheckUserPasswordWeb("web-totp", "hunter2"+code) {
+		t.Error("verification code accepted twice")
+	}
+	if CheckUserPasswordWeb("web-totp", "hunter2"+wrong) {
+		t.Error("cached password accepted with an incorrect verification code")
+	}
+	if !CheckUserPasswordWeb("web-totp", "hunter2"+next) {
+		t.Error("correct password and the next verification code rejected")
+	}
+}
@@ -0,0 +1,132 @@
+package user
+
+import (
+	"errors"
+	"pushtart/config"
+	"regexp"
+)
+
+var validGroupName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+//GroupExists returns true if the given group exists.
+func GroupExists(group string) bool {
+	_, ok := config.All().Groups[group]
+	return ok
+}
+
+//ListGroups returns the names of all groups.
+func ListGroups() []string {
+	var output []string
+	for name := range config.All().Groups {
+		output = append(output, name)
+	}
+	return output
+}
+
+//InGroup returns true if the given user is a member of the given group.
+func InGroup(username, group string) bool {
+	for _, member := range config.All().Groups[group].Members {
+		if member == username {
+			return true
+		}
+	}
+	return false
+}
+
+//InAnyGroup returns true if the given user is a member of at least one of the given groups.
+func InAnyGroup(username string, groups []string) bool {
+	for _, group := range groups {
+		if InGroup(username, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func saveGroup(name string, group config.Group) {
+	if config.All().Groups == nil {
+		config.All().Groups = map[string]config.Group{}
+	}
+	config.All().Groups[name] = group
+	config.Flush()
+}
+
+//NewGroup creates a new group with no members.
+func NewGroup(name, description string) error {
+	if !validGroupName.MatchString(name) {
+		return errors.New("Group names may only contain letters, numbers, '.', '_' and '-'")
+	}
+	if GroupExists(name) {
+		return errors.New("Group already exists")
+	}
+	saveGroup(name, config.Group{Description: description})
+	return nil
+}
+
+//DeleteGroup removes the specified group. Groups which own tarts cannot be deleted.
+func DeleteGroup(name string) error {
+	if !GroupExists(name) {
+		return errors.New("Group does not exist")
+	}
+	for pushURL, tart := range config.All().Tarts {
+		for _, group := range tart.OwnerGroups {
+			if group == name {
+				return errors.New("Cannot delete a group which still owns a tart (" + pushURL + ")")
+			}
+		}
+	}
+	delete(config.All().Groups, name)
+	config.Flush()
+	return nil
+}
+
+//AddGroupMember adds the given user to the group.
+func AddGroupMember(name, username string) error {
+	if !GroupExists(name) {
+		return errors.New("Group does not exist")
+	}
+	if !Exists(username) {
+		return errors.New("User does not exist")
+	}
+	if InGroup(username, name) {
+		return errors.New(username + " is already a member of " + name)
+	}
+	group := config.All().Groups[name]
+	group.Members = append(group.Members, username)
+	saveGroup(name, group)
+	return nil
+}
+
+//RemoveGroupMember removes the given user from the group.
+func RemoveGroupMember(name, username string) error {
+	if !GroupExists(name) {
+		return errors.New("Group does not exist")
+	}
+	if !InGroup(username, name) {
+		return errors.New(username + " is not a member of " + name)
+	}
+	group := config.All().Groups[name]
+	members := []string{}
+	for _, member := range group.Members {
+		if member != username {
+			members = append(members, member)
+		}
+	}
+	group.Members = members
+	saveGroup(name, group)
+	return nil
+}
+
+//removeFromAllGroups removes the given user from every group they are a member of, without flushing the configuration.
+func removeFromAllGroups(username string) {
+	for name, group := range config.All().Groups {
+		members := []string{}
+		for _, member := range group.Members {
+			if member != username {
+				members = append(members, member)
+			}
+		}
+		group.Members = members
+		config.All().Groups[name] = group
+	}
+}
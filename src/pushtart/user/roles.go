@@ -0,0 +1,47 @@
+package user
+
+//Roles a user may hold, in increasing order of privilege. Each role has all the permissions of the roles before it.
+const (
+	RoleDeveloper = "developer" //May manage tarts they own, their own SSH keys, and resources owned by their tarts.
+	RoleOperator  = "operator"  //May additionally manage all tarts and owned resources, list users, and view logs.
+	RoleAdmin     = "admin"     //May do anything, including managing users, configuration and API keys.
+)
+
+var roleLevels = map[string]int{
+	RoleDeveloper: 1,
+	RoleOperator:  2,
+	RoleAdmin:     3,
+}
+
+//ValidRole returns true if role is one of the defined roles.
+func ValidRole(role string) bool {
+	_, ok := roleLevels[role]
+	return ok
+}
+
+//Role returns the role of the given user. The console user ("") is an admin, and users without a (valid) role are developers.
+func Role(username string) string {
+	if username == "" {
+		return RoleAdmin
+	}
+	if role := Get(username).Role; ValidRole(role) {
+		return role
+	}
+	return RoleDeveloper
+}
+
+//RoleSatisfies returns true if role has at least the privileges of the required role.
+func RoleSatisfies(role, required string) bool {
+	return roleLevels[role] >= roleLevels[required]
+}
+
+//HasRole returns true if the given user has at least the privileges of the required role.
+func HasRole(username, required string) bool {
+	return RoleSatisfies(Role(username), required)
+}
+
+//CanManageCredentials returns true if callingUser may manage the SSH keys and two-factor authentication of username.
+//Users may manage their own credentials, admins may manage anyone's.
+func CanManageCredentials(callingUser, username string) bool {
+	return callingUser == username || HasRole(callingUser, RoleAdmin)
+}
@@ -0,0 +1,124 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"pushtart/config"
+	"pushtart/logging"
+	"sync"
+	"time"
+)
+
+// TOTP parameters (RFC 6238), chosen for compatibility with common authenticator apps.
+const (
+	totpPeriod     = 30 //Seconds each code is valid for.
+	totpDigits     = 6
+	totpModulus    = 1000000 //10^totpDigits
+	totpSecretSize = 20      //Bytes. 160 bits, as recommended by RFC 4226.
+	totpSkew       = 1       //Periods either side of the current one whose codes are also accepted, to allow for clock drift.
+)
+
+// TOTPCodeLength is the number of digits in a verification code.
+const TOTPCodeLength = totpDigits
+
+var (
+	totpUsedLock sync.Mutex
+	totpLastUsed = map[string]int64{} //Period of the last code accepted for each user, so codes cannot be replayed.
+)
+
+// Has2FA returns true if the given user has enabled two-factor authentication.
+func Has2FA(username string) bool {
+	return Get(username).TOTPSecret != ""
+}
+
+// Enable2FA generates a new TOTP secret for the user, storing it encrypted in the configuration. A otpauth://
+// provisioning URI is returned, which can be given to an authenticator app (usually as a QR code).
+func Enable2FA(username string) (string, error) {
+	if !Exists(username) {
+		return "", errors.New("User does not exist")
+	}
+	usr := Get(username)
+	if usr.TOTPSecret != "" {
+		return "", errors.New("Two-factor authentication is already enabled for this user")
+	}
+
+	secret := make([]byte, totpSecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	encrypted, err := config.EncryptSecret(encoded)
+	if err != nil {
+		return "", err
+	}
+	usr.TOTPSecret = encrypted
+	Save(username, usr)
+
+	issuer := config.All().Name
+	v := url.Values{}
+	v.Set("secret", encoded)
+	v.Set("issuer", issuer)
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+username) + "?" + v.Encode(), nil
+}
+
+// Disable2FA removes the TOTP secret of the given user.
+func Disable2FA(username string) error {
+	if !Exists(username) {
+		return errors.New("User does not exist")
+	}
+	usr := Get(username)
+	usr.TOTPSecret = ""
+	Save(username, usr)
+	return nil
+}
+
+// CheckTOTP returns true if code is a current verification code for the user. Each code is only accepted once.
+func CheckTOTP(username, code string) bool {
+	usr, ok := config.All().Users[username]
+	if !ok || usr.TOTPSecret == "" || len(code) != totpDigits {
+		return false
+	}
+	encoded, err := config.DecryptSecret(usr.TOTPSecret)
+	if err != nil {
+		logging.Error("totp-auth", "Could not decrypt TOTP secret of "+username+": "+err.Error())
+		return false
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		logging.Error("totp-auth", "Invalid TOTP secret for "+username+": "+err.Error())
+		return false
+	}
+
+	totpUsedLock.Lock()
+	defer totpUsedLock.Unlock()
+	now := time.Now().Unix() / totpPeriod
+	for period := now - totpSkew; period <= now+totpSkew; period++ {
+		if period > totpLastUsed[username] && hmac.Equal([]byte(totpCode(secret, period)), []byte(code)) {
+			totpLastUsed[username] = period
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the code for the given period, as per RFC 4226 section 5.3.
+func totpCode(secret []byte, period int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(period))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%totpModulus)
+}
@@ -0,0 +1,96 @@
+package user
+
+import (
+	"encoding/base32"
+	"fmt"
+	"pushtart/config"
+	"testing"
+	"time"
+)
+
+//addTOTPUser creates a user with two-factor authentication enabled, returning their TOTP secret.
+func addTOTPUser(t *testing.T, username, passwordHash string) []byte {
+	secret := []byte("12345678901234567890" + username)
+	encrypted, err := config.EncryptSecret(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.All().Users[username] = config.User{Password: passwordHash, TOTPSecret: encrypted}
+	return secret
+}
+
+//currentPeriod returns the current TOTP period, first waiting for the next one if it is about to end, so codes
+//computed by a test are not checked in a different period.
+func currentPeriod() int64 {
+	if time.Now().Unix()%totpPeriod >= totpPeriod-2 {
+		time.Sleep(3 * time.Second)
+	}
+	return time.Now().Unix() / totpPeriod
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	//Test vectors from RFC 6238 appendix B (SHA1), truncated to six digits.
+	secret := []byte("12345678901234567890")
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, v := range vectors {
+		want := v.code[len(v.code)-totpDigits:]
+		if got := totpCode(secret, v.time/totpPeriod); got != want {
+			t.Errorf("totpCode(T=%d) = %q, want %q", v.time, got, want)
+		}
+	}
+}
+
+func TestCheckTOTPWindow(t *testing.T) {
+	now := currentPeriod()
+	for offset := int64(-3); offset <= 3; offset++ {
+		username := fmt.Sprintf("totp-window%d", offset)
+		secret := addTOTPUser(t, username, "")
+		want := offset >= -totpSkew && offset <= totpSkew
+		if got := CheckTOTP(username, totpCode(secret, now+offset)); got != want {
+			t.Errorf("CheckTOTP() with the code %d periods from now = %v, want %v", offset, got, want)
+		}
+	}
+
+	secret := addTOTPUser(t, "totp-format", "")
+	code := totpCode(secret, now)
+	for _, bad := range []string{"", code[1:], code + "0", " " + code[1:]} {
+		if CheckTOTP("totp-format", bad) {
+			t.Errorf("CheckTOTP(%q) = true, want false", bad)
+		}
+	}
+	if CheckTOTP("totp-nobody", code) {
+		t.Error("CheckTOTP() accepted a code for a user who does not exist")
+	}
+	config.All().Users["totp-disabled"] = config.User{}
+	if CheckTOTP("totp-disabled", code) {
+		t.Error("CheckTOTP() accepted a code for a user without two-factor authentication")
+	}
+}
+
+func TestCheckTOTPReplay(t *testing.T) {
+	now := currentPeriod()
+	secret := addTOTPUser(t, "totp-replay", "")
+
+	if !CheckTOTP("totp-replay", totpCode(secret, now)) {
+		t.Fatal("CheckTOTP() rejected the current code")
+	}
+	if CheckTOTP("totp-replay", totpCode(secret, now)) {
+		t.Error("CheckTOTP() accepted the same code twice")
+	}
+	if CheckTOTP("totp-replay", totpCode(secret, now-1)) {
+		t.Error("CheckTOTP() accepted an earlier code after a later one was used")
+	}
+	if !CheckTOTP("totp-replay", totpCode(secret, now+1)) {
+		t.Error("CheckTOTP() rejected the next code")
+	}
+}
@@ -0,0 +1,30 @@
+package util
+
+import (
+	"errors"
+	"net"
+	"strings"
+)
+
+//ParseCIDR parses an address range in CIDR notation (like 10.0.0.0/8). A single address is treated as a range
+//containing only that address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.New("invalid address or CIDR range: " + s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+//AddressInRange returns true if addr is within the CIDR range (or is the single address) given.
+func AddressInRange(addr net.IP, cidr string) bool {
+	network, err := ParseCIDR(cidr)
+	return err == nil && addr != nil && network.Contains(addr)
+}
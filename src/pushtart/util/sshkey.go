@@ -0,0 +1,12 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+//SSHKeyFingerprint returns the OpenSSH-style SHA256 fingerprint of the given wire-format public key.
+func SSHKeyFingerprint(keyBlob []byte) string {
+	sum := sha256.Sum256(keyBlob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,272 @@
+package webproxy
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"pushtart/config"
+	"pushtart/logging"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	baseEjectTime = 10 * time.Second //How long a backend is ejected for after a connection error. Doubles with each consecutive failure.
+	maxEjectTime  = 5 * time.Minute
+	maxRetries    = 2 //Further backends an idempotent request is tried against, if one fails.
+)
+
+//backendState is the passively observed health of a backend.
+type backendState struct {
+	Active       int //Requests currently in flight.
+	Requests     int
+	Errors       int
+	Failures     int //Consecutive connection errors.
+	EjectedUntil time.Time
+	LastError    string
+}
+
+var (
+	balancerLock  sync.Mutex
+	backendStates = map[string]*backendState{} //keyed by backendKey
+	roundRobin    = map[string]int{}           //next index, keyed by the backend set
+)
+
+func backendKey(b config.Upstream) string {
+	return b.Scheme + "://" + b.Host + ":" + strconv.Itoa(b.Port)
+}
+
+func stateOf(b config.Upstream) *backendState {
+	st, ok := backendStates[backendKey(b)]
+	if !ok {
+		st = &backendState{}
+		backendStates[backendKey(b)] = st
+	}
+	return st
+}
+
+func weightOf(b config.Upstream) int {
+	if b.Weight <= 0 {
+		return 1
+	}
+	return b.Weight
+}
+
+//pickBackend chooses the backend for a request, according to the proxy's balancing policy. Ejected backends and those
+//in exclude are skipped - unless every remaining backend is ejected, in which case they are tried anyway.
+func pickBackend(proxyEntry config.DomainProxy, exclude map[string]bool) (config.Upstream, bool) {
+	balancerLock.Lock()
+	defer balancerLock.Unlock()
+
+	var candidates, ejected []config.Upstream
+	var keys []string
+	now := time.Now()
+	for _, b := range proxyEntry.Backends() {
+		keys = append(keys, backendKey(b))
+		if exclude[backendKey(b)] {
+			continue
+		}
+		if now.Before(stateOf(b).EjectedUntil) {
+			ejected = append(ejected, b)
+		} else {
+			candidates = append(candidates, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return config.Upstream{}, false
+	}
+
+	switch proxyEntry.Balance {
+	case config.BalanceLeastConnections:
+		best := candidates[0]
+		for _, b := range candidates[1:] {
+			//compare Active/weight without division
+			if stateOf(b).Active*weightOf(best) < stateOf(best).Active*weightOf(b) {
+				best = b
+			}
+		}
+		return best, true
+	case config.BalanceWeighted:
+		total := 0
+		for _, b := range candidates {
+			total += weightOf(b)
+		}
+		n := rand.Intn(total)
+		for _, b := range candidates {
+			if n -= weightOf(b); n < 0 {
+				return b, true
+			}
+		}
+	}
+	set := strings.Join(keys, ",")
+	b := candidates[roundRobin[set]%len(candidates)]
+	roundRobin[set]++
+	return b, true
+}
+
+func backendStarted(b config.Upstream) {
+	balancerLock.Lock()
+	defer balancerLock.Unlock()
+	st := stateOf(b)
+	st.Active++
+	st.Requests++
+}
+
+//backendFinished records the outcome of a request to a backend. Connection errors eject it for a while.
+func backendFinished(b config.Upstream, err error) {
+	balancerLock.Lock()
+	defer balancerLock.Unlock()
+	st := stateOf(b)
+	st.Active--
+	if err == nil {
+		st.Failures = 0
+		return
+	}
+
+	st.Errors++
+	st.LastError = err.Error()
+	if !isConnectionError(err) {
+		return
+	}
+	st.Failures++
+	ejectFor := baseEjectTime << uint(st.Failures-1)
+	if ejectFor > maxEjectTime || ejectFor <= 0 {
+		ejectFor = maxEjectTime
+	}
+	st.EjectedUntil = time.Now().Add(ejectFor)
+	logging.Warning("httpproxy-balancer", "Ejected backend "+backendKey(b)+" for "+ejectFor.String()+": "+err.Error())
+}
+
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+//canRetry returns true if a failed request may safely be sent to another backend: it must be idempotent, and
+//have no body (which may already have been consumed).
+func canRetry(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0
+	}
+	return false
+}
+
+//balancingTransport sends each request to a backend of the domain proxy, retrying idempotent requests on another
+//backend if one fails.
+type balancingTransport struct {
+	proxyEntry  config.DomainProxy
+	base        http.RoundTripper
+	idleTimeout time.Duration //Responses and upgraded connections which pass no data for this long are closed.
+}
+
+func (t *balancingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tried := map[string]bool{}
+	for attempt := 0; ; attempt++ {
+		b, ok := pickBackend(t.proxyEntry, tried)
+		if !ok {
+			return nil, errNoBackends
+		}
+		tried[backendKey(b)] = true
+
+		outreq := req.Clone(req.Context())
+		outreq.URL.Scheme, outreq.URL.Host, outreq.Host = b.Scheme, b.Host+":"+strconv.Itoa(b.Port), b.Host
+		backendStarted(b)
+		resp, err := t.base.RoundTrip(outreq)
+		if err != nil {
+			backendFinished(b, err)
+			if attempt < maxRetries && canRetry(req) && len(tried) < len(t.proxyEntry.Backends()) {
+				logging.Warning("httpproxy-balancer", "Retrying "+req.Method+" "+req.URL.Path+" on another backend: "+err.Error())
+				continue
+			}
+			return nil, err
+		}
+		body := resp.Body
+		idle := newIdleTimer(t.idleTimeout, func() {
+			logging.Info("httpproxy-main", "Closing idle connection to "+backendKey(b)+" (request "+req.Header.Get(requestIDHeader)+")")
+			body.Close()
+		})
+		if upgraded, isUpgrade := body.(io.ReadWriteCloser); isUpgrade { //protocol switches need a writable body
+			resp.Body = &upgradedBackendBody{ReadWriteCloser: upgraded, backendBody: backendBody{backend: b, idle: idle}}
+		} else {
+			resp.Body = &backendBody{ReadCloser: body, backend: b, idle: idle}
+		}
+		return resp, nil
+	}
+}
+
+//backendBody marks the request to its backend as finished once the response has been read, and closes it if it is idle.
+type backendBody struct {
+	io.ReadCloser
+	backend config.Upstream
+	once    sync.Once
+	idle    *idleTimer
+}
+
+func (b *backendBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.idle.touch()
+	return n, err
+}
+
+func (b *backendBody) Close() error {
+	b.finished()
+	return b.ReadCloser.Close()
+}
+
+func (b *backendBody) finished() {
+	b.once.Do(func() {
+		b.idle.stop()
+		backendFinished(b.backend, nil)
+	})
+}
+
+type upgradedBackendBody struct {
+	io.ReadWriteCloser
+	backendBody
+}
+
+func (b *upgradedBackendBody) Read(p []byte) (int, error) {
+	n, err := b.ReadWriteCloser.Read(p)
+	b.idle.touch()
+	return n, err
+}
+
+func (b *upgradedBackendBody) Write(p []byte) (int, error) {
+	n, err := b.ReadWriteCloser.Write(p)
+	b.idle.touch()
+	return n, err
+}
+
+func (b *upgradedBackendBody) Close() error {
+	b.finished()
+	return b.ReadWriteCloser.Close()
+}
+
+//BackendHealth describes the observed health of a backend, for display. ok is false if no requests have been sent to
+//it by this process.
+func BackendHealth(b config.Upstream) (health string, ok bool) {
+	balancerLock.Lock()
+	defer balancerLock.Unlock()
+	st, ok := backendStates[backendKey(b)]
+	if !ok {
+		return "", false
+	}
+
+	health = "healthy"
+	if remaining := st.EjectedUntil.Sub(time.Now()); remaining > 0 {
+		health = "ejected for " + remaining.Round(time.Second).String() + " after " + strconv.Itoa(st.Failures) + " connection errors"
+	}
+	health += ", " + strconv.Itoa(st.Active) + " active, " + strconv.Itoa(st.Requests) + " requests, " + strconv.Itoa(st.Errors) + " errors"
+	if st.LastError != "" {
+		health += " (last: " + st.LastError + ")"
+	}
+	return health, true
+}
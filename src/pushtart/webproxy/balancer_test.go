@@ -0,0 +1,174 @@
+package webproxy
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"pushtart/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+//balancedProxy returns a proxy balancing between local backends on the given ports, and resets their state.
+func balancedProxy(balance string, ports ...int) config.DomainProxy {
+	proxy := config.DomainProxy{TargetHost: "127.0.0.1", TargetPort: ports[0], TargetScheme: "http", Balance: balance}
+	for _, port := range ports {
+		b := config.Upstream{Host: "127.0.0.1", Port: port, Scheme: "http", Weight: 1}
+		proxy.Upstreams = append(proxy.Upstreams, b)
+		delete(backendStates, backendKey(b))
+	}
+	return proxy
+}
+
+//picks returns the ports of the next n backends chosen for the proxy.
+func picks(proxy config.DomainProxy, n int, exclude map[string]bool) []int {
+	var ports []int
+	for i := 0; i < n; i++ {
+		b, ok := pickBackend(proxy, exclude)
+		if !ok {
+			break
+		}
+		ports = append(ports, b.Port)
+	}
+	return ports
+}
+
+func eject(port int) {
+	b := config.Upstream{Host: "127.0.0.1", Port: port, Scheme: "http", Weight: 1}
+	backendStarted(b)
+	backendFinished(b, &net.OpError{Op: "dial", Err: errors.New("connection refused")})
+}
+
+func TestPickBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		proxy   func() config.DomainProxy
+		exclude map[string]bool
+		want    []int
+	}{
+		{"single target", func() config.DomainProxy {
+			return config.DomainProxy{TargetHost: "127.0.0.1", TargetPort: 9400, TargetScheme: "http"}
+		}, nil, []int{9400, 9400}},
+		{"round robin", func() config.DomainProxy { return balancedProxy("", 9401, 9402, 9403) }, nil, []int{9401, 9402, 9403, 9401}},
+		{"ejected backend is skipped", func() config.DomainProxy {
+			proxy := balancedProxy("", 9411, 9412, 9413)
+			eject(9412)
+			return proxy
+		}, nil, []int{9411, 9413, 9411, 9413}},
+		{"every backend ejected", func() config.DomainProxy {
+			proxy := balancedProxy("", 9421, 9422)
+			eject(9421)
+			eject(9422)
+			return proxy
+		}, nil, []int{9421, 9422}},
+		{"excluded backend", func() config.DomainProxy { return balancedProxy("", 9431, 9432) }, map[string]bool{"http://127.0.0.1:9431": true}, []int{9432, 9432}},
+		{"every backend excluded", func() config.DomainProxy { return balancedProxy("", 9441) }, map[string]bool{"http://127.0.0.1:9441": true}, nil},
+		{"least connections", func() config.DomainProxy {
+			proxy := balancedProxy(config.BalanceLeastConnections, 9451, 9452, 9453)
+			backendStarted(proxy.Upstreams[0])
+			backendStarted(proxy.Upstreams[2])
+			return proxy
+		}, nil, []int{9452, 9452}},
+		{"least connections by weight", func() config.DomainProxy {
+			proxy := balancedProxy(config.BalanceLeastConnections, 9461, 9462)
+			proxy.Upstreams[1].Weight = 4
+			for i := 0; i < 2; i++ {
+				backendStarted(proxy.Upstreams[0])
+				backendStarted(proxy.Upstreams[1])
+			}
+			return proxy
+		}, nil, []int{9462}},
+		{"weighted with one backend left", func() config.DomainProxy {
+			proxy := balancedProxy(config.BalanceWeighted, 9471, 9472)
+			proxy.Upstreams[0].Weight = 5
+			eject(9471)
+			return proxy
+		}, nil, []int{9472, 9472}},
+	}
+	for _, test := range tests {
+		if got := picks(test.proxy(), len(test.want), test.exclude); !samePorts(got, test.want) {
+			t.Errorf("%s: picked %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func samePorts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBackendEjection(t *testing.T) {
+	b := balancedProxy("", 9480).Upstreams[0]
+	dialErr := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantEject time.Duration //Approximate ejection from now, or 0 if it should not be ejected.
+	}{
+		{"first connection error", dialErr, baseEjectTime},
+		{"second connection error doubles it", dialErr, 2 * baseEjectTime},
+		{"other errors do not eject", errors.New("read: connection reset by peer"), 0},
+		{"success resets the failures", nil, 0},
+		{"connection error after success", dialErr, baseEjectTime},
+	}
+	for _, test := range tests {
+		stateOf(b).EjectedUntil = time.Time{}
+		backendStarted(b)
+		backendFinished(b, test.err)
+		ejectFor := time.Until(stateOf(b).EjectedUntil)
+		if test.wantEject == 0 && ejectFor > 0 {
+			t.Errorf("%s: ejected for %s, want not ejected", test.name, ejectFor)
+		}
+		if test.wantEject != 0 && (ejectFor > test.wantEject || ejectFor < test.wantEject-time.Second) {
+			t.Errorf("%s: ejected for %s, want %s", test.name, ejectFor, test.wantEject)
+		}
+	}
+	if st := stateOf(b); st.Active != 0 || st.Requests != len(tests) || st.Errors != 4 {
+		t.Errorf("state = %+v, want no active requests, %d requests and 4 errors", *st, len(tests))
+	}
+
+	for i := 0; i < 20; i++ {
+		backendStarted(b)
+		backendFinished(b, dialErr)
+	}
+	if ejectFor := time.Until(stateOf(b).EjectedUntil); ejectFor > maxEjectTime {
+		t.Errorf("ejected for %s, want at most %s", ejectFor, maxEjectTime)
+	}
+	if health, _ := BackendHealth(b); !strings.HasPrefix(health, "ejected for") {
+		t.Errorf("BackendHealth() = %q, want an ejected backend", health)
+	}
+}
+
+func TestCanRetry(t *testing.T) {
+	tests := []struct {
+		method string
+		body   string
+		want   bool
+	}{
+		{"GET", "", true},
+		{"HEAD", "", true},
+		{"OPTIONS", "", true},
+		{"GET", "a body", false},
+		{"POST", "", false},
+		{"PUT", "", false},
+		{"DELETE", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "http://app.example.com/", strings.NewReader(test.body))
+		if test.body == "" {
+			r = httptest.NewRequest(test.method, "http://app.example.com/", nil)
+		}
+		if got := canRetry(r); got != test.want {
+			t.Errorf("canRetry(%s with body %q) = %v, want %v", test.method, test.body, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package webproxy
+
+import (
+	"net"
+	"net/http"
+	"pushtart/authlimit"
+	"pushtart/config"
+	"pushtart/util"
+	"strings"
+)
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, cidr := range config.All().Web.TrustedProxies {
+		if util.AddressInRange(ip, cidr) {
+			return true
+		}
+	}
+	return false
+}
+
+//clientAddress returns the address of the client which made a request. If the request came from a trusted proxy,
+//X-Forwarded-For is followed back (right to left) until an address which is not a trusted proxy is found.
+func clientAddress(r *http.Request) string {
+	addr := authlimit.Host(r.RemoteAddr)
+	ip := net.ParseIP(addr)
+	if ip == nil || !isTrustedProxy(ip) {
+		return addr
+	}
+
+	hops := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil { //missing or malformed - the last trusted proxy is the best we know
+			break
+		}
+		addr, ip = hop.String(), hop
+		if !isTrustedProxy(ip) {
+			break
+		}
+	}
+	return addr
+}
+
+//addressDenied returns true if addr matches any IP_DENY rule of the proxy.
+func addressDenied(proxyEntry config.DomainProxy, addr string) bool {
+	ip := net.ParseIP(addr)
+	for _, rule := range proxyEntry.AuthRules {
+		if rule.RuleType == "IP_DENY" && util.AddressInRange(ip, rule.CIDR) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package webproxy
+
+import (
+	"net/http/httptest"
+	"pushtart/config"
+	"testing"
+)
+
+func TestClientAddress(t *testing.T) {
+	config.All().Web.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}
+	defer func() { config.All().Web.TrustedProxies = nil }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		want       string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"spoofed XFF from untrusted peer", "203.0.113.5:4000", []string{"198.51.100.7"}, "203.0.113.5"},
+		{"trusted proxy without XFF", "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"one trusted hop", "10.0.0.2:4000", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"multi-hop chain through trusted proxies", "10.0.0.2:4000", []string{"198.51.100.7, 192.168.1.1, 10.1.2.3"}, "198.51.100.7"},
+		{"multiple XFF headers", "10.0.0.2:4000", []string{"198.51.100.7", "10.1.2.3"}, "198.51.100.7"},
+		{"client-supplied entries left of the first untrusted hop are ignored", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.7, 10.1.2.3"}, "198.51.100.7"},
+		{"untrusted hop within the chain", "10.0.0.2:4000", []string{"198.51.100.7, 203.0.113.9"}, "203.0.113.9"},
+		{"single trusted address, not its range", "192.168.1.2:4000", []string{"198.51.100.7"}, "192.168.1.2"},
+		{"malformed hop stops at the last trusted proxy", "10.0.0.2:4000", []string{"198.51.100.7, not-an-ip"}, "10.0.0.2"},
+		{"only trusted proxies", "10.0.0.2:4000", []string{"10.9.9.9"}, "10.9.9.9"},
+		{"IPv6 trusted proxy", "[fd00::1]:4000", []string{"2001:db8::5"}, "2001:db8::5"},
+		{"IPv6 untrusted peer", "[2001:db8::9]:4000", []string{"2001:db8::5"}, "2001:db8::9"},
+		{"IPv6 chain", "[fd00::1]:4000", []string{"2001:db8::5, fd12::7"}, "2001:db8::5"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://example.test/", nil)
+		r.RemoteAddr = test.remoteAddr
+		for _, h := range test.xff {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientAddress(r); got != test.want {
+			t.Errorf("%s: clientAddress() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthorizedAddressRules(t *testing.T) {
+	proxyEntry := config.DomainProxy{AuthRules: []config.AuthorizationRule{
+		{RuleType: "IP_DENY", CIDR: "10.0.5.0/24"},
+		{RuleType: "IP_DENY", CIDR: "2001:db8:bad::/48"},
+		{RuleType: "IP_ALLOW", CIDR: "10.0.0.0/8"},
+		{RuleType: "IP_ALLOW", CIDR: "2001:db8::/32"},
+		{RuleType: "USR_DENY", Username: "mallory"},
+		{RuleType: "USR_ALLOW", Username: "alice"},
+	}}
+
+	tests := []struct {
+		name          string
+		address       string
+		username      string
+		authenticated bool
+		want          bool
+	}{
+		{"allowed range", "10.1.2.3", "", false, true},
+		{"denied range within an allowed one", "10.0.5.9", "", false, false},
+		{"outside every range", "203.0.113.5", "", false, false},
+		{"IPv6 allowed range", "2001:db8:1::1", "", false, true},
+		{"IPv6 denied range within an allowed one", "2001:db8:bad::1", "", false, false},
+		{"IPv6 outside every range", "2001:db9::1", "", false, false},
+		{"IPv4-mapped IPv6 address", "::ffff:10.1.2.3", "", false, true},
+		{"IP_DENY applies to allowed users", "10.0.5.9", "alice", true, false},
+		{"allowed user from outside the ranges", "203.0.113.5", "alice", true, true},
+		{"USR_DENY takes precedence over IP_ALLOW", "10.1.2.3", "mallory", true, false},
+		{"unauthenticated username is not trusted", "203.0.113.5", "alice", false, false},
+	}
+	for _, test := range tests {
+		if got := authorized(proxyEntry, test.username, test.authenticated, test.address); got != test.want {
+			t.Errorf("%s: authorized(%q, %q, %v) = %v, want %v", test.name, test.username, test.address, test.authenticated, got, test.want)
+		}
+	}
+
+	if !authorized(config.DomainProxy{}, "", false, "203.0.113.5") {
+		t.Error("a proxy without auth rules should allow everyone")
+	}
+}
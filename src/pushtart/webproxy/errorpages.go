@@ -0,0 +1,140 @@
+package webproxy
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"pushtart/config"
+	"pushtart/tartmanager"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultRetryAfter = 60 //Seconds clients are asked to wait before retrying 503 responses, unless the proxy sets RetryAfter.
+
+var errNoBackends = errors.New("no backends available")
+
+//errorPageText is the heading and explanation shown on the built-in error pages.
+var errorPageText = map[int][2]string{
+	http.StatusBadGateway:         {"Bad Gateway", "The site is not responding right now. Please try again in a little while."},
+	http.StatusServiceUnavailable: {"Service Unavailable", "The site is not available right now. Please try again in a little while."},
+	http.StatusGatewayTimeout:     {"Gateway Timeout", "The site took too long to respond. Please try again in a little while."},
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Heading}}</title>
+    <style>
+      body { font-family: sans-serif; background: #F0F0F0; color: #333; text-align: center; padding-top: 15vh; }
+      .status { color: #999; }
+      .id { color: #999; font-size: small; margin-top: 4em; }
+    </style>
+  </head>
+  <body>
+    <h1>{{.Heading}}</h1>
+    <p class="status">{{.Status}}</p>
+    <p>{{.Message}}</p>
+    {{if .RequestID}}<p class="id">Request {{.RequestID}}</p>{{end}}
+  </body>
+</html>
+`))
+
+var (
+	errorPageLock  sync.Mutex
+	errorPageCache = map[string][]byte{} //Last contents of custom pages, used while their tart is being deployed.
+)
+
+//inMaintenance returns true if requests covered by the proxy should be answered with the maintenance page - if it is
+//in maintenance mode, or the tart serving it is.
+func inMaintenance(proxyEntry config.DomainProxy) bool {
+	if proxyEntry.Maintenance || tartmanager.InMaintenance(proxyEntry.OwnerTart) {
+		return true
+	}
+	return proxyEntry.Static != nil && tartmanager.InMaintenance(proxyEntry.Static.Tart)
+}
+
+//proxyErrorStatus returns the status to respond with when a request could not be proxied.
+func proxyErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errNoBackends):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+//serveErrorPage responds to a request with an error (or if maintenance is set, maintenance) page. The proxy's custom
+//page for the status is used if it has one, otherwise a built-in one. Clients which do not accept HTML are sent text.
+func serveErrorPage(proxyEntry config.DomainProxy, w http.ResponseWriter, r *http.Request, status int, maintenance bool) {
+	heading, message := errorPageText[status][0], errorPageText[status][1]
+	if maintenance {
+		heading, message = "Down for maintenance", "The site is down for maintenance, and will be back shortly."
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	if status == http.StatusServiceUnavailable {
+		retryAfter := proxyEntry.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = defaultRetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write([]byte(strconv.Itoa(status) + " " + heading + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	pages := []string{strconv.Itoa(status) + ".html"}
+	if maintenance {
+		pages = append([]string{"maintenance.html"}, pages...)
+	}
+	for _, page := range pages {
+		if content, ok := customErrorPage(proxyEntry.ErrorPages, page); ok {
+			w.WriteHeader(status)
+			w.Write(content)
+			return
+		}
+	}
+	w.WriteHeader(status)
+	errorPageTemplate.Execute(w, struct {
+		Heading, Message, Status, RequestID string
+	}{heading, message, strconv.Itoa(status) + " " + http.StatusText(status), w.Header().Get(requestIDHeader)})
+}
+
+//customErrorPage returns the contents of a custom error page. If the page cannot be read while its tart is being
+//deployed, the contents it last had are returned.
+func customErrorPage(pages *config.ErrorPages, page string) ([]byte, bool) {
+	if pages == nil {
+		return nil, false
+	}
+	root := filepath.Join(config.All().DeploymentPath, pages.Tart, filepath.FromSlash(pages.Dir))
+	key := filepath.Join(root, page)
+
+	errorPageLock.Lock()
+	defer errorPageLock.Unlock()
+	if name, _, err := findStaticFile(root, "/"+page); err == nil {
+		if content, err := ioutil.ReadFile(name); err == nil {
+			errorPageCache[key] = content
+			return content, true
+		}
+	}
+	content, ok := errorPageCache[key]
+	if !tartmanager.InMaintenance(pages.Tart) {
+		delete(errorPageCache, key) //the page has been removed
+		return nil, false
+	}
+	return content, ok
+}
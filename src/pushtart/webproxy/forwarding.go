@@ -0,0 +1,60 @@
+package webproxy
+
+import (
+	"net"
+	"net/http"
+	"pushtart/authlimit"
+	"pushtart/util"
+	"strings"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+//identityHeaders are only ever set by pushtart, from the user a request has authenticated as.
+var identityHeaders = []string{"X-auth-username", "X-auth-name"}
+
+//stripIdentityHeaders removes any identity headers sent by the client. Names using underscores are matched too, as
+//many frameworks treat X_auth_username and X-auth-username as the same header.
+func stripIdentityHeaders(req *http.Request) {
+	for name := range req.Header {
+		canonical := http.CanonicalHeaderKey(strings.Replace(name, "_", "-", -1))
+		for _, identityHeader := range identityHeaders {
+			if canonical == http.CanonicalHeaderKey(identityHeader) {
+				req.Header.Del(name)
+			}
+		}
+	}
+}
+
+func fromTrustedProxy(r *http.Request) bool {
+	ip := net.ParseIP(authlimit.Host(r.RemoteAddr))
+	return ip != nil && isTrustedProxy(ip)
+}
+
+//requestID returns the ID of a request - generated, unless a trusted proxy in front of pushtart already gave it one.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); id != "" && len(id) <= 128 && fromTrustedProxy(r) {
+		return id
+	}
+	return util.RandAlphaKey(20)
+}
+
+//setForwardingHeaders sets the X-Forwarded-* headers of a request to be proxied. Values sent by clients are
+//discarded unless they came through a trusted proxy. X-Forwarded-For is appended to by httputil.ReverseProxy itself.
+func setForwardingHeaders(req *http.Request, id string) {
+	trusted := fromTrustedProxy(req)
+	if !trusted {
+		req.Header.Del("X-Forwarded-For")
+	}
+	if !trusted || req.Header.Get("X-Forwarded-Proto") == "" {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if !trusted || req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", req.Host)
+	}
+	req.Header.Set(requestIDHeader, id)
+}
@@ -1,16 +1,18 @@
 package webproxy
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"pushtart/authlimit"
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/user"
+	"pushtart/util"
 	"strconv"
 	"strings"
-	"time"
 )
 
 //routes all requests
@@ -19,21 +21,32 @@ func main(w http.ResponseWriter, r *http.Request) {
 	host := trimHostFieldToJustHostname(r.Host)
 	if host == config.All().Web.DefaultDomain {
 		internalsRouter.ServeHTTP(w, r)
-	} else if isKnownVirtualDomain(host) {
+	} else if domain, proxyEntry, ok := findDomainProxy(host); ok {
 		if config.All().TLS.Enabled && r.TLS == nil && config.All().TLS.ForceRedirect {
 			redir(w, r)
 			return
 		}
-		proxyRequestViaNetwork(config.All().Web.DomainProxies[host], w, r)
+		proxyRequestViaNetwork(domain, proxyEntry, w, r)
 	} else {
 		logging.Warning("httpproxy-main", "Request received for unknown virtual domain: "+host)
 		internalsRouter.ServeHTTP(w, r)
 	}
 }
 
-func isKnownVirtualDomain(host string) bool {
-	_, ok := config.All().Web.DomainProxies[host]
-	return ok
+//findDomainProxy returns the domain proxy for host, and the domain it is configured under. An exact match is preferred,
+//otherwise the most specific wildcard entry (like *.apps.example.com) which covers host is used.
+func findDomainProxy(host string) (string, config.DomainProxy, bool) {
+	host = strings.ToLower(host)
+	if proxyEntry, ok := config.All().Web.DomainProxies[host]; ok {
+		return host, proxyEntry, true
+	}
+	for parent := host; strings.Contains(parent, "."); {
+		parent = parent[strings.Index(parent, ".")+1:]
+		if proxyEntry, ok := config.All().Web.DomainProxies["*."+parent]; ok {
+			return "*." + parent, proxyEntry, true
+		}
+	}
+	return "", config.DomainProxy{}, false
 }
 
 func trimHostFieldToJustHostname(hostField string) string {
@@ -58,92 +71,189 @@ func redir(w http.ResponseWriter, req *http.Request) {
 	http.Redirect(w, req, newURL, http.StatusMovedPermanently)
 }
 
-func proxyRequestViaNetwork(proxyEntry config.DomainProxy, w http.ResponseWriter, r *http.Request) {
+func proxyRequestViaNetwork(domain string, proxyEntry config.DomainProxy, w http.ResponseWriter, r *http.Request) {
+	transport := transportFor(domain, proxyEntry)
+	proxyEntry, targetPath, targetRawPath := routeRequest(proxyEntry, r.URL)
+
+	//requests from denied address ranges are refused outright, as logging in would not help
+	address := clientAddress(r)
+	if addressDenied(proxyEntry, address) {
+		w.WriteHeader(403)
+		w.Write([]byte("403 Forbidden\n"))
+		return
+	}
+
+	switch r.URL.Path {
+	case loginPath:
+		loginPage(w, r)
+		return
+	case logoutPath:
+		logout(w, r)
+		return
+	}
+
+	//refuse addresses and users with too many recent failed logins, without checking their password
+	if usr, _, ok := r.BasicAuth(); ok && len(proxyEntry.AuthRules) > 0 {
+		//verification codes are single use, so basic auth (which resends them with every request) cannot work
+		if _, hasSession := sessionUser(r); !hasSession && user.Has2FA(usr) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(401)
+			w.Write([]byte("401 Unauthorized - basic auth is not accepted for users with two-factor authentication, log in at " + loginPath + " instead\n"))
+			return
+		}
+		if err := authlimit.Check(address, usr); err != nil {
+			logging.Warning("httpproxy-auth", "Refused basic auth for "+usr+" from blocked "+address)
+			w.Header().Set("Retry-After", strconv.Itoa(int(authlimit.LockoutTime.Seconds())))
+			w.WriteHeader(429)
+			w.Write([]byte("429 Too Many Requests\n"))
+			return
+		}
+	}
+
+	//Basic auth is only checked for domains with auth rules, as apps on other domains may use it themselves.
+	username, authenticated := requestUser(r, address, len(proxyEntry.AuthRules) > 0)
 
 	//see if request authorized
-	if !authorized(proxyEntry, w, r) {
-		w.Header().Set("WWW-Authenticate", "Basic realm=\"Pushtart:"+config.All().Name+"\"")
-		w.WriteHeader(401)
-		w.Write([]byte("401 Unauthorized\n"))
+	if !authorized(proxyEntry, username, authenticated, address) {
+		denied(w, r, authenticated)
 		return
 	}
 
-	//fetch the user if they exist
-	username, _, ok := r.BasicAuth()
 	var usr config.User
-	if ok && user.Exists(username) {
+	if authenticated {
 		usr = user.Get(username)
 	}
 
+	id := requestID(r)
+	w.Header().Set(requestIDHeader, id)
+	if inMaintenance(proxyEntry) {
+		serveErrorPage(proxyEntry, w, r, http.StatusServiceUnavailable, true)
+		return
+	}
+	if proxyEntry.Static != nil {
+		if config.All().Web.LogAllProxies {
+			logging.Info("httpproxy-main", "Serving static request "+r.Host+targetPath+" from "+proxyEntry.Static.Tart+" (from "+address+", request "+id+")")
+		}
+		serveStatic(*proxyEntry.Static, w, r, targetPath)
+		return
+	}
 	if config.All().Web.LogAllProxies {
-		logging.Info("httpproxy-main", "Proxying request "+r.Host+" -> "+proxyEntry.TargetHost+":"+strconv.Itoa(proxyEntry.TargetPort)+r.URL.Path)
+		logging.Info("httpproxy-main", "Proxying request "+r.Host+" -> "+proxyEntry.TargetHost+":"+strconv.Itoa(proxyEntry.TargetPort)+targetPath+" (from "+address+", request "+id+")")
 	}
 	director := func(req *http.Request) {
+		setForwardingHeaders(req, id)
 		req.URL.Scheme = proxyEntry.TargetScheme
-		req.URL.Path = r.URL.Path
+		req.URL.Path, req.URL.RawPath = targetPath, targetRawPath
 		req.URL.Host = proxyEntry.TargetHost + ":" + strconv.Itoa(proxyEntry.TargetPort)
 		req.Host = proxyEntry.TargetHost
-		if usr.Password != "" {
-			req.Header.Add("X-auth-username", username)
-			req.Header.Add("X-auth-name", usr.Name)
+		stripSessionCookie(req)
+		stripIdentityHeaders(req)
+		if authenticated {
+			req.Header.Set("X-auth-username", username)
+			req.Header.Set("X-auth-name", usr.Name)
 		}
 	}
 
 	prox := httputil.ReverseProxy{
-		Director: director,
-		Transport: &http.Transport{
-			Proxy: func(req *http.Request) (*url.URL, error) {
-				return http.ProxyFromEnvironment(req)
-			},
-			Dial: func(network, addr string) (net.Conn, error) {
-				conn, err := (&net.Dialer{
-					Timeout:   30 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}).Dial(network, addr)
-				if err != nil {
-					logging.Warning("httpproxy-main", "Error connecting to backend: "+err.Error()+" ("+r.Host+")")
-				}
-				return conn, err
-			},
-			TLSHandshakeTimeout: 10 * time.Second,
+		Director:      director,
+		FlushInterval: flushInterval(proxyEntry),
+		Transport:     &balancingTransport{proxyEntry: proxyEntry, idleTimeout: idleTimeout(proxyEntry), base: transport},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			status := proxyErrorStatus(err)
+			logging.Warning("httpproxy-main", "Failed to proxy "+req.Method+" "+r.Host+targetPath+" to "+proxyEntry.TargetHost+":"+strconv.Itoa(proxyEntry.TargetPort)+" (request "+id+"), responding "+strconv.Itoa(status)+": "+err.Error())
+			serveErrorPage(proxyEntry, w, req, status, false)
 		},
 	}
 
+	r = r.WithContext(context.WithValue(r.Context(), dialInfoKey{}, r.Host+", request "+id))
 	prox.ServeHTTP(w, r)
 }
 
-//Grants authorization if there are not auth rules, else if they have a valid basic auth that positively matches
-//one of the rules.
-func authorized(proxyEntry config.DomainProxy, w http.ResponseWriter, r *http.Request) bool {
+//requestUser returns the user a request is logged in as, from its session cookie or (if allowBasic) its basic auth.
+func requestUser(r *http.Request, address string, allowBasic bool) (username string, authenticated bool) {
+	if username, ok := sessionUser(r); ok {
+		return username, true
+	}
+
+	usr, pwd, ok := r.BasicAuth()
+	if !ok || !allowBasic {
+		return "", false
+	}
+	if !user.CheckUserPasswordWeb(usr, pwd) { //Incorrect password for given auth
+		authlimit.Failure(address, usr, "HTTPProxy")
+		return "", false
+	}
+	authlimit.Success(usr)
+	return usr, true
+}
+
+//Grants authorization if there are not auth rules, else if the request comes from an address matching an IP_ALLOW
+//rule, or is authenticated as a user who positively matches one of the rules. DENY rules take precedence over
+//ALLOW rules, and IP_DENY rules apply even to authenticated users.
+func authorized(proxyEntry config.DomainProxy, usr string, authenticated bool, address string) bool {
 	if len(proxyEntry.AuthRules) == 0 {
 		return true
 	}
-	usr, pwd, ok := r.BasicAuth()
+	if addressDenied(proxyEntry, address) {
+		return false
+	}
 
-	//First, check if any DENY rules match
+	//Next, check if any user DENY rules match. Group membership is resolved now, so changes apply immediately.
 	for _, rule := range proxyEntry.AuthRules {
-		if rule.RuleType == "USR_DENY" {
-			if usr == rule.Username {
-				return false
-			}
+		if !authenticated {
+			break
+		}
+		switch {
+		case rule.RuleType == "USR_DENY" && usr == rule.Username:
+			return false
+		case rule.RuleType == "GRP_DENY" && user.InGroup(usr, rule.Group):
+			return false
 		}
 	}
 
-	if !ok { //No basic Auth specified
-		return false
-	}
-	if !user.CheckUserPasswordWeb(usr, pwd) { //Incorrect password for given auth
-		return false
-	}
+	ip := net.ParseIP(address)
 	for _, rule := range proxyEntry.AuthRules {
 		switch rule.RuleType {
+		case "IP_ALLOW":
+			if util.AddressInRange(ip, rule.CIDR) {
+				return true
+			}
 		case "ALLOW_ANY_USER":
-			return true
+			if authenticated {
+				return true
+			}
 		case "USR_ALLOW":
-			if rule.Username == usr {
+			if authenticated && rule.Username == usr {
+				return true
+			}
+		case "GRP_ALLOW":
+			if authenticated && user.InGroup(usr, rule.Group) {
 				return true
 			}
 		}
 	}
 	return false
 }
+
+//denied responds to a request which is not authorized. Browsers are sent to the login page, while other clients
+//are asked for basic auth. Users who are logged in but not permitted are told so.
+func denied(w http.ResponseWriter, r *http.Request, authenticated bool) {
+	switch {
+	case authenticated:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(403)
+		w.Write([]byte("403 Forbidden - you do not have access to this domain. " + logoutForm + "\n"))
+	case isBrowserRequest(r):
+		http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+	default:
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"Pushtart:"+config.All().Name+"\"")
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+	}
+}
+
+//isBrowserRequest returns true if the request appears to be a page load by a browser, rather than an API client.
+func isBrowserRequest(r *http.Request) bool {
+	_, _, hasBasicAuth := r.BasicAuth()
+	return !hasBasicAuth && (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
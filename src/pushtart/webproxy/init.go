@@ -26,6 +26,9 @@ func initRoutes() {
 	internalsRouter.HandleFunc("/status", statusPage)
 	internalsRouter.HandleFunc("/pubrpc", pubRPCInit().ServeHTTP)
 	internalsRouter.HandleFunc("/rpc", privRPCInit().ServeHTTP)
+	internalsRouter.HandleFunc(loginPath, loginPage)
+	internalsRouter.HandleFunc(logoutPath, logout)
+	initOIDCRoutes()
 	http.HandleFunc("/", main)
 }
 
@@ -37,7 +40,7 @@ func start() {
 	logging.Info("httpproxy-init", "Initialising HTTP server on ", config.All().Web.Listener)
 
 	if config.All().TLS.Enabled {
-		go http.ListenAndServe(config.All().Web.Listener, nil)
+		go (&http.Server{Addr: config.All().Web.Listener, IdleTimeout: serverIdleTimeout}).ListenAndServe()
 		logging.Info("httpproxy-init", "Initialising HTTPS server on ", config.All().TLS.Listener)
 		listener, err := tls.Listen("tcp", config.All().TLS.Listener, config.TLS())
 		if err != nil {
@@ -45,8 +48,8 @@ func start() {
 			return
 		}
 
-		http.Serve(listener, nil)
+		(&http.Server{IdleTimeout: serverIdleTimeout}).Serve(listener)
 	} else {
-		http.ListenAndServe(config.All().Web.Listener, nil)
+		(&http.Server{Addr: config.All().Web.Listener, IdleTimeout: serverIdleTimeout}).ListenAndServe()
 	}
 }
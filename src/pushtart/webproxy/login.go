@@ -0,0 +1,179 @@
+package webproxy
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"pushtart/authlimit"
+	"pushtart/config"
+	"pushtart/logging"
+	"pushtart/user"
+	"strings"
+)
+
+//Paths on every proxied domain which are handled by pushtart, rather than proxied.
+const (
+	loginPath  = "/_pushtart/login"
+	logoutPath = "/_pushtart/logout"
+)
+
+type loginData struct {
+	Name     string
+	Domain   string
+	Next     string
+	Error    string
+	Username string
+	LoggedIn string //User the request already has a session for, if any.
+}
+
+var loginTmpl = template.Must(template.New("login").Parse(loginTemplate))
+
+//safeNext returns next if it is a path on the same domain, or / otherwise, so the login page cannot redirect elsewhere.
+func safeNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+//sameOrigin returns false if a request was sent by a page on another site, going by its Origin header (or Referer, for
+//browsers which do not send Origin). Forms which change the session are refused cross-site, so other sites cannot log
+//visitors in or out. Requests with neither header are not from browsers, so they are allowed.
+func sameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, data loginData) {
+	data.Name = config.All().Name
+	data.Domain = trimHostFieldToJustHostname(r.Host)
+	data.LoggedIn, _ = sessionUser(r)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := loginTmpl.Execute(w, data); err != nil {
+		logging.Error("httpproxy-auth", "Template error: "+err.Error())
+	}
+}
+
+//loginPage shows the login form, and starts a session when valid credentials are posted to it.
+func loginPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderLogin(w, r, http.StatusOK, loginData{Next: safeNext(r.URL.Query().Get("next"))})
+		return
+	}
+
+	username, password, code := r.PostFormValue("username"), r.PostFormValue("password"), r.PostFormValue("code")
+	data := loginData{Next: safeNext(r.PostFormValue("next")), Username: username}
+	if !sameOrigin(r) {
+		logging.Warning("httpproxy-auth", "Refused cross-site login for "+username+" on "+r.Host)
+		data.Error = "Login refused - the form was submitted from another site"
+		renderLogin(w, r, http.StatusForbidden, data)
+		return
+	}
+	address := clientAddress(r)
+	if err := authlimit.Check(address, username); err != nil {
+		logging.Warning("httpproxy-auth", "Refused login for "+username+" from blocked "+address)
+		data.Error = err.Error()
+		renderLogin(w, r, http.StatusTooManyRequests, data)
+		return
+	}
+
+	if user.Has2FA(username) {
+		password += strings.TrimSpace(code)
+	}
+	if !user.CheckUserPasswordWeb(username, password) {
+		authlimit.Failure(address, username, "HTTPProxy login")
+		data.Error = "Incorrect username, password or verification code"
+		renderLogin(w, r, http.StatusUnauthorized, data)
+		return
+	}
+	authlimit.Success(username)
+
+	if err := startSession(w, r, username); err != nil {
+		logging.Error("httpproxy-auth", "Could not start session: "+err.Error())
+		data.Error = "Internal error - could not start session"
+		renderLogin(w, r, http.StatusInternalServerError, data)
+		return
+	}
+	logging.Info("httpproxy-auth", "Started session for "+username+" on "+r.Host)
+	http.Redirect(w, r, data.Next, http.StatusSeeOther)
+}
+
+//logout ends the session of the request, and returns to the login page. Only same-site POSTs are accepted, so links
+//and other sites cannot log users out.
+func logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte("405 Method Not Allowed\n"))
+		return
+	}
+	if !sameOrigin(r) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 Forbidden\n"))
+		return
+	}
+	endSession(w, r)
+	http.Redirect(w, r, loginPath, http.StatusSeeOther)
+}
+
+//logoutForm is a button which logs the user out.
+const logoutForm = `<form class="logout" method="post" action="` + logoutPath + `"><input type="submit" value="Log out"></form>`
+
+var loginTemplate = `
+<html>
+  <head>
+    <title>{{.Name}} - Log in</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+
+  <body>
+  <style>
+    body {
+      font-family: sans-serif;
+    }
+    .main {
+      margin: 60px auto;
+      width: 300px;
+      border: 1px solid #C0C0C0;
+      padding: 15px;
+    }
+    .main input[type=text], .main input[type=password] {
+      width: 100%;
+      margin-bottom: 10px;
+      padding: 5px;
+    }
+    .logout {
+      display: inline;
+    }
+    .error {
+      color: #AA0000;
+    }
+  </style>
+  <div class="main">
+    <h3>{{.Name}}</h3>
+    <p>Log in to continue to {{.Domain}}.</p>
+    {{if .LoggedIn}}<p>You are logged in as {{.LoggedIn}}. ` + logoutForm + `</p>{{end}}
+    {{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+    <form method="post" action="` + loginPath + `">
+      <input type="hidden" name="next" value="{{.Next}}">
+      <input type="text" name="username" placeholder="Username" value="{{.Username}}" autofocus required>
+      <input type="password" name="password" placeholder="Password" required>
+      <input type="text" name="code" placeholder="Verification code (if enabled)" autocomplete="one-time-code" inputmode="numeric">
+      <input type="submit" value="Log in">
+    </form>
+  </div>
+  </body>
+</html>
+`
@@ -0,0 +1,283 @@
+package webproxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"pushtart/config"
+	"pushtart/logging"
+	"pushtart/tartmanager"
+	"pushtart/user"
+	"pushtart/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Paths of the OpenID Connect provider endpoints, on the internals router.
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	oidcAuthorizePath = "/oauth/authorize"
+	oidcTokenPath     = "/oauth/token"
+	oidcUserinfoPath  = "/oauth/userinfo"
+	oidcJWKSPath      = "/oauth/jwks"
+)
+
+const (
+	oidcCodeLifetime  = time.Minute
+	oidcTokenLifetime = time.Hour //Lifetime of access and ID tokens.
+)
+
+//oidcGrant is the user and client an authorization code or access token was issued for.
+type oidcGrant struct {
+	User          string
+	ClientID      string
+	RedirectURI   string
+	Scope         string
+	Nonce         string
+	CodeChallenge string //S256 PKCE challenge, if the client sent one.
+	Expires       time.Time
+}
+
+var (
+	oidcLock   sync.Mutex
+	oidcCodes  = map[string]oidcGrant{}
+	oidcTokens = map[string]oidcGrant{}
+)
+
+//storeGrant saves g under a new random key in store, returning the key. Expired entries are pruned.
+func storeGrant(store map[string]oidcGrant, g oidcGrant) string {
+	oidcLock.Lock()
+	defer oidcLock.Unlock()
+	now := time.Now()
+	for k, existing := range store {
+		if now.After(existing.Expires) {
+			delete(store, k)
+		}
+	}
+	key := util.RandAlphaKey(40)
+	store[key] = g
+	return key
+}
+
+func initOIDCRoutes() {
+	internalsRouter.HandleFunc(oidcDiscoveryPath, oidcDiscovery)
+	internalsRouter.HandleFunc(oidcAuthorizePath, oidcAuthorize)
+	internalsRouter.HandleFunc(oidcTokenPath, oidcToken)
+	internalsRouter.HandleFunc(oidcUserinfoPath, oidcUserinfo)
+	internalsRouter.HandleFunc(oidcJWKSPath, oidcJWKS)
+}
+
+func oidcDiscovery(w http.ResponseWriter, r *http.Request) {
+	issuer := OIDCIssuer()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + oidcAuthorizePath,
+		"token_endpoint":                        issuer + oidcTokenPath,
+		"userinfo_endpoint":                     issuer + oidcUserinfoPath,
+		"jwks_uri":                              issuer + oidcJWKSPath,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "nonce", "name", "preferred_username"},
+	})
+}
+
+//redirectWithParams sends the user back to the client's redirect URI, with the given (non-empty) query parameters added.
+func redirectWithParams(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, _ := url.Parse(redirectURI) //validated when the client was registered
+	q := u.Query()
+	for k, v := range params {
+		if len(v) > 0 && v[0] != "" {
+			q[k] = v
+		}
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+//oidcAuthorize issues an authorization code to the user's session, sending them to the login page first if needed.
+func oidcAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pushURL, client, ok := tartmanager.FindOIDCClient(q.Get("client_id"))
+	if !ok {
+		http.Error(w, "Unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI := q.Get("redirect_uri")
+	if !tartmanager.OIDCClientAllowsRedirect(client, redirectURI) {
+		http.Error(w, "redirect_uri is not registered for this client", http.StatusBadRequest)
+		return
+	}
+
+	//Now the redirect URI is known to be safe, errors are reported to the client.
+	errParams := func(code, description string) url.Values {
+		return url.Values{"error": {code}, "error_description": {description}, "state": {q.Get("state")}}
+	}
+	switch {
+	case q.Get("response_type") != "code":
+		redirectWithParams(w, r, redirectURI, errParams("unsupported_response_type", "Only the code response type is supported"))
+		return
+	case !hasScope(q.Get("scope"), "openid"):
+		redirectWithParams(w, r, redirectURI, errParams("invalid_scope", "The openid scope is required"))
+		return
+	case q.Get("code_challenge") != "" && q.Get("code_challenge_method") != "S256":
+		redirectWithParams(w, r, redirectURI, errParams("invalid_request", "Only the S256 code_challenge_method is supported"))
+		return
+	}
+
+	username, loggedIn := sessionUser(r)
+	if !loggedIn {
+		http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+		return
+	}
+	if !oidcUserAllowed(username, pushURL) {
+		logging.Warning("httpproxy-oidc", "Refused authorization code for "+username+" to client "+client.Label+" of "+pushURL+": no access to the tart")
+		redirectWithParams(w, r, redirectURI, errParams("access_denied", "You do not have access to this application"))
+		return
+	}
+
+	code := storeGrant(oidcCodes, oidcGrant{
+		User:          username,
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Scope:         q.Get("scope"),
+		Nonce:         q.Get("nonce"),
+		CodeChallenge: q.Get("code_challenge"),
+		Expires:       time.Now().Add(oidcCodeLifetime),
+	})
+	logging.Info("httpproxy-oidc", "Issued authorization code for "+username+" to client "+client.Label+" of "+pushURL)
+	redirectWithParams(w, r, redirectURI, url.Values{"code": {code}, "state": {q.Get("state")}})
+}
+
+//oidcUserAllowed returns true if the user may log in to the OpenID Connect clients of the tart: if they may read the
+//tart, or are allowed by the auth rules of a domain proxy the tart owns. Proxies without auth rules do not count, so
+//a tart cannot collect the identity of every user who visits it.
+func oidcUserAllowed(username, pushURL string) bool {
+	if tartmanager.Exists(pushURL) && tartmanager.UserCanReadTart(username, tartmanager.Get(pushURL)) {
+		return true
+	}
+	for _, proxy := range config.All().Web.DomainProxies {
+		if proxy.OwnerTart == pushURL && len(proxy.AuthRules) > 0 && authorized(proxy, username, true, "") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func tokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+//oidcToken exchanges an authorization code for an access token and ID token.
+func oidcToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	clientID, secret, hasBasicAuth := r.BasicAuth()
+	if !hasBasicAuth {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	client, ok := tartmanager.CheckOIDCClient(clientID, secret)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"Pushtart:"+config.All().Name+"\"")
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "Unknown client, or incorrect client secret")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the authorization_code grant type is supported")
+		return
+	}
+
+	//codes may only be used once, even if the exchange fails
+	oidcLock.Lock()
+	grant, ok := oidcCodes[r.PostFormValue("code")]
+	delete(oidcCodes, r.PostFormValue("code"))
+	oidcLock.Unlock()
+
+	switch {
+	case !ok || time.Now().After(grant.Expires) || grant.ClientID != client.ID:
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "Unknown or expired authorization code")
+		return
+	case grant.RedirectURI != r.PostFormValue("redirect_uri"):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	case grant.CodeChallenge != "" && !pkceMatches(grant.CodeChallenge, r.PostFormValue("code_verifier")):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "Incorrect code_verifier")
+		return
+	case !user.Exists(grant.User):
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "User no longer exists")
+		return
+	}
+
+	now := time.Now()
+	claims := userClaims(grant.User)
+	claims["iss"] = OIDCIssuer()
+	claims["aud"] = client.ID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(oidcTokenLifetime).Unix()
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+	idToken, err := signJWT(claims)
+	if err != nil {
+		logging.Error("httpproxy-oidc", "Could not sign ID token: "+err.Error())
+		tokenError(w, http.StatusInternalServerError, "server_error", "Could not sign ID token")
+		return
+	}
+
+	grant.Expires = now.Add(oidcTokenLifetime)
+	accessToken := storeGrant(oidcTokens, grant)
+	w.Header().Set("Pragma", "no-cache")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(oidcTokenLifetime.Seconds()),
+		"scope":        grant.Scope,
+		"id_token":     idToken,
+	})
+}
+
+//pkceMatches returns true if verifier hashes to the S256 challenge (RFC 7636).
+func pkceMatches(challenge, verifier string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	return subtle.ConstantTimeCompare([]byte(base64URL(sum[:])), []byte(challenge)) == 1
+}
+
+func userClaims(username string) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":                username,
+		"preferred_username": username,
+		"name":               user.Get(username).Name,
+	}
+}
+
+//oidcUserinfo returns the claims of the user an access token was issued for.
+func oidcUserinfo(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	oidcLock.Lock()
+	grant, ok := oidcTokens[token]
+	oidcLock.Unlock()
+
+	if !ok || time.Now().After(grant.Expires) || !user.Exists(grant.User) {
+		w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+		tokenError(w, http.StatusUnauthorized, "invalid_token", "Unknown or expired access token")
+		return
+	}
+	writeJSON(w, http.StatusOK, userClaims(grant.User))
+}
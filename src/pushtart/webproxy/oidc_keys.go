@@ -0,0 +1,151 @@
+package webproxy
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"pushtart/config"
+	"pushtart/constants"
+	"pushtart/logging"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	oidcKeyLock sync.Mutex
+	oidcKey     *rsa.PrivateKey
+	oidcKeyID   string
+)
+
+//oidcSigningKey returns the key which signs ID tokens, and its key ID. The key is generated and saved (encrypted) in
+//the configuration if there is not one already.
+func oidcSigningKey() (*rsa.PrivateKey, string, error) {
+	oidcKeyLock.Lock()
+	defer oidcKeyLock.Unlock()
+	if oidcKey != nil {
+		return oidcKey, oidcKeyID, nil
+	}
+
+	var key *rsa.PrivateKey
+	if encrypted := config.All().OIDC.PrivPEM; encrypted != "" {
+		privPEM, err := config.DecryptSecret(encrypted)
+		if err != nil {
+			return nil, "", err
+		}
+		block, _ := pem.Decode([]byte(privPEM))
+		if block == nil {
+			return nil, "", errors.New("OIDC.PrivPEM does not contain a PEM encoded key")
+		}
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, "", err
+		}
+	} else {
+		logging.Info("httpproxy-oidc", "Generating OpenID Connect signing key")
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, constants.RsaKeySize); err != nil {
+			return nil, "", err
+		}
+		privPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		if config.All().OIDC.PrivPEM, err = config.EncryptSecret(string(privPEM)); err != nil {
+			return nil, "", err
+		}
+		config.Flush()
+	}
+
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(pubDER)
+	oidcKey, oidcKeyID = key, hex.EncodeToString(sum[:8])
+	return oidcKey, oidcKeyID, nil
+}
+
+func base64URL(d []byte) string {
+	return base64.RawURLEncoding.EncodeToString(d)
+}
+
+//signJWT returns claims as a JWT, signed with RS256.
+func signJWT(claims map[string]interface{}) (string, error) {
+	key, kid, err := oidcSigningKey()
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64URL(header) + "." + base64URL(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64URL(sig), nil
+}
+
+//oidcJWKS serves the public key which signs ID tokens, as a JSON Web Key Set.
+func oidcJWKS(w http.ResponseWriter, r *http.Request) {
+	key, kid, err := oidcSigningKey()
+	if err != nil {
+		logging.Error("httpproxy-oidc", "Could not load signing key: "+err.Error())
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": kid,
+			"n":   base64URL(key.PublicKey.N.Bytes()),
+			"e":   base64URL(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	})
+}
+
+//OIDCIssuer returns the base URL of the OpenID Connect provider. Unless configured, it is the default domain, with
+//the port of the HTTPS (or if TLS is disabled, HTTP) listener.
+func OIDCIssuer() string {
+	if issuer := config.All().OIDC.Issuer; issuer != "" {
+		return strings.TrimSuffix(issuer, "/")
+	}
+
+	scheme, listener, defaultPort := "http", config.All().Web.Listener, "80"
+	if config.All().TLS.Enabled {
+		scheme, listener, defaultPort = "https", config.All().TLS.Listener, "443"
+	}
+	issuer := scheme + "://" + config.All().Web.DefaultDomain
+	if i := strings.LastIndex(listener, ":"); i >= 0 {
+		if port := listener[i+1:]; port != defaultPort {
+			if _, err := strconv.Atoi(port); err == nil {
+				issuer += ":" + port
+			}
+		}
+	}
+	return issuer
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.Error("httpproxy-oidc", "Could not write response: "+err.Error())
+	}
+}
@@ -0,0 +1,223 @@
+package webproxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pushtart/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	oidcTestHost     = "pt.example.com"
+	oidcTestRedirect = "https://app.example.com/callback"
+	oidcTestSecret   = "client-secret"
+)
+
+func setupOIDC() func() {
+	teardownUsers := setupSessionUsers()
+	config.All().Users["carol"] = config.User{Password: "carol-hash"}
+	config.All().Users["dave"] = config.User{Password: "dave-hash"}
+
+	sum := sha256.Sum256([]byte(oidcTestSecret))
+	config.All().Tarts["/alice/app"] = config.Tart{
+		PushURL: "/alice/app",
+		Owners:  []string{"alice"},
+		Readers: []string{"dave"},
+		OIDCClients: []config.OIDCClient{
+			{ID: "client1", SecretHash: hex.EncodeToString(sum[:]), RedirectURIs: []string{oidcTestRedirect}},
+			{ID: "client2", SecretHash: hex.EncodeToString(sum[:]), RedirectURIs: []string{oidcTestRedirect}},
+		},
+	}
+	config.All().Web.DomainProxies["app.example.com"] = config.DomainProxy{
+		OwnerTart: "/alice/app",
+		AuthRules: []config.AuthorizationRule{{RuleType: "USR_ALLOW", Username: "bob"}},
+	}
+	config.All().Web.DomainProxies["public.example.com"] = config.DomainProxy{OwnerTart: "/alice/app"}
+
+	return func() {
+		teardownUsers()
+		delete(config.All().Tarts, "/alice/app")
+		config.All().Web.DomainProxies = map[string]config.DomainProxy{}
+	}
+}
+
+//authorizeRequest makes an authorization request as username (or without a session, if it is empty).
+func authorizeRequest(t *testing.T, username string, params url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "http://"+oidcTestHost+oidcAuthorizePath+"?"+params.Encode(), nil)
+	if username != "" {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: issueSession(t, oidcTestHost, username)})
+	}
+	w := httptest.NewRecorder()
+	oidcAuthorize(w, r)
+	return w
+}
+
+func authorizeParams(clientID string) url.Values {
+	return url.Values{
+		"client_id":     {clientID},
+		"redirect_uri":  {oidcTestRedirect},
+		"response_type": {"code"},
+		"scope":         {"openid profile"},
+		"state":         {"xyz"},
+	}
+}
+
+//issueCode returns an authorization code for username, with the given extra authorization parameters.
+func issueCode(t *testing.T, username, clientID string, extra url.Values) string {
+	params := authorizeParams(clientID)
+	for k, v := range extra {
+		params[k] = v
+	}
+	w := authorizeRequest(t, username, params)
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("no authorization code issued to %s: %d %s", username, w.Code, w.Header().Get("Location"))
+	}
+	return location.Query().Get("code")
+}
+
+func exchangeCode(form url.Values, secret string) (int, map[string]interface{}) {
+	if form.Get("grant_type") == "" {
+		form.Set("grant_type", "authorization_code")
+	}
+	r := httptest.NewRequest("POST", "http://"+oidcTestHost+oidcTokenPath, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("client1", secret)
+	w := httptest.NewRecorder()
+	oidcToken(w, r)
+	var resp map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+func TestOIDCAuthorize(t *testing.T) {
+	defer setupOIDC()()
+
+	tests := []struct {
+		name      string
+		username  string
+		params    url.Values
+		wantCode  int
+		wantTo    string //Prefix of the redirect location.
+		wantError string //OAuth error sent back to the client, if any.
+	}{
+		{"unknown client", "alice", url.Values{"client_id": {"nope"}, "redirect_uri": {oidcTestRedirect}}, http.StatusBadRequest, "", ""},
+		{"unregistered redirect_uri", "alice", url.Values{"client_id": {"client1"}, "redirect_uri": {"https://evil.example/callback"}}, http.StatusBadRequest, "", ""},
+		{"redirect_uri with extra path", "alice", url.Values{"client_id": {"client1"}, "redirect_uri": {oidcTestRedirect + "/x"}}, http.StatusBadRequest, "", ""},
+		{"not logged in", "", authorizeParams("client1"), http.StatusFound, loginPath + "?next=", ""},
+		{"unsupported response type", "alice", url.Values{"response_type": {"token"}}, http.StatusFound, oidcTestRedirect, "unsupported_response_type"},
+		{"no openid scope", "alice", url.Values{"scope": {"profile"}}, http.StatusFound, oidcTestRedirect, "invalid_scope"},
+		{"plain PKCE", "alice", url.Values{"code_challenge": {"abc"}, "code_challenge_method": {"plain"}}, http.StatusFound, oidcTestRedirect, "invalid_request"},
+		{"owner", "alice", nil, http.StatusFound, oidcTestRedirect, ""},
+		{"reader", "dave", nil, http.StatusFound, oidcTestRedirect, ""},
+		{"allowed by the tart's domain", "bob", nil, http.StatusFound, oidcTestRedirect, ""},
+		{"no access to the tart", "carol", nil, http.StatusFound, oidcTestRedirect, "access_denied"},
+	}
+	for _, test := range tests {
+		params := authorizeParams("client1")
+		for k, v := range test.params {
+			params[k] = v
+		}
+		w := authorizeRequest(t, test.username, params)
+		if w.Code != test.wantCode {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.wantCode)
+			continue
+		}
+		location := w.Header().Get("Location")
+		if !strings.HasPrefix(location, test.wantTo) || (test.wantTo == "" && location != "") {
+			t.Errorf("%s: redirected to %q, want %q", test.name, location, test.wantTo)
+			continue
+		}
+		if !strings.HasPrefix(location, oidcTestRedirect) {
+			continue
+		}
+		q, _ := url.Parse(location)
+		if got := q.Query().Get("error"); got != test.wantError {
+			t.Errorf("%s: error = %q, want %q", test.name, got, test.wantError)
+		}
+		if (q.Query().Get("code") != "") != (test.wantError == "") {
+			t.Errorf("%s: code = %q, want one only without an error", test.name, q.Query().Get("code"))
+		}
+		if q.Query().Get("state") != "xyz" {
+			t.Errorf("%s: state was not returned", test.name)
+		}
+	}
+}
+
+func TestOIDCToken(t *testing.T) {
+	defer setupOIDC()()
+
+	code := issueCode(t, "alice", "client1", nil)
+	status, resp := exchangeCode(url.Values{"code": {code}, "redirect_uri": {oidcTestRedirect}}, oidcTestSecret)
+	if status != http.StatusOK || resp["id_token"] == nil || resp["access_token"] == nil {
+		t.Fatalf("exchange = %d %v, want tokens", status, resp)
+	}
+	r := httptest.NewRequest("GET", "http://"+oidcTestHost+oidcUserinfoPath, nil)
+	r.Header.Set("Authorization", "Bearer "+resp["access_token"].(string))
+	w := httptest.NewRecorder()
+	oidcUserinfo(w, r)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sub":"alice"`) {
+		t.Errorf("userinfo = %d %s, want alice's claims", w.Code, w.Body.String())
+	}
+
+	verifier := "a-code-verifier-which-is-long-enough-for-pkce"
+	sum := sha256.Sum256([]byte(verifier))
+	pkce := url.Values{"code_challenge": {base64URL(sum[:])}, "code_challenge_method": {"S256"}}
+
+	tests := []struct {
+		name       string
+		code       func() string
+		form       url.Values
+		secret     string
+		wantStatus int
+		wantError  string
+	}{
+		{"code reused", func() string { return code }, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"unknown code", func() string { return "nope" }, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"wrong client secret", func() string { return issueCode(t, "alice", "client1", nil) }, nil, "wrong", http.StatusUnauthorized, "invalid_client"},
+		{"code issued to another client", func() string { return issueCode(t, "alice", "client2", nil) }, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"redirect_uri mismatch", func() string { return issueCode(t, "alice", "client1", nil) }, url.Values{"redirect_uri": {oidcTestRedirect + "?x=1"}}, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"redirect_uri missing", func() string { return issueCode(t, "alice", "client1", nil) }, url.Values{"redirect_uri": {""}}, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"wrong grant type", func() string { return issueCode(t, "alice", "client1", nil) }, url.Values{"grant_type": {"password"}}, oidcTestSecret, http.StatusBadRequest, "unsupported_grant_type"},
+		{"PKCE without verifier", func() string { return issueCode(t, "alice", "client1", pkce) }, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"PKCE with wrong verifier", func() string { return issueCode(t, "alice", "client1", pkce) }, url.Values{"code_verifier": {verifier + "x"}}, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"PKCE with verifier", func() string { return issueCode(t, "alice", "client1", pkce) }, url.Values{"code_verifier": {verifier}}, oidcTestSecret, http.StatusOK, ""},
+		{"expired code", func() string {
+			code := issueCode(t, "alice", "client1", nil)
+			oidcLock.Lock()
+			grant := oidcCodes[code]
+			grant.Expires = time.Now().Add(-time.Second)
+			oidcCodes[code] = grant
+			oidcLock.Unlock()
+			return code
+		}, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+		{"user deleted since", func() string {
+			code := issueCode(t, "dave", "client1", nil)
+			delete(config.All().Users, "dave")
+			return code
+		}, nil, oidcTestSecret, http.StatusBadRequest, "invalid_grant"},
+	}
+	for _, test := range tests {
+		form := url.Values{"code": {test.code()}, "redirect_uri": {oidcTestRedirect}}
+		for k, v := range test.form {
+			form[k] = v
+		}
+		status, resp := exchangeCode(form, test.secret)
+		if status != test.wantStatus || (test.wantError != "" && resp["error"] != test.wantError) {
+			t.Errorf("%s: exchange = %d %v, want %d %q", test.name, status, resp["error"], test.wantStatus, test.wantError)
+		}
+	}
+
+	//a code is spent by a failed exchange
+	code = issueCode(t, "alice", "client1", nil)
+	exchangeCode(url.Values{"code": {code}, "redirect_uri": {"https://evil.example/callback"}}, oidcTestSecret)
+	if status, _ := exchangeCode(url.Values{"code": {code}, "redirect_uri": {oidcTestRedirect}}, oidcTestSecret); status == http.StatusOK {
+		t.Error("code was accepted after a failed exchange")
+	}
+}
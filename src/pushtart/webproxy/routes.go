@@ -0,0 +1,76 @@
+package webproxy
+
+import (
+	"net/url"
+	"path"
+	"pushtart/config"
+	"strings"
+)
+
+//cleanRequestPath returns the path of a request with . and .. elements resolved, keeping any trailing slash.
+//Routes are matched against the cleaned path (and it is what gets proxied), so a path like /public/../admin
+//cannot reach one route through the auth rules of another.
+func cleanRequestPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+//prefixMatches returns true if p is the prefix, or is beneath it.
+func prefixMatches(prefix, p string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+//routeRequest returns the proxy settings which apply to a request for reqURL, taking the path routes of the domain
+//into account, along with the path which should be proxied and its escaped form (the URL.RawPath, so escapes such as
+//%2F survive stripping a prefix - empty if the default encoding of the path will do).
+func routeRequest(proxyEntry config.DomainProxy, reqURL *url.URL) (config.DomainProxy, string, string) {
+	if len(proxyEntry.Routes) == 0 {
+		return proxyEntry, reqURL.Path, reqURL.RawPath
+	}
+
+	cleaned := cleanRequestPath(reqURL.Path)
+	for _, route := range proxyEntry.Routes {
+		if !prefixMatches(route.Prefix, cleaned) {
+			continue
+		}
+		proxyEntry.TargetHost, proxyEntry.TargetPort, proxyEntry.TargetScheme = route.TargetHost, route.TargetPort, route.TargetScheme
+		proxyEntry.Upstreams, proxyEntry.Static = nil, nil
+		proxyEntry.OwnerUser, proxyEntry.OwnerTart = route.OwnerUser, route.OwnerTart
+		if len(route.AuthRules) > 0 {
+			proxyEntry.AuthRules = route.AuthRules
+		}
+		stripSegments := 0
+		if route.StripPrefix {
+			prefix := strings.TrimSuffix(route.Prefix, "/")
+			cleaned = "/" + strings.TrimPrefix(strings.TrimPrefix(cleaned, prefix), "/")
+			stripSegments = strings.Count(prefix, "/")
+		}
+		return proxyEntry, cleaned, escapedTargetPath(reqURL.RawPath, cleaned, stripSegments)
+	}
+	return proxyEntry, cleaned, escapedTargetPath(reqURL.RawPath, cleaned, 0)
+}
+
+//escapedTargetPath cleans the escaped path of a request and strips its first stripSegments segments, the same as was
+//done to its decoded path to get target. If the result does not decode to target (say, because an escaped slash was
+//part of the stripped prefix), or the request had no escaped path, "" is returned and target is proxied as is.
+func escapedTargetPath(rawPath, target string, stripSegments int) string {
+	if rawPath == "" {
+		return ""
+	}
+	escaped := cleanRequestPath(rawPath)
+	if stripSegments > 0 {
+		segments := strings.SplitN(escaped, "/", stripSegments+2)
+		escaped = "/"
+		if len(segments) == stripSegments+2 {
+			escaped += segments[stripSegments+1]
+		}
+	}
+	if unescaped, err := url.PathUnescape(escaped); err != nil || unescaped != target {
+		return ""
+	}
+	return escaped
+}
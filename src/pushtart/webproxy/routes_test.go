@@ -0,0 +1,80 @@
+package webproxy
+
+import (
+	"net/url"
+	"pushtart/config"
+	"testing"
+)
+
+func TestRouteRequest(t *testing.T) {
+	proxyEntry := config.DomainProxy{
+		TargetHost: "default",
+		Routes: []config.PathRoute{
+			{Prefix: "/static", TargetHost: "static"},
+			{Prefix: "/api/v1", TargetHost: "v1", StripPrefix: true},
+			{Prefix: "/api", TargetHost: "api", StripPrefix: true},
+			{Prefix: "/files/", TargetHost: "files"},
+			{Prefix: "/public", TargetHost: "public"},
+			{Prefix: "/static/v2", TargetHost: "shadowed"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantHost   string
+		wantPath   string
+		wantEscape string
+	}{
+		{"no route", "/index.html", "default", "/index.html", ""},
+		{"root", "/", "default", "/", ""},
+		{"prefix itself", "/api", "api", "/", ""},
+		{"prefix with trailing slash", "/api/", "api", "/", ""},
+		{"beneath prefix", "/api/users", "api", "/users", ""},
+		{"trailing slash kept", "/api/users/", "api", "/users/", ""},
+		{"prefix is not a string prefix", "/apix", "default", "/apix", ""},
+		{"prefix is not a string prefix beneath", "/apix/users", "default", "/apix/users", ""},
+		{"more specific route listed first", "/api/v1/users", "v1", "/users", ""},
+		{"similar to the more specific route", "/api/v10/users", "api", "/v10/users", ""},
+		{"earlier route wins over a longer later one", "/static/v2/app.js", "static", "/static/v2/app.js", ""},
+		{"route prefix with trailing slash", "/files", "files", "/files", ""},
+		{"not stripped", "/files/a.txt", "files", "/files/a.txt", ""},
+		{"dot segments resolved before matching", "/public/../api/users", "api", "/users", ""},
+		{"dot segments cannot escape root", "/../../api", "api", "/", ""},
+		{"query is not part of the path", "/api/users?next=/api/v1", "api", "/users", ""},
+		{"encoded slash kept when stripping", "/api/a%2Fb", "api", "/a/b", "/a%2Fb"},
+		{"encoded slash kept beneath nested prefix", "/api/v1/repos/x%2Fy/files", "v1", "/repos/x/y/files", "/repos/x%2Fy/files"},
+		{"encoded slash kept when not stripping", "/files/dir%2Fname.txt", "files", "/files/dir/name.txt", "/files/dir%2Fname.txt"},
+		{"encoded slash kept without a route", "/other/a%2Fb", "default", "/other/a/b", "/other/a%2Fb"},
+		{"encoded slash within the prefix", "/api%2Fv1/users", "v1", "/users", ""},
+		{"encoded dot segments are resolved", "/public/%2E%2E/api/x", "api", "/x", ""},
+		{"other escapes kept", "/api/caf%C3%A9%20menu%2F1", "api", "/café menu/1", "/caf%C3%A9%20menu%2F1"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		routed, targetPath, targetRawPath := routeRequest(proxyEntry, u)
+		if routed.TargetHost != test.wantHost || targetPath != test.wantPath || targetRawPath != test.wantEscape {
+			t.Errorf("%s: routeRequest(%q) = %q, %q, %q, want %q, %q, %q", test.name, test.target,
+				routed.TargetHost, targetPath, targetRawPath, test.wantHost, test.wantPath, test.wantEscape)
+		}
+
+		//the request proxied must have the same path, with escapes intact
+		out := url.URL{Path: targetPath, RawPath: targetRawPath}
+		if out.Path != test.wantPath || (test.wantEscape != "" && out.EscapedPath() != test.wantEscape) {
+			t.Errorf("%s: proxied path = %q, want %q", test.name, out.EscapedPath(), test.wantEscape)
+		}
+	}
+}
+
+func TestRouteRequestWithoutRoutes(t *testing.T) {
+	u, err := url.Parse("/a/../b%2Fc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, targetPath, targetRawPath := routeRequest(config.DomainProxy{TargetHost: "default"}, u); targetPath != u.Path || targetRawPath != u.RawPath {
+		t.Errorf("routeRequest() = %q, %q, want the request's path %q, %q unchanged", targetPath, targetRawPath, u.Path, u.RawPath)
+	}
+}
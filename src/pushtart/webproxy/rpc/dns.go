@@ -4,6 +4,8 @@ import (
 	"pushtart/config"
 	"pushtart/dnsserv"
 	"pushtart/logging"
+	"pushtart/user"
+	"strconv"
 
 	"github.com/powerman/rpc-codec/jsonrpc2"
 )
@@ -18,8 +20,10 @@ type ListRecordsResult struct {
 
 // DNSRecord represents a DNS zone that pushtart serves.
 type DNSRecord struct {
-	Address string
-	TTL     int
+	Address   string
+	TTL       int
+	OwnerUser string
+	OwnerTart string
 }
 
 // List constructs a ListRecordsResult and returns it to the RPC caller. The structure contains information about
@@ -27,7 +31,7 @@ type DNSRecord struct {
 func (e *DNSExtension) List(arg map[string]string, result *ListRecordsResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeReadOnly, user.RoleOperator, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] List()")
 	} else {
 		logging.Warning("rpc", "Invalid auth for List()")
@@ -37,8 +41,10 @@ func (e *DNSExtension) List(arg map[string]string, result *ListRecordsResult) er
 	result.A = make(map[string]DNSRecord)
 	for name, obj := range config.All().DNS.ARecord {
 		result.A[name] = DNSRecord{
-			Address: obj.Address,
-			TTL:     int(obj.TTL),
+			Address:   obj.Address,
+			TTL:       int(obj.TTL),
+			OwnerUser: obj.OwnerUser,
+			OwnerTart: obj.OwnerTart,
 		}
 	}
 	return nil
@@ -53,23 +59,27 @@ type SetAArgs struct {
 }
 
 // SetA sets an A record for a specific domain, such that the DNS server will respond with the given address and record TTL.
-func (e *DNSExtension) SetA(arg SetAArgs, result *ArbitrarySuccessResult) error {
+func (e *DNSExtension) SetA(arg SetAArgs, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeDNS, user.RoleAdmin, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] SetA("+arg.Domain+" => "+arg.Address+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for SetA("+arg.Domain+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("DNSExtension.SetA", arg.APIKey, map[string]string{"Domain": arg.Domain, "Address": arg.Address, "TTL": strconv.Itoa(arg.TTL)}, &err)
 
 	if config.All().DNS.ARecord == nil {
 		config.All().DNS.ARecord = map[string]config.ARecord{}
 	}
 
+	existing := config.All().DNS.ARecord[dnsserv.SanitizeDomain(arg.Domain)]
 	config.All().DNS.ARecord[dnsserv.SanitizeDomain(arg.Domain)] = config.ARecord{
-		Address: arg.Address,
-		TTL:     uint32(arg.TTL),
+		Address:   arg.Address,
+		TTL:       uint32(arg.TTL),
+		OwnerUser: existing.OwnerUser,
+		OwnerTart: existing.OwnerTart,
 	}
 	config.Flush()
 	result.Success = true
@@ -77,15 +87,16 @@ func (e *DNSExtension) SetA(arg SetAArgs, result *ArbitrarySuccessResult) error
 }
 
 // DeleteA deletes any DNS A records for the given domain.
-func (e *DNSExtension) DeleteA(arg SetAArgs, result *ArbitrarySuccessResult) error {
+func (e *DNSExtension) DeleteA(arg SetAArgs, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeDNS, user.RoleAdmin, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] DeleteA("+arg.Domain+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for DeleteA("+arg.Domain+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("DNSExtension.DeleteA", arg.APIKey, map[string]string{"Domain": arg.Domain, "Address": arg.Address, "TTL": strconv.Itoa(arg.TTL)}, &err)
 
 	if config.All().DNS.ARecord != nil {
 		delete(config.All().DNS.ARecord, dnsserv.SanitizeDomain(arg.Domain))
@@ -3,12 +3,15 @@ package rpc
 import (
 	"bytes"
 	"errors"
+	"pushtart/audit"
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/sshserv/cmd_registry"
+	"pushtart/user"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/powerman/rpc-codec/jsonrpc2"
 )
@@ -30,16 +33,19 @@ type ListTartsResult struct {
 func (t *Service) ListTarts(arg *AuthenticationArgument, result *ListTartsResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeReadOnly, user.RoleOperator, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] ListTarts()")
 	} else {
 		logging.Warning("rpc", "Invalid auth for ListTarts()")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
 
+	i, _ := findAPIKey(arg.APIKey)
 	result.Tarts = map[string]config.Tart{}
 	for name, t := range config.All().Tarts {
-		result.Tarts[name] = t
+		if config.All().APIKeys[i].CanAccessTart(name) {
+			result.Tarts[name] = t
+		}
 	}
 	return nil
 }
@@ -53,7 +59,7 @@ type ListUsersResult struct {
 func (t *Service) ListUsers(arg *AuthenticationArgument, result *ListUsersResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeAdmin, user.RoleOperator, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] ListUsers()")
 	} else {
 		logging.Warning("rpc", "Invalid auth for ListUsers()")
@@ -64,6 +70,9 @@ func (t *Service) ListUsers(arg *AuthenticationArgument, result *ListUsersResult
 	for name, t := range config.All().Users {
 		temp := t
 		temp.Password = "<nil>"
+		if temp.TOTPSecret != "" {
+			temp.TOTPSecret = "<nil>"
+		}
 		result.Users[name] = temp
 	}
 	return nil
@@ -85,24 +94,66 @@ type RunCommandResult struct {
 func (t *Service) RunCommand(arg *RunCommandArgument, result *RunCommandResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeAdmin, user.RoleDeveloper, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] RunCommand("+arg.Command+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for RunCommand("+arg.Command+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
 
-	if ok, cmd := cmd_registry.Command(arg.Command); ok {
-		buf := new(bytes.Buffer)
-		cmd(arg.Args, buf, "")
-		result.Output = buf.String()
-	} else {
-		return errors.New("Command not found")
+	buf := new(bytes.Buffer)
+	if err := cmd_registry.Run(arg.Command, arg.Args, buf, apiKeyActor(arg.APIKey)); err == cmd_registry.ErrPermissionDenied {
+		logging.Warning("rpc", "["+serviceName+"] Denied RunCommand("+arg.Command+") (requires "+cmd_registry.Role(arg.Command)+")")
+		return jsonrpc2.NewError(403, "Permission denied")
+	} else if err != nil {
+		return err
 	}
+	result.Output = buf.String()
 
 	return nil
 }
 
+//AuditLogArgument represents the parameters passed to the AuditLog RPC. Empty fields match any entry.
+type AuditLogArgument struct {
+	APIKey string
+	User   string
+	Actor  string
+	Source string
+	Action string
+	Tart   string
+	Since  int64 //Unix timestamp.
+	Limit  int
+}
+
+//AuditLogResult represents the result of a successful AuditLog RPC.
+type AuditLogResult struct {
+	Entries []audit.Entry
+}
+
+//AuditLog RPC returns entries from the audit log which match the given filters, oldest first.
+func (t *Service) AuditLog(arg *AuditLogArgument, result *AuditLogResult) error {
+	var serviceName string
+	var ok bool
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeAdmin, user.RoleAdmin, ""); ok {
+		logging.Info("rpc", "["+serviceName+"] AuditLog()")
+	} else {
+		logging.Warning("rpc", "Invalid auth for AuditLog()")
+		return jsonrpc2.NewError(403, "Invalid API key")
+	}
+
+	var err error
+	result.Entries, err = audit.Query(audit.Filter{
+		User:   arg.User,
+		Actor:  arg.Actor,
+		Source: arg.Source,
+		Action: arg.Action,
+		Tart:   arg.Tart,
+		Since:  arg.Since,
+		Limit:  arg.Limit,
+	})
+	return err
+}
+
 //GetConfigValueArgument represents the parameters passed to the GetConfigValue RPC.
 type GetConfigValueArgument struct {
 	APIKey string
@@ -120,7 +171,7 @@ type GetConfigValueResult struct {
 func (t *Service) GetConfigValue(arg *GetConfigValueArgument, result *GetConfigValueResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeAdmin, user.RoleAdmin, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] GetConfigValue("+arg.Field+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for GetConfigValue("+arg.Field+")")
@@ -155,7 +206,7 @@ type SetConfigValueResult struct {
 func (t *Service) SetConfigValue(arg *SetConfigValueArgument, result *SetConfigValueResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeAdmin, user.RoleAdmin, ""); ok {
 		logging.Info("rpc", "["+serviceName+"] SetConfigValue("+arg.Field+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for SetConfigValue("+arg.Field+")")
@@ -163,6 +214,7 @@ func (t *Service) SetConfigValue(arg *SetConfigValueArgument, result *SetConfigV
 	}
 
 	err := setVal(arg.Field, arg.Value, reflect.ValueOf(config.All()).Elem())
+	auditRPC("Service.SetConfigValue", arg.APIKey, map[string]string{"Field": arg.Field, "Value": arg.Value}, &err)
 	if err != nil {
 		result.Error = err.Error()
 		result.Success = false
@@ -238,11 +290,66 @@ func strToVal(in string, template reflect.Value) (reflect.Value, error) {
 	return reflect.ValueOf(nil), errors.New("Don't know how to process " + template.Kind().String())
 }
 
-func checkAuth(key string) (service string, ok bool) {
-	for _, entry := range config.All().APIKeys {
-		if entry.Key == key {
-			return entry.Service, true
+//checkAuth returns the service name of the given API key, and true if the key is valid and unexpired, has the given scope,
+//and may access the given tart. Keys acting as a user additionally require that user to have the given role. Keys restricted
+//to specific tarts may not use admin methods which are not specific to a tart.
+func checkAuth(key, scope, role, tart string) (service string, ok bool) {
+	i, ok := findAPIKey(key)
+	if !ok {
+		return "", false
+	}
+	entry := config.All().APIKeys[i]
+
+	var reason string
+	switch {
+	case entry.Expired():
+		reason = "key has expired"
+	case !entry.HasScope(scope):
+		reason = "key does not have the " + scope + " scope"
+	case tart != "" && !entry.CanAccessTart(tart):
+		reason = "key may not access tart " + tart
+	case tart == "" && scope == config.APIKeyScopeAdmin && len(entry.Tarts) > 0:
+		reason = "key is restricted to specific tarts"
+	case !user.HasRole(entry.Username, role):
+		reason = "key user " + entry.Username + " does not have the " + role + " role"
+	default:
+		config.RecordUsage(func(c *config.Config) {
+			if i < len(c.APIKeys) && c.APIKeys[i].ID == entry.ID { //unless revoked meanwhile
+				c.APIKeys[i].LastUsed = time.Now().Unix()
+			}
+		})
+		return entry.Service, true
+	}
+	logging.Warning("rpc", "["+entry.Service+"] API key "+entry.ID+" refused: "+reason)
+	return entry.Service, false
+}
+
+//findAPIKey returns the index of the given key in config.All().APIKeys. Keys acting as a user which no longer exists are invalid.
+func findAPIKey(key string) (int, bool) {
+	for i, entry := range config.All().APIKeys {
+		if entry.MatchesKey(key) {
+			return i, entry.Username == "" || user.Exists(entry.Username)
 		}
 	}
-	return "", false
+	return -1, false
+}
+
+//apiKeyActor returns the audit log actor for requests made with the given (valid) API key.
+func apiKeyActor(key string) audit.Actor {
+	actor := audit.Actor{Source: audit.SourceRPC}
+	if i, ok := findAPIKey(key); ok {
+		entry := config.All().APIKeys[i]
+		actor.User, actor.Service, actor.KeyID = entry.Username, entry.Service, entry.ID
+	}
+	return actor
+}
+
+//auditRPC records a call to an RPC method which changes something in the audit log. It should be deferred after
+//authentication, with err pointing to the method's (named) error result.
+func auditRPC(method, key string, args map[string]string, err *error) {
+	result := "ok"
+	if *err != nil {
+		result = (*err).Error()
+	}
+	audit.Record(audit.Entry{Actor: apiKeyActor(key), Action: method, Args: args, Result: result})
 }
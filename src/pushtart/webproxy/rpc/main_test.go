@@ -0,0 +1,78 @@
+package rpc
+
+import (
+	"pushtart/config"
+	"pushtart/config/configtest"
+	"pushtart/user"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	configtest.Run(m)
+}
+
+func TestCheckAuth(t *testing.T) {
+	config.All().Users["op"] = config.User{Role: user.RoleOperator}
+	config.All().Users["dev"] = config.User{Role: user.RoleDeveloper}
+	key := func(id string, k config.APIKey) config.APIKey {
+		k.ID, k.Service, k.KeyHash = id, "svc-"+id, config.HashAPIKey(id+"-secret")
+		return k
+	}
+	config.All().APIKeys = []config.APIKey{
+		key("admin", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}}),
+		key("readonly", config.APIKey{Scopes: []string{config.APIKeyScopeReadOnly}}),
+		key("control", config.APIKey{Scopes: []string{config.APIKeyScopeTartControl}, Tarts: []string{"/a/app"}}),
+		key("scoped-admin", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}, Tarts: []string{"/a/app"}}),
+		key("expired", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}, Expires: time.Now().Add(-time.Minute).Unix()}),
+		key("unexpired", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}, Expires: time.Now().Add(time.Hour).Unix()}),
+		key("dev", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}, Username: "dev"}),
+		key("op", config.APIKey{Scopes: []string{config.APIKeyScopeReadOnly}, Username: "op"}),
+		key("deleted", config.APIKey{Scopes: []string{config.APIKeyScopeAdmin}, Username: "gone"}),
+	}
+	defer func() {
+		config.All().APIKeys = nil
+		delete(config.All().Users, "op")
+		delete(config.All().Users, "dev")
+	}()
+
+	tests := []struct {
+		name  string
+		key   string
+		scope string
+		role  string
+		tart  string
+		want  bool
+	}{
+		{"admin key", "admin", config.APIKeyScopeAdmin, user.RoleAdmin, "", true},
+		{"admin scope covers the others", "admin", config.APIKeyScopeDNS, user.RoleDeveloper, "/b/app", true},
+		{"unknown key", "nope", config.APIKeyScopeReadOnly, user.RoleDeveloper, "", false},
+		{"within scope", "readonly", config.APIKeyScopeReadOnly, user.RoleOperator, "", true},
+		{"outside scope", "readonly", config.APIKeyScopeTartControl, user.RoleDeveloper, "/a/app", false},
+		{"allowed tart", "control", config.APIKeyScopeTartControl, user.RoleDeveloper, "/a/app", true},
+		{"tart not on the allowlist", "control", config.APIKeyScopeTartControl, user.RoleDeveloper, "/b/app", false},
+		{"tart-restricted admin key for a tart", "scoped-admin", config.APIKeyScopeAdmin, user.RoleDeveloper, "/a/app", true},
+		{"tart-restricted admin key for a global method", "scoped-admin", config.APIKeyScopeAdmin, user.RoleAdmin, "", false},
+		{"expired", "expired", config.APIKeyScopeReadOnly, user.RoleDeveloper, "", false},
+		{"not yet expired", "unexpired", config.APIKeyScopeReadOnly, user.RoleDeveloper, "", true},
+		{"user has the role", "dev", config.APIKeyScopeAdmin, user.RoleDeveloper, "", true},
+		{"user lacks the role", "dev", config.APIKeyScopeAdmin, user.RoleAdmin, "", false},
+		{"operator user", "op", config.APIKeyScopeReadOnly, user.RoleOperator, "", true},
+		{"user no longer exists", "deleted", config.APIKeyScopeAdmin, user.RoleDeveloper, "", false},
+	}
+	for _, test := range tests {
+		if _, got := checkAuth(test.key+"-secret", test.scope, test.role, test.tart); got != test.want {
+			t.Errorf("%s: checkAuth(%q, %q, %q, %q) = %v, want %v", test.name, test.key, test.scope, test.role, test.tart, got, test.want)
+		}
+	}
+
+	if service, _ := checkAuth("admin-secret", config.APIKeyScopeAdmin, user.RoleAdmin, ""); service != "svc-admin" {
+		t.Errorf("checkAuth() service = %q, want %q", service, "svc-admin")
+	}
+	if config.All().APIKeys[0].LastUsed == 0 {
+		t.Error("checkAuth() did not record when the key was used")
+	}
+	if config.All().APIKeys[4].LastUsed != 0 {
+		t.Error("checkAuth() recorded the use of an expired key")
+	}
+}
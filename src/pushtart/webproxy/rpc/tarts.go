@@ -5,6 +5,7 @@ import (
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/tartmanager"
+	"pushtart/user"
 	"strconv"
 	"strings"
 
@@ -29,7 +30,7 @@ type GetTartResult struct {
 func (t *Tarts) GetTart(arg *GetTartArgument, result *GetTartResult) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeReadOnly, user.RoleOperator, arg.PushURL); ok {
 		logging.Info("rpc", "["+serviceName+"] GetTart("+arg.PushURL+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for GetTart("+arg.PushURL+")")
@@ -49,7 +50,7 @@ func (t *Tarts) GetTart(arg *GetTartArgument, result *GetTartResult) error {
 func (t *Tarts) GetTartStats(arg *GetTartArgument, result *tartmanager.RunMetrics) error {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg.APIKey); ok {
+	if serviceName, ok = checkAuth(arg.APIKey, config.APIKeyScopeReadOnly, user.RoleOperator, arg.PushURL); ok {
 		logging.Info("rpc", "["+serviceName+"] GetTart("+arg.PushURL+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for GetTart("+arg.PushURL+")")
@@ -79,15 +80,16 @@ type ArbitrarySuccessResult struct {
 }
 
 // EnableOutputLogging RPC enables Stdout/Error logging to the main logger for a given tart.
-func (t *Tarts) EnableOutputLogging(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) EnableOutputLogging(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] EnableOutputLogging("+arg["PushURL"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for EnableOutputLogging("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.EnableOutputLogging", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		t := tartmanager.Get(arg["PushURL"])
@@ -106,15 +108,16 @@ func (t *Tarts) EnableOutputLogging(arg map[string]string, result *ArbitrarySucc
 }
 
 // SetName RPC sets the human-readable name for a given tart.
-func (t *Tarts) SetName(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) SetName(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] SetName("+arg["PushURL"]+", "+arg["Name"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for SetName("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.SetName", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		t := tartmanager.Get(arg["PushURL"])
@@ -129,15 +132,16 @@ func (t *Tarts) SetName(arg map[string]string, result *ArbitrarySuccessResult) e
 }
 
 // SetEnv RPC sets a key=value environment variable for the tart.
-func (t *Tarts) SetEnv(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) SetEnv(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] SetEnv("+arg["PushURL"]+", "+arg["Key"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for SetEnv("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.SetEnv", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		t := tartmanager.Get(arg["PushURL"])
@@ -152,15 +156,16 @@ func (t *Tarts) SetEnv(arg map[string]string, result *ArbitrarySuccessResult) er
 }
 
 // DelEnv RPC deletes a key from a tarts environment variable list if it exists.
-func (t *Tarts) DelEnv(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) DelEnv(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] DelEnv("+arg["PushURL"]+", "+arg["Key"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for DelEnv("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.DelEnv", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		t := tartmanager.Get(arg["PushURL"])
@@ -175,15 +180,16 @@ func (t *Tarts) DelEnv(arg map[string]string, result *ArbitrarySuccessResult) er
 }
 
 // Start RPC starts a tart.
-func (t *Tarts) Start(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) Start(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] Start("+arg["PushURL"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for Start("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.Start", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		err := tartmanager.Start(arg["PushURL"])
@@ -197,15 +203,16 @@ func (t *Tarts) Start(arg map[string]string, result *ArbitrarySuccessResult) err
 }
 
 // Stop RPC starts a tart.
-func (t *Tarts) Stop(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) Stop(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] Stop("+arg["PushURL"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for Stop("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.Stop", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		err := tartmanager.Stop(arg["PushURL"])
@@ -219,20 +226,21 @@ func (t *Tarts) Stop(arg map[string]string, result *ArbitrarySuccessResult) erro
 }
 
 // Init RPC creates a tart's metadata without it actually existing yet.
-func (t *Tarts) Init(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) Init(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] Init("+arg["PushURL"]+", "+arg["User"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for Init("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.Init", arg["APIKey"], arg, &err)
 
 	if tartmanager.Exists(arg["PushURL"]) {
 		return errors.New("Tart exists")
 	}
-	err := tartmanager.PreGitRecieve(arg["PushURL"], arg["User"])
+	err = tartmanager.PreGitRecieve(arg["PushURL"], arg["User"])
 	if err != nil {
 		return err
 	}
@@ -242,15 +250,16 @@ func (t *Tarts) Init(arg map[string]string, result *ArbitrarySuccessResult) erro
 }
 
 // AddOwner RPC adds an owner to an existing tart.
-func (t *Tarts) AddOwner(arg map[string]string, result *ArbitrarySuccessResult) error {
+func (t *Tarts) AddOwner(arg map[string]string, result *ArbitrarySuccessResult) (err error) {
 	var serviceName string
 	var ok bool
-	if serviceName, ok = checkAuth(arg["APIKey"]); ok {
+	if serviceName, ok = checkAuth(arg["APIKey"], config.APIKeyScopeTartControl, user.RoleOperator, arg["PushURL"]); ok {
 		logging.Info("rpc", "["+serviceName+"] AddOwner("+arg["PushURL"]+", "+arg["Username"]+")")
 	} else {
 		logging.Warning("rpc", "Invalid auth for AddOwner("+arg["PushURL"]+")")
 		return jsonrpc2.NewError(403, "Invalid API key")
 	}
+	defer auditRPC("Tarts.AddOwner", arg["APIKey"], arg, &err)
 
 	if !tartmanager.Exists(arg["PushURL"]) {
 		return errors.New("Tart does not exist")
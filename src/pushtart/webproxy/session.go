@@ -0,0 +1,143 @@
+package webproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"pushtart/config"
+	"pushtart/logging"
+	"strings"
+	"time"
+)
+
+const sessionCookieName = "pushtart_session"
+const defaultSessionLifetime = 12 * time.Hour
+
+//session is the signed content of a login session cookie.
+type session struct {
+	User    string
+	Scope   string //Domain the session is valid for, including its subdomains if it was issued for SessionCookieDomain.
+	Expires int64  //Unix timestamp.
+	Cred    string //Identifies the password the user logged in with, so changing it ends existing sessions.
+}
+
+func sessionLifetime() time.Duration {
+	if secs := config.All().Web.SessionLifetime; secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	return defaultSessionLifetime
+}
+
+//sessionScope returns the domain a session for host should be valid for, and the Domain attribute its cookie should have.
+func sessionScope(host string) (scope, cookieDomain string) {
+	parent := strings.TrimPrefix(config.All().Web.SessionCookieDomain, ".")
+	if parent != "" && (host == parent || strings.HasSuffix(host, "."+parent)) {
+		return parent, parent
+	}
+	return host, ""
+}
+
+func scopeMatches(scope, host string) bool {
+	return host == scope || (scope == strings.TrimPrefix(config.All().Web.SessionCookieDomain, ".") && strings.HasSuffix(host, "."+scope))
+}
+
+func credTag(username string) string {
+	sum := sha256.Sum256([]byte(username + ":" + config.All().Users[username].Password))
+	return hex.EncodeToString(sum[:8])
+}
+
+//startSession sets a signed session cookie, logging username in to the domain of the request (or SessionCookieDomain).
+func startSession(w http.ResponseWriter, r *http.Request, username string) error {
+	host := trimHostFieldToJustHostname(r.Host)
+	scope, cookieDomain := sessionScope(host)
+	expires := time.Now().Add(sessionLifetime())
+
+	d, err := json.Marshal(session{User: username, Scope: scope, Expires: expires.Unix(), Cred: credTag(username)})
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(d)
+	sig, err := config.SignMessage(payload)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    payload + "." + sig,
+		Path:     "/",
+		Domain:   cookieDomain,
+		Expires:  expires,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+//endSession clears the session cookie of the request.
+func endSession(w http.ResponseWriter, r *http.Request) {
+	_, cookieDomain := sessionScope(trimHostFieldToJustHostname(r.Host))
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		Domain:   cookieDomain,
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+	})
+}
+
+//sessionUser returns the user logged in by the session cookie of the request, if it has a valid one.
+func sessionUser(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	spl := strings.SplitN(cookie.Value, ".", 2)
+	if len(spl) != 2 {
+		return "", false
+	}
+	sig, err := config.SignMessage(spl[0])
+	if err != nil {
+		logging.Error("httpproxy-auth", "Could not verify session: "+err.Error())
+		return "", false
+	}
+	if !hmac.Equal([]byte(sig), []byte(spl[1])) {
+		return "", false
+	}
+
+	d, err := base64.RawURLEncoding.DecodeString(spl[0])
+	if err != nil {
+		return "", false
+	}
+	var s session
+	if err = json.Unmarshal(d, &s); err != nil {
+		return "", false
+	}
+	if time.Now().Unix() > s.Expires || !scopeMatches(s.Scope, trimHostFieldToJustHostname(r.Host)) {
+		return "", false
+	}
+	if _, exists := config.All().Users[s.User]; !exists || s.Cred != credTag(s.User) {
+		return "", false
+	}
+	return s.User, true
+}
+
+//stripSessionCookie removes the session cookie from a request before it is proxied, so apps never see it.
+func stripSessionCookie(req *http.Request) {
+	if _, err := req.Cookie(sessionCookieName); err != nil {
+		return
+	}
+	cookies := req.Cookies()
+	req.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != sessionCookieName {
+			req.AddCookie(c)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package webproxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"pushtart/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+//signedSession returns a session cookie value for s, signed as startSession would.
+func signedSession(t *testing.T, s session) string {
+	d, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(d)
+	sig, err := config.SignMessage(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload + "." + sig
+}
+
+//issueSession logs username in to host, and returns the value of the session cookie set.
+func issueSession(t *testing.T, host, username string) string {
+	w := httptest.NewRecorder()
+	if err := startSession(w, httptest.NewRequest("POST", "http://"+host+loginPath, nil), username); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			return c.Value
+		}
+	}
+	t.Fatal("startSession did not set a session cookie")
+	return ""
+}
+
+func requestWithSession(host, value string) *http.Request {
+	r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: value})
+	return r
+}
+
+func setupSessionUsers() func() {
+	config.All().Users = map[string]config.User{
+		"alice": {Password: "alice-hash"},
+		"bob":   {Password: "bob-hash"},
+	}
+	return func() {
+		config.All().Users = map[string]config.User{}
+		config.All().Web.SessionCookieDomain = ""
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	defer setupSessionUsers()()
+
+	valid := issueSession(t, "app.example.com", "alice")
+	payload, sig := valid[:strings.Index(valid, ".")], valid[strings.Index(valid, ".")+1:]
+	forged, _ := json.Marshal(session{User: "bob", Scope: "app.example.com", Expires: time.Now().Add(time.Hour).Unix(), Cred: credTag("bob")})
+
+	tests := []struct {
+		name   string
+		host   string
+		cookie string
+		want   string
+	}{
+		{"valid", "app.example.com", valid, "alice"},
+		{"valid with port", "app.example.com:8080", valid, "alice"},
+		{"no signature", "app.example.com", payload, ""},
+		{"tampered signature", "app.example.com", payload + "." + strings.Repeat("0", len(sig)), ""},
+		{"tampered payload", "app.example.com", base64.RawURLEncoding.EncodeToString(forged) + "." + sig, ""},
+		{"garbage", "app.example.com", "not-a-session", ""},
+		{"expired", "app.example.com", signedSession(t, session{User: "alice", Scope: "app.example.com", Expires: time.Now().Add(-time.Minute).Unix(), Cred: credTag("alice")}), ""},
+		{"other domain", "other.example.com", valid, ""},
+		{"subdomain of the scope", "sub.app.example.com", valid, ""},
+		{"unknown user", "app.example.com", signedSession(t, session{User: "mallory", Scope: "app.example.com", Expires: time.Now().Add(time.Hour).Unix(), Cred: "x"}), ""},
+	}
+	for _, test := range tests {
+		if got, ok := sessionUser(requestWithSession(test.host, test.cookie)); got != test.want || ok != (test.want != "") {
+			t.Errorf("%s: sessionUser() = %q, %v, want %q", test.name, got, ok, test.want)
+		}
+	}
+
+	if _, ok := sessionUser(httptest.NewRequest("GET", "http://app.example.com/", nil)); ok {
+		t.Error("sessionUser() accepted a request without a session cookie")
+	}
+}
+
+func TestSessionCookieDomain(t *testing.T) {
+	defer setupSessionUsers()()
+	config.All().Web.SessionCookieDomain = ".example.com"
+
+	shared := issueSession(t, "app.example.com", "alice")
+	for host, want := range map[string]bool{
+		"app.example.com":   true,
+		"other.example.com": true,
+		"example.com":       true,
+		"example.org":       false,
+		"badexample.com":    false,
+	} {
+		if _, ok := sessionUser(requestWithSession(host, shared)); ok != want {
+			t.Errorf("session for example.com used on %s: ok = %v, want %v", host, ok, want)
+		}
+	}
+
+	//sessions for domains outside SessionCookieDomain stay scoped to their domain
+	own := issueSession(t, "app.example.org", "alice")
+	if _, ok := sessionUser(requestWithSession("other.example.org", own)); ok {
+		t.Error("session for app.example.org was accepted on other.example.org")
+	}
+}
+
+func TestSessionEndsOnPasswordChange(t *testing.T) {
+	defer setupSessionUsers()()
+
+	cookie := issueSession(t, "app.example.com", "alice")
+	if _, ok := sessionUser(requestWithSession("app.example.com", cookie)); !ok {
+		t.Fatal("new session was not accepted")
+	}
+
+	usr := config.All().Users["alice"]
+	usr.Password = "new-alice-hash"
+	config.All().Users["alice"] = usr
+	if _, ok := sessionUser(requestWithSession("app.example.com", cookie)); ok {
+		t.Error("session was accepted after the password changed")
+	}
+
+	delete(config.All().Users, "alice")
+	if _, ok := sessionUser(requestWithSession("app.example.com", cookie)); ok {
+		t.Error("session was accepted after the user was deleted")
+	}
+}
+
+func TestLoginRefusesCrossSitePosts(t *testing.T) {
+	defer setupSessionUsers()()
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"same origin", map[string]string{"Origin": "https://app.example.com"}, http.StatusUnauthorized},
+		{"same origin referer", map[string]string{"Referer": "https://app.example.com" + loginPath}, http.StatusUnauthorized},
+		{"non-browser client", nil, http.StatusUnauthorized},
+		{"other origin", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+		{"other origin with same referer", map[string]string{"Origin": "https://evil.example", "Referer": "https://app.example.com/"}, http.StatusForbidden},
+		{"other referer", map[string]string{"Referer": "https://evil.example/page"}, http.StatusForbidden},
+		{"opaque origin", map[string]string{"Origin": "null"}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		form := url.Values{"username": {"nobody"}, "password": {"wrong"}}
+		r := httptest.NewRequest("POST", "http://app.example.com"+loginPath, strings.NewReader(form.Encode()))
+		r.RemoteAddr = "192.0.2.1:1234"
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		loginPage(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	defer setupSessionUsers()()
+	cookie := issueSession(t, "app.example.com", "alice")
+
+	tests := []struct {
+		name    string
+		method  string
+		origin  string
+		want    int
+		cleared bool
+	}{
+		{"GET", "GET", "", http.StatusMethodNotAllowed, false},
+		{"cross-site POST", "POST", "https://evil.example", http.StatusForbidden, false},
+		{"same-site POST", "POST", "https://app.example.com", http.StatusSeeOther, true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "http://app.example.com"+logoutPath, nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: cookie})
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		w := httptest.NewRecorder()
+		logout(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: status = %d, want %d", test.name, w.Code, test.want)
+		}
+		cleared := false
+		for _, c := range w.Result().Cookies() {
+			cleared = cleared || (c.Name == sessionCookieName && c.MaxAge < 0)
+		}
+		if cleared != test.cleared {
+			t.Errorf("%s: session cookie cleared = %v, want %v", test.name, cleared, test.cleared)
+		}
+	}
+}
+
+func TestBasicAuthRefusedWith2FA(t *testing.T) {
+	defer setupSessionUsers()()
+	config.All().Users["carol"] = config.User{Password: "carol-hash", TOTPSecret: "encrypted"}
+	proxyEntry := config.DomainProxy{TargetHost: "127.0.0.1", TargetPort: 1, AuthRules: []config.AuthorizationRule{{RuleType: "ALLOW_ANY_USER"}}}
+
+	for i := 0; i < 20; i++ { //more than the failed login limit, as these are not counted
+		r := httptest.NewRequest("GET", "http://app.example.com/", nil)
+		r.RemoteAddr = "192.0.2.2:1234"
+		r.SetBasicAuth("carol", "password123456")
+		w := httptest.NewRecorder()
+		proxyRequestViaNetwork("app.example.com", proxyEntry, w, r)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), loginPath) {
+			t.Fatalf("request %d: got %d %q, want a 401 pointing to the login page", i, w.Code, w.Body.String())
+		}
+		if w.Header().Get("WWW-Authenticate") != "" {
+			t.Fatal("basic auth was offered to a user with two-factor authentication")
+		}
+	}
+
+	//with a session, basic auth credentials are ignored
+	r := requestWithSession("app.example.com", issueSession(t, "app.example.com", "carol"))
+	r.SetBasicAuth("carol", "password123456")
+	if username, ok := requestUser(r, "192.0.2.2", true); !ok || username != "carol" {
+		t.Errorf("requestUser() = %q, %v, want the session's user", username, ok)
+	}
+}
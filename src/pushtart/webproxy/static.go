@@ -0,0 +1,149 @@
+package webproxy
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"pushtart/config"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultStaticMaxAge = time.Hour
+
+//serveStatic serves the file at reqPath from a static site. Directories are served by their index.html, and
+//(if the site is a single-page app) paths without a file extension which match nothing by the top-level index.html.
+func serveStatic(site config.StaticSite, w http.ResponseWriter, r *http.Request, reqPath string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reqPath = cleanRequestPath(reqPath)
+	root := filepath.Join(config.All().DeploymentPath, site.Tart, filepath.FromSlash(site.Dir))
+	name, info, err := findStaticFile(root, reqPath)
+	if os.IsNotExist(err) && site.SPAFallback && path.Ext(reqPath) == "" {
+		name, info, err = findStaticFile(root, "/")
+	}
+	if err == errNeedsSlash {
+		target := reqPath + "/"
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	serveStaticFile(site, w, r, name, info)
+}
+
+var errNeedsSlash = errors.New("directory requested without a trailing slash")
+
+//findStaticFile returns the file within root which serves reqPath - the index.html of a directory. Files which
+//resolve outside root (through symlinks), or have a path element starting with a dot (like .git), are never served.
+func findStaticFile(root, reqPath string) (string, os.FileInfo, error) {
+	name := filepath.Join(root, filepath.FromSlash(reqPath))
+	info, err := statWithin(root, name)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		if !strings.HasSuffix(reqPath, "/") {
+			return "", nil, errNeedsSlash
+		}
+		name = filepath.Join(name, "index.html")
+		if info, err = statWithin(root, name); err != nil {
+			return "", nil, err
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil, os.ErrNotExist
+	}
+	return name, info, nil
+}
+
+//statWithin stats name, returning os.ErrNotExist if it does not resolve to a path beneath root, or any element of
+//that path starts with a dot.
+func statWithin(root, name string) (os.FileInfo, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	realName, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	rel, err := filepath.Rel(realRoot, realName)
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	for _, element := range strings.Split(filepath.ToSlash(rel), "/") {
+		if element != "." && strings.HasPrefix(element, ".") { //also catches .. - a path outside root
+			return nil, os.ErrNotExist
+		}
+	}
+	return os.Stat(realName)
+}
+
+//serveStaticFile serves a file with cache headers, preferring a gzipped copy (name.gz) if the client accepts one.
+//Range and conditional requests are handled by http.ServeContent.
+func serveStaticFile(site config.StaticSite, w http.ResponseWriter, r *http.Request, name string, info os.FileInfo) {
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	servedName := name
+	if acceptsGzip(r) {
+		if gzInfo, err := statWithin(filepath.Dir(name), name+".gz"); err == nil && gzInfo.Mode().IsRegular() {
+			servedName, info = name+".gz", gzInfo
+			w.Header().Set("Content-Encoding", "gzip")
+			if contentType == "" {
+				contentType = "application/octet-stream" //sniffing would see gzip
+			}
+		}
+	}
+	f, err := os.Open(servedName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", `W/"`+strconv.FormatInt(info.Size(), 16)+"-"+strconv.FormatInt(info.ModTime().UnixNano(), 16)+`"`)
+	maxAge := defaultStaticMaxAge
+	if site.MaxAge != 0 {
+		maxAge = time.Duration(site.MaxAge) * time.Second
+	}
+	if maxAge < 0 || strings.HasPrefix(contentType, "text/html") { //HTML is revalidated, so new deploys show up straight away
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(maxAge.Seconds())))
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(encoding, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+		for _, param := range params[1:] {
+			if q := strings.TrimSpace(param); strings.HasPrefix(q, "q=") {
+				weight, err := strconv.ParseFloat(q[2:], 64)
+				return err == nil && weight > 0
+			}
+		}
+		return true
+	}
+	return false
+}
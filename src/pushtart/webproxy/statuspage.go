@@ -52,7 +52,7 @@ func statusPage(w http.ResponseWriter, r *http.Request) {
 	uptime.Get()
 	avg, err := concreteSigar.GetLoadAverage()
 	if err != nil {
-		fmt.Fprintf(w, "Failed to get load average: "+err.Error())
+		fmt.Fprint(w, "Failed to get load average: "+err.Error())
 		return
 	}
 
@@ -85,6 +85,12 @@ func statusPage(w http.ResponseWriter, r *http.Request) {
 			}
 			return m
 		},
+		"backendHealth": func(b config.Upstream) string {
+			if health, ok := BackendHealth(b); ok {
+				return health
+			}
+			return "no requests yet"
+		},
 		"timeformat": func(in uint64) string {
 			t := time.Millisecond * time.Duration(in)
 			s := strconv.Itoa(int(t.Hours())) + " hours, "
@@ -333,6 +339,12 @@ var statusTemplate = `
                 <td>
                   Running: {{boolcolour $value.IsRunning}}<br>
                   PID: {{$value.PID}}<br>
+                  {{if gt $value.ReplicaCount 1}}
+                  Replicas: {{$value.ReplicaCount}}<br>
+                  {{range $value.Instances}}
+                  &nbsp;&nbsp;Running: {{boolcolour .IsRunning}}, PID: {{.PID}}, Port: {{.Port}}<br>
+                  {{end}}
+                  {{end}}
                   Restart on Stop: {{boolcolour $value.RestartOnStop}}<br>
                   Restart Delay Seconds: {{$value.RestartDelaySecs}}<br>
                   Logging Stdout/Stderr: {{boolcolour $value.LogStdout}}<br>
@@ -354,6 +366,38 @@ var statusTemplate = `
       </tr>
 
 
+      <tr>
+        <td>
+          <table class="main">
+            <thead>
+              <tr>
+                <th class="section-header">Domain Proxies</th>
+                <th></th>
+              </tr>
+            </thead>
+            <tbody>
+              {{range $key, $value := .Config.Web.DomainProxies}}
+              <tr>
+                <td>{{$key}}</td>
+                <td>
+                  {{if $value.Maintenance}}<b>Maintenance mode</b><br>{{end}}
+                  {{if $value.Static}}
+                  Static site: {{$value.Static.Tart}}/{{$value.Static.Dir}}{{if $value.Static.SPAFallback}} (single-page app){{end}}<br>
+                  {{else}}
+                  {{if $value.Upstreams}}Balancing: {{if $value.Balance}}{{$value.Balance}}{{else}}round-robin{{end}}<br>{{end}}
+                  {{range $value.Backends}}
+                  {{.Scheme}}://{{.Host}}:{{.Port}} - {{backendHealth .}}<br>
+                  {{end}}
+                  {{end}}
+                </td>
+              </tr>
+              {{end}}
+            </tbody>
+          </table>
+        </td>
+      </tr>
+
+
       <tr>
         <td>
           <table class="main">
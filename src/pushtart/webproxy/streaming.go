@@ -0,0 +1,76 @@
+package webproxy
+
+import (
+	"pushtart/config"
+	"sync"
+	"time"
+)
+
+const (
+	defaultIdleTimeout = 15 * time.Minute //How long a connection to a backend may be idle, unless the proxy sets IdleTimeout.
+	serverIdleTimeout  = 2 * time.Minute  //How long keep-alive connections from clients are kept open between requests.
+)
+
+//flushInterval returns how often responses from the proxy's backend are flushed to the client. Streaming responses
+//(server-sent events, and those without a Content-Length) are flushed as soon as they are written regardless.
+func flushInterval(proxyEntry config.DomainProxy) time.Duration {
+	if proxyEntry.FlushInterval < 0 {
+		return -1 //after every write
+	}
+	return time.Duration(proxyEntry.FlushInterval) * time.Millisecond
+}
+
+//idleTimeout returns how long a connection to the proxy's backend may pass no data before it is closed - zero if it
+//never is.
+func idleTimeout(proxyEntry config.DomainProxy) time.Duration {
+	switch {
+	case proxyEntry.IdleTimeout < 0:
+		return 0
+	case proxyEntry.IdleTimeout == 0:
+		return defaultIdleTimeout
+	}
+	return time.Duration(proxyEntry.IdleTimeout) * time.Second
+}
+
+//idleTimer calls a function once it has not been touched for a while. A nil idleTimer never fires.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	lock    sync.Mutex
+	last    time.Time
+}
+
+func newIdleTimer(timeout time.Duration, onIdle func()) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout, last: time.Now()}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.lock.Lock()
+		remaining := t.timeout - time.Since(t.last)
+		if remaining > 0 { //touched since the timer was set
+			t.timer.Reset(remaining)
+			t.lock.Unlock()
+			return
+		}
+		t.lock.Unlock()
+		onIdle()
+	})
+	return t
+}
+
+//touch records activity. It is cheap enough to call on every read and write.
+func (t *idleTimer) touch() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.last = time.Now()
+	t.lock.Unlock()
+}
+
+func (t *idleTimer) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
@@ -0,0 +1,74 @@
+package webproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"pushtart/config"
+	"pushtart/logging"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	maxIdleConnsPerBackend = 32 //Idle keep-alive connections kept open to each backend.
+	maxIdleConns           = 256
+	idleConnTimeout        = 90 * time.Second
+)
+
+//domainTransport is the transport used for requests to a domain proxy, and the configuration it was built for.
+type domainTransport struct {
+	proxyEntry config.DomainProxy
+	transport  *http.Transport
+}
+
+var (
+	transportLock sync.Mutex
+	transports    = map[string]*domainTransport{} //keyed by domain
+)
+
+//dialInfoKey is the context key of a description of the request a connection to a backend is made for, for logging.
+type dialInfoKey struct{}
+
+//transportFor returns the transport for requests to the given domain proxy, so connections to its backends are kept
+//alive and reused between requests. The transport is replaced if the proxy's configuration has changed.
+func transportFor(domain string, proxyEntry config.DomainProxy) *http.Transport {
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if cached, ok := transports[domain]; ok && reflect.DeepEqual(cached.proxyEntry, proxyEntry) {
+		return cached.transport
+	}
+
+	for d, cached := range transports { //close the pools of replaced and deleted domains
+		if _, exists := config.All().Web.DomainProxies[d]; d == domain || !exists {
+			cached.transport.CloseIdleConnections()
+			delete(transports, d)
+		}
+	}
+	t := newTransport()
+	transports[domain] = &domainTransport{proxyEntry: proxyEntry, transport: t}
+	return t
+}
+
+func newTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				info, _ := ctx.Value(dialInfoKey{}).(string)
+				logging.Warning("httpproxy-main", "Error connecting to backend: "+err.Error()+" ("+info+")")
+			}
+			return conn, err
+		},
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        maxIdleConns,
+		MaxIdleConnsPerHost: maxIdleConnsPerBackend,
+		IdleConnTimeout:     idleConnTimeout,
+	}
+}
@@ -0,0 +1,75 @@
+package webproxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"pushtart/config"
+	"strconv"
+	"testing"
+)
+
+//benchmarkProxy proxies b.N requests to a local backend, using the transport returned by transportFor for each one.
+func benchmarkProxy(b *testing.B, transportFor func(domain string, proxyEntry config.DomainProxy) (http.RoundTripper, func())) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	backendURL, _ := url.Parse(backend.URL)
+	port, _ := strconv.Atoi(backendURL.Port())
+	proxyEntry := config.DomainProxy{TargetHost: backendURL.Hostname(), TargetPort: port, TargetScheme: "http"}
+	config.All().Web.DomainProxies["bench.test"] = proxyEntry
+	defer delete(config.All().Web.DomainProxies, "bench.test")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transport, done := transportFor("bench.test", proxyEntry)
+		prox := httputil.ReverseProxy{
+			Director:  func(req *http.Request) {},
+			Transport: &balancingTransport{proxyEntry: proxyEntry, base: transport},
+		}
+		w := httptest.NewRecorder()
+		prox.ServeHTTP(w, httptest.NewRequest("GET", "http://bench.test/", nil))
+		done()
+		if w.Code != http.StatusOK {
+			b.Fatalf("request %d: got status %d", i, w.Code)
+		}
+		ioutil.ReadAll(w.Body)
+	}
+}
+
+//BenchmarkTransportPerRequest measures proxying with a new transport for every request, as was done before
+//transports were pooled. Its idle connections are closed after each request, so the benchmark does not run out of
+//file descriptors - the old code leaked them instead.
+func BenchmarkTransportPerRequest(b *testing.B) {
+	benchmarkProxy(b, func(domain string, proxyEntry config.DomainProxy) (http.RoundTripper, func()) {
+		t := newTransport()
+		return t, t.CloseIdleConnections
+	})
+}
+
+//BenchmarkPooledTransport measures proxying with the transport kept for the domain by transportFor.
+func BenchmarkPooledTransport(b *testing.B) {
+	benchmarkProxy(b, func(domain string, proxyEntry config.DomainProxy) (http.RoundTripper, func()) {
+		return transportFor(domain, proxyEntry), func() {}
+	})
+}
+
+func TestTransportForReusesAndReplaces(t *testing.T) {
+	proxyEntry := config.DomainProxy{TargetHost: "127.0.0.1", TargetPort: 1, TargetScheme: "http"}
+	config.All().Web.DomainProxies["pool.test"] = proxyEntry
+	defer delete(config.All().Web.DomainProxies, "pool.test")
+
+	first := transportFor("pool.test", proxyEntry)
+	if transportFor("pool.test", proxyEntry) != first {
+		t.Error("transport was not reused for an unchanged proxy")
+	}
+	proxyEntry.TargetPort = 2
+	config.All().Web.DomainProxies["pool.test"] = proxyEntry
+	if transportFor("pool.test", proxyEntry) == first {
+		t.Error("transport was reused after the proxy changed")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"pushtart/config"
+	"pushtart/util"
+	"sort"
+	"strconv"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func addSSHCA(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"label"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart add-ssh-ca --label <label> --key \"<ca-public-key>\"")
+		if w == os.Stdout {
+			fmt.Fprintln(w, "   or: pushtart add-ssh-ca --label <label> --pub-key-file <path-to-ca.pub>")
+		}
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	keyData := []byte(params["key"])
+	if params["pub-key-file"] != "" && w == os.Stdout { //Only read files when invoked from the command line
+		var err error
+		if keyData, err = ioutil.ReadFile(params["pub-key-file"]); err != nil {
+			fmt.Fprintln(w, "Err: "+err.Error())
+			return
+		}
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(keyData)
+	if err != nil {
+		fmt.Fprintln(w, "Err: Could not parse public key: "+err.Error())
+		return
+	}
+	fingerprint := util.SSHKeyFingerprint(pubKey.Marshal())
+	if _, exists := findSSHCA(fingerprint); exists {
+		fmt.Fprintln(w, "Err: That CA has already been added")
+		return
+	}
+
+	config.All().SSH.CertAuthorities = append(config.All().SSH.CertAuthorities, config.SSHCertAuthority{
+		Label:       params["label"],
+		Fingerprint: fingerprint,
+		PubKey:      string(ssh.MarshalAuthorizedKey(pubKey)),
+	})
+	config.Flush()
+	fmt.Fprintln(w, "Added CA "+fingerprint)
+}
+
+func removeSSHCA(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"ca"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart remove-ssh-ca --ca <ca-fingerprint>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	i, exists := findSSHCA(params["ca"])
+	if !exists {
+		fmt.Fprintln(w, "Err: No CA with that fingerprint")
+		return
+	}
+	cas := config.All().SSH.CertAuthorities
+	config.All().SSH.CertAuthorities = append(cas[:i], cas[i+1:]...)
+	config.Flush()
+}
+
+func listSSHCAs(params map[string]string, w io.Writer, user string) {
+	for _, ca := range config.All().SSH.CertAuthorities {
+		fmt.Fprintln(w, ca.Fingerprint+" ("+ca.Label+")")
+
+		var principals []string
+		for principal := range ca.PrincipalMap {
+			principals = append(principals, principal)
+		}
+		sort.Strings(principals)
+		for _, principal := range principals {
+			fmt.Fprintln(w, "\tprincipal "+principal+" => user "+ca.PrincipalMap[principal])
+		}
+		for _, serial := range ca.RevokedSerials {
+			fmt.Fprintln(w, "\trevoked serial "+strconv.FormatUint(serial, 10))
+		}
+	}
+	for _, fingerprint := range config.All().SSH.RevokedKeys {
+		fmt.Fprintln(w, "Revoked key: "+fingerprint)
+	}
+}
+
+func mapSSHCAPrincipal(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"ca", "principal"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart ssh-ca-map-principal --ca <ca-fingerprint> --principal <principal> [--username <username>]")
+		fmt.Fprintln(w, "\tOmit --username to remove the mapping. Unmapped principals are treated as usernames.")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	i, exists := findSSHCA(params["ca"])
+	if !exists {
+		fmt.Fprintln(w, "Err: No CA with that fingerprint")
+		return
+	}
+	ca := &config.All().SSH.CertAuthorities[i]
+	if params["username"] == "" {
+		delete(ca.PrincipalMap, params["principal"])
+	} else {
+		if ca.PrincipalMap == nil {
+			ca.PrincipalMap = map[string]string{}
+		}
+		ca.PrincipalMap[params["principal"]] = params["username"]
+	}
+	config.Flush()
+}
+
+func revokeSSHCert(params map[string]string, w io.Writer, user string) {
+	switch {
+	case params["key-fingerprint"] != "":
+		config.All().SSH.RevokedKeys = append(config.All().SSH.RevokedKeys, params["key-fingerprint"])
+
+	case params["ca"] != "" && params["serial"] != "":
+		i, exists := findSSHCA(params["ca"])
+		if !exists {
+			fmt.Fprintln(w, "Err: No CA with that fingerprint")
+			return
+		}
+		serial, err := strconv.ParseUint(params["serial"], 10, 64)
+		if err != nil {
+			fmt.Fprintln(w, "Err: could not read value for serial. Did you provide an integer?")
+			return
+		}
+		ca := &config.All().SSH.CertAuthorities[i]
+		ca.RevokedSerials = append(ca.RevokedSerials, serial)
+
+	default:
+		fmt.Fprintln(w, "USAGE: pushtart revoke-ssh-cert --ca <ca-fingerprint> --serial <certificate-serial>")
+		fmt.Fprintln(w, "   or: pushtart revoke-ssh-cert --key-fingerprint <fingerprint-of-certified-key>")
+		return
+	}
+	config.Flush()
+}
+
+func findSSHCA(fingerprint string) (int, bool) {
+	for i, ca := range config.All().SSH.CertAuthorities {
+		if ca.Fingerprint == fingerprint {
+			return i, true
+		}
+	}
+	return -1, false
+}
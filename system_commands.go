@@ -4,10 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"pushtart/audit"
+	"pushtart/authlimit"
 	"pushtart/config"
 	"pushtart/logging"
 	"pushtart/util"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -133,32 +136,168 @@ func strToVal(in string, template reflect.Value) (reflect.Value, error) {
 }
 
 func generateAPIKey(params map[string]string, w io.Writer, user string) {
-	if missingFields := checkHasFields([]string{"service"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart generate-api-key --service <service-name>")
+	if missingFields := checkHasFields([]string{"service", "scopes"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart generate-api-key --service <service-name> --scopes <scope>[,<scope>...] [--tarts <pushURL>[,<pushURL>...]] [--expires <duration>] [--username <username>]")
+		fmt.Fprintln(w, "\tScopes: "+strings.Join(config.APIKeyScopes, ", "))
 		printMissingFields(missingFields, w)
 		return
 	}
+	if _, exists := config.All().Users[params["username"]]; params["username"] != "" && !exists {
+		fmt.Fprintln(w, "Err: user does not exist")
+		return
+	}
 
-	var newAPIKeyList []struct {
-		Service string
-		Key     string
+	scopes := splitList(params["scopes"])
+	for _, scope := range scopes {
+		if !config.ValidAPIKeyScope(scope) {
+			fmt.Fprintln(w, "Err: invalid scope '"+scope+"' - must be one of "+strings.Join(config.APIKeyScopes, ", "))
+			return
+		}
 	}
-	for _, entry := range config.All().APIKeys {
-		if entry.Service != params["service"] {
-			newAPIKeyList = append(newAPIKeyList, entry)
+
+	now := time.Now()
+	var expires int64
+	if params["expires"] != "" {
+		d, err := time.ParseDuration(params["expires"])
+		if err != nil || d <= 0 {
+			fmt.Fprintln(w, "Err: --expires must be a positive duration, such as 720h")
+			return
 		}
+		expires = now.Add(d).Unix()
 	}
 
-	k := util.RandAlphaKey(16)
-	newAPIKeyList = append(newAPIKeyList, struct {
-		Service string
-		Key     string
-	}{
-		Service: params["service"],
-		Key:     k,
-	})
+	k := util.RandAlphaKey(32)
+	entry := config.APIKey{
+		ID:       util.RandAlphaKey(8),
+		Service:  params["service"],
+		KeyHash:  config.HashAPIKey(k),
+		Username: params["username"],
+		Scopes:   scopes,
+		Tarts:    splitList(params["tarts"]),
+		Created:  now.Unix(),
+		Expires:  expires,
+	}
+	config.All().APIKeys = append(config.All().APIKeys, entry)
+	config.Flush()
 
+	fmt.Fprintln(w, "Generated key "+entry.ID+" - this is the only time the key will be shown:")
 	fmt.Fprintln(w, k)
-	config.All().APIKeys = newAPIKeyList
-	config.Flush()
+}
+
+func listAPIKeys(params map[string]string, w io.Writer, user string) {
+	for _, key := range config.All().APIKeys {
+		fmt.Fprint(w, key.ID+" ("+key.Service+"): scopes ["+strings.Join(key.Scopes, ", ")+"]")
+		if len(key.Tarts) > 0 {
+			fmt.Fprint(w, " tarts ["+strings.Join(key.Tarts, ", ")+"]")
+		}
+		if key.Username != "" {
+			fmt.Fprint(w, " as user "+key.Username)
+		}
+		fmt.Fprintln(w)
+
+		created, lastUsed, expires := "unknown", "never", "never"
+		if key.Created != 0 {
+			created = time.Unix(key.Created, 0).Format(time.ANSIC)
+		}
+		if key.LastUsed != 0 {
+			lastUsed = time.Unix(key.LastUsed, 0).Format(time.ANSIC)
+		}
+		if key.Expires != 0 {
+			expires = time.Unix(key.Expires, 0).Format(time.ANSIC)
+			if key.Expired() {
+				expires += " (EXPIRED)"
+			}
+		}
+		fmt.Fprintln(w, "\tcreated "+created+", expires "+expires+", last used "+lastUsed)
+	}
+}
+
+func revokeAPIKey(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"id"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart revoke-api-key --id <key-id>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	for i, key := range config.All().APIKeys {
+		if key.ID == params["id"] {
+			config.All().APIKeys = append(config.All().APIKeys[:i], config.All().APIKeys[i+1:]...)
+			config.Flush()
+			fmt.Fprintln(w, "Revoked key "+key.ID+" ("+key.Service+")")
+			return
+		}
+	}
+	fmt.Fprintln(w, "Err: No API key with that ID")
+}
+
+func auditLog(params map[string]string, w io.Writer, user string) {
+	filter := audit.Filter{
+		User:   params["user"],
+		Actor:  params["actor"],
+		Source: params["source"],
+		Action: params["action"],
+		Tart:   params["tart"],
+		Limit:  50,
+	}
+	if params["since"] != "" {
+		d, err := time.ParseDuration(params["since"])
+		if err != nil {
+			fmt.Fprintln(w, "Err: --since must be a duration, such as 24h")
+			return
+		}
+		filter.Since = time.Now().Add(-d).Unix()
+	}
+	if params["limit"] != "" {
+		var err error
+		if filter.Limit, err = strconv.Atoi(params["limit"]); err != nil {
+			fmt.Fprintln(w, "Err parsing limit: "+err.Error())
+			return
+		}
+	}
+
+	entries, err := audit.Query(filter)
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	for _, e := range entries {
+		var args []string
+		for name, value := range e.Args {
+			args = append(args, "--"+name+" "+strconv.Quote(value))
+		}
+		sort.Strings(args)
+		fmt.Fprintln(w, time.Unix(e.Time, 0).Format(time.ANSIC), "["+e.Actor.Source+"]", e.Actor.String()+":", e.Action, strings.Join(args, " "), "=>", e.Result)
+	}
+}
+
+func listAuthBlocks(params map[string]string, w io.Writer, user string) {
+	for _, b := range authlimit.List() {
+		status := "not blocked"
+		if b.Blocked() {
+			status = "BLOCKED until " + b.LockedUntil.Format(time.ANSIC)
+		}
+		fmt.Fprintln(w, b.Kind+" "+b.Key+": "+strconv.Itoa(b.Failures)+" failures, last "+b.LastFailure.Format(time.ANSIC)+" - "+status)
+	}
+}
+
+func clearAuthBlock(params map[string]string, w io.Writer, user string) {
+	switch {
+	case params["all"] != "":
+		authlimit.ClearAll()
+		fmt.Fprintln(w, "Cleared all failures and blocks")
+	case params["address"] != "":
+		if !authlimit.Clear(authlimit.KindAddress, params["address"]) {
+			fmt.Fprintln(w, "Err: No failures recorded for that address")
+			return
+		}
+		fmt.Fprintln(w, "Cleared "+params["address"])
+	case params["username"] != "":
+		if !authlimit.Clear(authlimit.KindUsername, params["username"]) {
+			fmt.Fprintln(w, "Err: No failures recorded for that username")
+			return
+		}
+		fmt.Fprintln(w, "Cleared "+params["username"])
+	default:
+		fmt.Fprintln(w, "USAGE: clear-auth-block --address <ip-address> | --username <username> | --all yes")
+	}
 }
@@ -3,28 +3,72 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path"
 	"pushtart/config"
 	"pushtart/tartmanager"
+	"pushtart/util"
+	"pushtart/webproxy"
 	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func listTarts(params map[string]string, w io.Writer, user string) {
 	for pushURL, tart := range config.All().Tarts {
+		if !tartmanager.UserCanReadTart(user, tart) {
+			continue
+		}
 		fmt.Fprint(w, tart.Name+" ("+pushURL+"): ")
-		if tart.IsRunning {
+		if tart.ReplicaCount() > 1 {
+			running, pids := 0, []string{}
+			for i := 0; i < tart.ReplicaCount(); i++ {
+				if tart.Replica(i).IsRunning {
+					running++
+					pids = append(pids, strconv.Itoa(tart.Replica(i).PID))
+				}
+			}
+			if running > 0 {
+				fmt.Fprint(w, "Running "+strconv.Itoa(running)+"/"+strconv.Itoa(tart.ReplicaCount())+" replicas (PIDs "+strings.Join(pids, ", ")+") ")
+			} else {
+				fmt.Fprint(w, "Stopped ("+strconv.Itoa(tart.ReplicaCount())+" replicas). ")
+			}
+		} else if tart.IsRunning {
 			fmt.Fprint(w, "Running (PID "+strconv.Itoa(tart.PID)+") ")
 		} else {
 			fmt.Fprint(w, "Stopped. ")
 		}
 
+		if tart.Maintenance {
+			fmt.Fprint(w, "[MAINTENANCE MODE] ")
+		}
 		if tart.LogStdout {
 			fmt.Fprintln(w, "[Stdout -> Log is ENABLED]")
 		} else {
 			fmt.Fprintln(w, "[Stdout -> Log is disabled]")
 		}
 
+		if tart.Port != 0 {
+			ports := []string{strconv.Itoa(tart.Port)}
+			for i := 1; i < tart.ReplicaCount(); i++ {
+				if tart.Replica(i).Port != 0 {
+					ports = append(ports, strconv.Itoa(tart.Replica(i).Port))
+				}
+			}
+			if len(ports) > 1 {
+				fmt.Fprint(w, "\tPorts "+strings.Join(ports, ", "))
+			} else {
+				fmt.Fprint(w, "\tPort "+ports[0])
+			}
+			if tart.Subdomain != "" {
+				fmt.Fprint(w, ", proxied at "+tart.Subdomain)
+			}
+			fmt.Fprintln(w)
+		}
+
 		if len(tart.Env) > 0 {
 			for _, env := range tart.Env {
 				fmt.Fprintln(w, "\t"+env)
@@ -53,14 +97,46 @@ func newTart(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: pushURLs must start with a '/' character.")
 		return
 	}
+	if err := tartmanager.ValidatePushURL(params["tart"]); err != nil {
+		fmt.Fprintln(w, "Err:", err)
+		return
+	}
+	if err := tartmanager.CheckPushURLNamespace(params["tart"], user); err != nil {
+		fmt.Fprintln(w, "Err:", err)
+		return
+	}
 
 	err := tartmanager.PreGitRecieve(params["tart"], user)
 	if err != nil {
 		fmt.Fprintln(w, "Err:", err)
+		return
 	}
 	tartmanager.New(params["tart"], user)
 }
 
+func deleteTart(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart delete-tart --tart <pushURL> [--delete-owned yes/no]")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	err := tartmanager.Delete(tart.PushURL, strings.ToLower(params["delete-owned"]) == "yes")
+	if err != nil {
+		fmt.Fprintln(w, "Err:", err)
+	}
+}
+
 func digestTartConfig(params map[string]string, w io.Writer, user string) {
 	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 {
 		fmt.Fprintln(w, "USAGE: pushtart digest-tartconfig --tart <pushURL>")
@@ -73,11 +149,16 @@ func digestTartConfig(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
-	err := tartmanager.ExecuteCommandFile(path.Join(config.All().DeploymentPath, tart.PushURL, "tartconfig"), tart.PushURL, &w)
+
+	//tartconfigs are never run as the console superuser - when invoked from the console, run as an owner.
+	if user == "" && len(tart.Owners) > 0 {
+		user = tart.Owners[0]
+	}
+	err := tartmanager.ExecuteCommandFile(path.Join(config.All().DeploymentPath, tart.PushURL, "tartconfig"), tart.PushURL, user, &w)
 	if err != nil {
 		fmt.Fprintln(w, "Err:", err)
 	}
@@ -95,7 +176,7 @@ func startTart(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
@@ -117,7 +198,7 @@ func stopTart(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
@@ -139,7 +220,7 @@ func findTart(tartName string) (bool, config.Tart) {
 
 func editTart(params map[string]string, w io.Writer, user string) {
 	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart edit-tart --tart <pushURL> [--name <name>] [--set-env \"<env-name>=<env-value>\"] [--delete-env <env-name>] [--log-stdout yes/no]")
+		fmt.Fprintln(w, "USAGE: pushtart edit-tart --tart <pushURL> [--name <name>] [--set-env \"<env-name>=<env-value>\"] [--delete-env <env-name>] [--log-stdout yes/no] [--replicas <n>] [--maintenance yes/no]")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -150,11 +231,24 @@ func editTart(params map[string]string, w io.Writer, user string) {
 		return
 	}
 
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
 
+	replicas := 0
+	if params["replicas"] != "" {
+		var err error
+		if replicas, err = strconv.Atoi(params["replicas"]); err != nil || replicas < 1 {
+			fmt.Fprintln(w, "Err: replicas must be a number, 1 or more")
+			return
+		}
+		if replicas > tartmanager.MaxDeveloperReplicas && !isOperator(user) {
+			fmt.Fprintln(w, "Err: Only operators may run more than "+strconv.Itoa(tartmanager.MaxDeveloperReplicas)+" replicas")
+			return
+		}
+	}
+
 	if params["name"] != "" {
 		tart.Name = params["name"]
 	}
@@ -176,6 +270,17 @@ func editTart(params map[string]string, w io.Writer, user string) {
 	}
 
 	tartmanager.Save(tart.PushURL, tart)
+	if params["maintenance"] != "" {
+		tartmanager.SetMaintenance(tart.PushURL, strings.ToLower(params["maintenance"]) == "yes")
+	}
+	if params["name"] != "" { //the tart's subdomain follows its name
+		tartmanager.SyncSubdomain(tart.PushURL)
+	}
+	if replicas > 0 {
+		if err := tartmanager.SetReplicas(tart.PushURL, replicas); err != nil {
+			fmt.Fprintln(w, "Err:", err)
+		}
+	}
 }
 
 func tartRestartMode(params map[string]string, w io.Writer, user string) {
@@ -190,7 +295,7 @@ func tartRestartMode(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
@@ -232,8 +337,9 @@ func setEnv(envList []string, envString, delString string) []string {
 }
 
 func tartAddOwner(params map[string]string, w io.Writer, user string) {
-	if missingFields := checkHasFields([]string{"tart", "username"}, params); len(missingFields) > 0 {
+	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 || (params["username"] == "") == (params["group"] == "") {
 		fmt.Fprintln(w, "USAGE: pushtart tart-add-owner --tart <pushURL> --username <username>")
+		fmt.Fprintln(w, "   or: pushtart tart-add-owner --tart <pushURL> --group <group>")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -243,11 +349,31 @@ func tartAddOwner(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
 
+	if group := params["group"]; group != "" {
+		if _, ok := config.All().Groups[group]; !ok {
+			fmt.Fprintln(w, "Err: group does not exist")
+			return
+		}
+		for _, owner := range tart.OwnerGroups {
+			if owner == group {
+				fmt.Fprintln(w, "Err: "+group+" is already set as an owner group.")
+				return
+			}
+		}
+		tart.OwnerGroups = append(tart.OwnerGroups, group)
+		tartmanager.Save(tart.PushURL, tart)
+		return
+	}
+
+	if _, ok := config.All().Users[params["username"]]; !ok {
+		fmt.Fprintln(w, "Err: user does not exist")
+		return
+	}
 	for _, owner := range tart.Owners {
 		if owner == params["username"] {
 			fmt.Fprintln(w, "Err: "+params["username"]+" is already set as an owner.")
@@ -260,8 +386,9 @@ func tartAddOwner(params map[string]string, w io.Writer, user string) {
 }
 
 func tartRemoveOwner(params map[string]string, w io.Writer, user string) {
-	if missingFields := checkHasFields([]string{"tart", "username"}, params); len(missingFields) > 0 {
+	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 || (params["username"] == "") == (params["group"] == "") {
 		fmt.Fprintln(w, "USAGE: pushtart tart-remove-owner --tart <pushURL> --username <username>")
+		fmt.Fprintln(w, "   or: pushtart tart-remove-owner --tart <pushURL> --group <group>")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -271,11 +398,30 @@ func tartRemoveOwner(params map[string]string, w io.Writer, user string) {
 		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
 		return
 	}
-	if user != "" && !tartmanager.UserHasTartOwnership(user, tart.Owners) {
+	if !tartmanager.UserCanManageTart(user, tart) {
 		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
 		return
 	}
 
+	if group := params["group"]; group != "" {
+		didFind := false
+		temp := []string{}
+		for _, owner := range tart.OwnerGroups {
+			if owner == group {
+				didFind = true
+			} else {
+				temp = append(temp, owner)
+			}
+		}
+		if !didFind {
+			fmt.Fprintln(w, "Err: That group is not a tart owner.")
+			return
+		}
+		tart.OwnerGroups = temp
+		tartmanager.Save(tart.PushURL, tart)
+		return
+	}
+
 	didFind := false
 	temp := []string{}
 	for _, owner := range tart.Owners {
@@ -299,3 +445,268 @@ func tartRemoveOwner(params map[string]string, w io.Writer, user string) {
 	tart.Owners = temp
 	tartmanager.Save(tart.PushURL, tart)
 }
+
+func tartAddReader(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "username"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-add-reader --tart <pushURL> --username <username>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	if _, ok := config.All().Users[params["username"]]; !ok {
+		fmt.Fprintln(w, "Err: user does not exist")
+		return
+	}
+	for _, reader := range tart.Readers {
+		if reader == params["username"] {
+			fmt.Fprintln(w, "Err: "+params["username"]+" is already set as a reader.")
+			return
+		}
+	}
+
+	tart.Readers = append(tart.Readers, params["username"])
+	tartmanager.Save(tart.PushURL, tart)
+}
+
+func tartRemoveReader(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "username"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-remove-reader --tart <pushURL> --username <username>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	didFind := false
+	temp := []string{}
+	for _, reader := range tart.Readers {
+		if reader == params["username"] {
+			didFind = true
+		} else {
+			temp = append(temp, reader)
+		}
+	}
+
+	if !didFind {
+		fmt.Fprintln(w, "Err: That user is not a tart reader.")
+		return
+	}
+
+	tart.Readers = temp
+	tartmanager.Save(tart.PushURL, tart)
+}
+
+func tartAddDeployKey(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "label"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-add-deploy-key --tart <pushURL> --label <label> --key \"<ssh-public-key>\"")
+		if w == os.Stdout {
+			fmt.Fprintln(w, "   or: pushtart tart-add-deploy-key --tart <pushURL> --label <label> --pub-key-file <path-to-.pub-file>")
+		}
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	keyData := []byte(params["key"])
+	if params["pub-key-file"] != "" && w == os.Stdout { //Only read files when invoked from the command line
+		var err error
+		if keyData, err = ioutil.ReadFile(params["pub-key-file"]); err != nil {
+			fmt.Fprintln(w, "Err: "+err.Error())
+			return
+		}
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(keyData)
+	if err != nil {
+		fmt.Fprintln(w, "Err: Could not parse public key: "+err.Error())
+		return
+	}
+	fingerprint := util.SSHKeyFingerprint(pubKey.Marshal())
+	if owningTart, _, inUse := tartmanager.FindDeployKey(fingerprint); inUse {
+		fmt.Fprintln(w, "Err: That key is already a deploy key for "+owningTart)
+		return
+	}
+
+	tart.DeployKeys = append(tart.DeployKeys, config.DeployKey{
+		Label:       params["label"],
+		Fingerprint: fingerprint,
+		PubKey:      string(ssh.MarshalAuthorizedKey(pubKey)),
+		Added:       time.Now().Unix(),
+	})
+	tartmanager.Save(tart.PushURL, tart)
+	fmt.Fprintln(w, "Added deploy key "+fingerprint)
+}
+
+func tartRemoveDeployKey(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "fingerprint"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-remove-deploy-key --tart <pushURL> --fingerprint <fingerprint>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	didFind := false
+	temp := []config.DeployKey{}
+	for _, key := range tart.DeployKeys {
+		if key.Fingerprint == params["fingerprint"] {
+			didFind = true
+		} else {
+			temp = append(temp, key)
+		}
+	}
+
+	if !didFind {
+		fmt.Fprintln(w, "Err: No deploy key with that fingerprint.")
+		return
+	}
+
+	tart.DeployKeys = temp
+	tartmanager.Save(tart.PushURL, tart)
+}
+
+func tartListDeployKeys(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-ls-deploy-keys --tart <pushURL>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	for _, key := range tart.DeployKeys {
+		fmt.Fprintln(w, key.Fingerprint+" ("+key.Label+"), added "+time.Unix(key.Added, 0).Format(time.ANSIC))
+	}
+}
+
+func tartAddOIDCClient(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "label", "redirect-uri"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-add-oidc-client --tart <pushURL> --label <label> --redirect-uri <uri>[,<uri>...]")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	client, secret, err := tartmanager.NewOIDCClient(params["label"], splitList(params["redirect-uri"]))
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	tart.OIDCClients = append(tart.OIDCClients, client)
+	tartmanager.Save(tart.PushURL, tart)
+
+	fmt.Fprintln(w, "Issuer: "+webproxy.OIDCIssuer())
+	fmt.Fprintln(w, "Client ID: "+client.ID)
+	fmt.Fprintln(w, "Client secret: "+secret)
+	fmt.Fprintln(w, "The client secret will not be shown again.")
+}
+
+func tartRemoveOIDCClient(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart", "client-id"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-remove-oidc-client --tart <pushURL> --client-id <client-id>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	didFind := false
+	temp := []config.OIDCClient{}
+	for _, client := range tart.OIDCClients {
+		if client.ID == params["client-id"] {
+			didFind = true
+		} else {
+			temp = append(temp, client)
+		}
+	}
+
+	if !didFind {
+		fmt.Fprintln(w, "Err: No OIDC client with that ID.")
+		return
+	}
+
+	tart.OIDCClients = temp
+	tartmanager.Save(tart.PushURL, tart)
+}
+
+func tartListOIDCClients(params map[string]string, w io.Writer, user string) {
+	if missingFields := checkHasFields([]string{"tart"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart tart-ls-oidc-clients --tart <pushURL>")
+		printMissingFields(missingFields, w)
+		return
+	}
+
+	exists, tart := findTart(params["tart"])
+	if !exists {
+		fmt.Fprintln(w, "Err: A tart by that pushURL does not exist")
+		return
+	}
+	if !tartmanager.UserCanManageTart(user, tart) {
+		fmt.Fprintln(w, "Err: You ("+user+") are not an owner of the specified tart")
+		return
+	}
+
+	for _, client := range tart.OIDCClients {
+		fmt.Fprintln(w, client.ID+" ("+client.Label+"), added "+time.Unix(client.Added, 0).Format(time.ANSIC)+" - redirects to "+strings.Join(client.RedirectURIs, ", "))
+	}
+}
@@ -10,7 +10,9 @@ import (
 	"pushtart/logging"
 	"pushtart/user"
 	"pushtart/util"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func saveUser(username string, usr config.User, params map[string]string) {
@@ -36,12 +38,39 @@ func saveUser(username string, usr config.User, params map[string]string) {
 		}
 	}
 
+	if _, exists = params["role"]; exists {
+		usr.Role = params["role"]
+	}
+
 	user.Save(username, usr)
 }
 
+//checkIsAdmin returns false and writes an error if the calling user is not an admin.
+func checkIsAdmin(w io.Writer, callingUser string) bool {
+	if !user.HasRole(callingUser, user.RoleAdmin) {
+		fmt.Fprintln(w, "Err: You ("+callingUser+") must be an admin to do that")
+		return false
+	}
+	return true
+}
+
+//isOperator returns true if the user has at least the operator role.
+func isOperator(username string) bool {
+	return user.HasRole(username, user.RoleOperator)
+}
+
+//checkRoleParam returns false and writes an error if a --role was given which is not a valid role.
+func checkRoleParam(params map[string]string, w io.Writer) bool {
+	if role, exists := params["role"]; exists && !user.ValidRole(role) {
+		fmt.Fprintln(w, "Err: role must be one of "+user.RoleAdmin+", "+user.RoleOperator+" or "+user.RoleDeveloper)
+		return false
+	}
+	return true
+}
+
 func editUser(params map[string]string, w io.Writer, callingUser string) {
 	if missingFields := checkHasFields([]string{"username"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart edit-user --username <username> [--config <config file>] [--password <password] [--name <name] [--allow-ssh-password yes/no]")
+		fmt.Fprintln(w, "USAGE: pushtart edit-user --username <username> [--config <config file>] [--password <password] [--name <name] [--allow-ssh-password yes/no] [--role admin/operator/developer]")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -50,30 +79,36 @@ func editUser(params map[string]string, w io.Writer, callingUser string) {
 		fmt.Fprintln(w, "Err: user does not exist")
 		return
 	}
+	if !checkRoleParam(params, w) {
+		return
+	}
 
 	usr := user.Get(params["username"])
 	saveUser(params["username"], usr, params)
 }
 
 func listUser(params map[string]string, w io.Writer, callingUser string) {
-	for username, user := range config.All().Users {
-		fmt.Fprint(w, username+" ("+user.Name+"): [")
-		if user.SSHPubKey != "" {
-			fmt.Fprint(w, " sshkey ")
+	for username, usr := range config.All().Users {
+		fmt.Fprint(w, username+" ("+usr.Name+", "+user.Role(username)+"): [")
+		if len(usr.SSHKeys) > 0 {
+			fmt.Fprint(w, " sshkey("+strconv.Itoa(len(usr.SSHKeys))+") ")
 		}
-		if user.Password != "" {
+		if usr.Password != "" {
 			fmt.Fprint(w, " password ")
 		}
-		if user.Password != "" && user.AllowSSHPassword {
+		if usr.Password != "" && usr.AllowSSHPassword {
 			fmt.Fprint(w, " password-ssh ")
 		}
+		if usr.TOTPSecret != "" {
+			fmt.Fprint(w, " 2fa ")
+		}
 		fmt.Fprintln(w, "]")
 	}
 }
 
 func makeUser(params map[string]string, w io.Writer, callingUser string) {
 	if missingFields := checkHasFields([]string{"username"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart make-user --username <username> [--config <config file>] [--password <password] [--name <name] [--allow-ssh-password yes/no]")
+		fmt.Fprintln(w, "USAGE: pushtart make-user --username <username> [--config <config file>] [--password <password] [--name <name] [--allow-ssh-password yes/no] [--role admin/operator/developer]")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -82,6 +117,13 @@ func makeUser(params map[string]string, w io.Writer, callingUser string) {
 		fmt.Fprintln(w, "Err: user already exists")
 		return
 	}
+	if strings.Contains(params["username"], ":") { //reserved for deploy keys
+		fmt.Fprintln(w, "Err: usernames cannot contain ':'")
+		return
+	}
+	if !checkRoleParam(params, w) {
+		return
+	}
 
 	user.New(params["username"])
 	usr := user.Get(params["username"])
@@ -108,7 +150,7 @@ func deleteUser(params map[string]string, w io.Writer, callingUser string) {
 
 func importSSHKey(params map[string]string, w io.Writer, callingUser string) {
 	if missingFields := checkHasFields([]string{"username"}, params); len(missingFields) > 0 {
-		fmt.Fprintln(w, "USAGE: pushtart import-ssh-key --username <username> [--pub-key-file <path-to-.pub-file>]")
+		fmt.Fprintln(w, "USAGE: pushtart import-ssh-key --username <username> [--pub-key-file <path-to-.pub-file>] [--label <label>]")
 		printMissingFields(missingFields, w)
 		return
 	}
@@ -133,7 +175,121 @@ func importSSHKey(params map[string]string, w io.Writer, callingUser string) {
 		return
 	}
 
-	usr := user.Get(params["username"])
-	usr.SSHPubKey = string(b)
-	user.Save(params["username"], usr)
+	added, err := user.AddSSHKeys(params["username"], b, params["label"])
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	for _, key := range added {
+		fmt.Fprintln(w, "Added key "+key.Fingerprint+" ("+key.Label+")")
+	}
+}
+
+//credentialTargetUser returns the user whose SSH keys or 2FA a command should operate on. Users may only manage their own credentials, unless they are an admin.
+func credentialTargetUser(params map[string]string, w io.Writer, callingUser string) (string, bool) {
+	username := params["username"]
+	if username == "" {
+		username = callingUser
+	}
+	if username == "" {
+		fmt.Fprintln(w, "Err: --username must be specified")
+		return "", false
+	}
+	if !user.CanManageCredentials(callingUser, username) {
+		fmt.Fprintln(w, "Err: You ("+callingUser+") may only manage your own SSH keys and 2FA")
+		return "", false
+	}
+	if !user.Exists(username) {
+		fmt.Fprintln(w, "Err: user does not exist")
+		return "", false
+	}
+	return username, true
+}
+
+func listSSHKeys(params map[string]string, w io.Writer, callingUser string) {
+	username, ok := credentialTargetUser(params, w, callingUser)
+	if !ok {
+		return
+	}
+
+	for _, key := range user.Get(username).SSHKeys {
+		lastUsed := "never"
+		if key.LastUsed != 0 {
+			lastUsed = time.Unix(key.LastUsed, 0).Format(time.ANSIC)
+		}
+		fmt.Fprintln(w, key.Fingerprint+" ("+key.Label+"): added "+time.Unix(key.Added, 0).Format(time.ANSIC)+", last used "+lastUsed)
+	}
+}
+
+func addSSHKey(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"key"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart add-ssh-key [--username <username>] --key \"<ssh-public-key>\" [--label <label>]")
+		printMissingFields(missingFields, w)
+		return
+	}
+	username, ok := credentialTargetUser(params, w, callingUser)
+	if !ok {
+		return
+	}
+
+	added, err := user.AddSSHKeys(username, []byte(params["key"]), params["label"])
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	if len(added) == 0 {
+		fmt.Fprintln(w, "Err: That key has already been added")
+	}
+	for _, key := range added {
+		fmt.Fprintln(w, "Added key "+key.Fingerprint+" ("+key.Label+")")
+	}
+}
+
+func removeSSHKey(params map[string]string, w io.Writer, callingUser string) {
+	if missingFields := checkHasFields([]string{"fingerprint"}, params); len(missingFields) > 0 {
+		fmt.Fprintln(w, "USAGE: pushtart remove-ssh-key [--username <username>] --fingerprint <fingerprint>")
+		printMissingFields(missingFields, w)
+		return
+	}
+	username, ok := credentialTargetUser(params, w, callingUser)
+	if !ok {
+		return
+	}
+
+	if err := user.RemoveSSHKey(username, params["fingerprint"]); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+	}
+}
+
+func enable2FA(params map[string]string, w io.Writer, callingUser string) {
+	username, ok := credentialTargetUser(params, w, callingUser)
+	if !ok {
+		return
+	}
+
+	uri, err := user.Enable2FA(username)
+	if err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	fmt.Fprintln(w, "Two-factor authentication enabled for "+username+". Add it to your authenticator app with this provisioning URI:")
+	fmt.Fprintln(w, uri)
+	fmt.Fprintln(w, "From now on, enter the current verification code when logging in to HTTPProxy protected domains, or append it to your password for basic auth.")
+}
+
+func disable2FA(params map[string]string, w io.Writer, callingUser string) {
+	username, ok := credentialTargetUser(params, w, callingUser)
+	if !ok {
+		return
+	}
+	if !user.Has2FA(username) {
+		fmt.Fprintln(w, "Err: Two-factor authentication is not enabled for "+username)
+		return
+	}
+
+	if err := user.Disable2FA(username); err != nil {
+		fmt.Fprintln(w, "Err: "+err.Error())
+		return
+	}
+	fmt.Fprintln(w, "Two-factor authentication disabled for "+username)
 }